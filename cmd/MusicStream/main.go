@@ -8,12 +8,15 @@ import (
 	"os/signal"
 	"path/filepath"
 	"plugin"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/TrungNguyen1909/MusicStream"
+	"github.com/TrungNguyen1909/MusicStream/common"
 	"github.com/TrungNguyen1909/MusicStream/server"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/pkg/errors"
 )
 
 func main() {
@@ -24,6 +27,45 @@ func main() {
 	if defaultSource, ok := os.LookupEnv("DEFAULT_SOURCE"); ok && len(defaultSource) > 0 {
 		config.DefaultMusicSource = defaultSource
 	}
+	if searchFallback, ok := os.LookupEnv("SEARCH_FALLBACK"); ok && searchFallback == "1" {
+		config.SearchFallback = true
+	}
+	if nativeDecoder, ok := os.LookupEnv("NATIVE_DECODER"); ok && nativeDecoder == "1" {
+		config.PreferNativeDecoder = true
+	}
+	if iceServers, ok := os.LookupEnv("ICE_SERVERS"); ok && len(iceServers) > 0 {
+		config.ICEServers = strings.Split(iceServers, ",")
+	}
+	if lyricsProviders, ok := os.LookupEnv("LYRICS_PROVIDERS"); ok && len(lyricsProviders) > 0 {
+		config.LyricsProviders = strings.Split(lyricsProviders, ",")
+	}
+	if lyricsDirs, ok := os.LookupEnv("LYRICS_DIR"); ok && len(lyricsDirs) > 0 {
+		config.LyricsDirs = filepath.SplitList(lyricsDirs)
+	}
+	if lyricsCache, ok := os.LookupEnv("LYRICS_CACHE"); ok && len(lyricsCache) > 0 {
+		config.LyricsCachePath = lyricsCache
+	}
+	if playlistsDir, ok := os.LookupEnv("PLAYLISTS_DIR"); ok && len(playlistsDir) > 0 {
+		config.PlaylistsPath = playlistsDir
+	}
+	if lyricsLanguage, ok := os.LookupEnv("LYRICS_LANGUAGE"); ok && len(lyricsLanguage) > 0 {
+		config.LyricsLanguage = lyricsLanguage
+	}
+	if subsonicUsername, ok := os.LookupEnv("SUBSONIC_SERVER_USERNAME"); ok && len(subsonicUsername) > 0 {
+		config.SubsonicUsername = subsonicUsername
+	}
+	if subsonicPassword, ok := os.LookupEnv("SUBSONIC_SERVER_PASSWORD"); ok && len(subsonicPassword) > 0 {
+		config.SubsonicPassword = subsonicPassword
+	}
+	configFile, ok := os.LookupEnv("CONFIG_FILE")
+	if !ok {
+		configFile = "config.json"
+	}
+	if err := server.LoadConfigFile(configFile, &config); err != nil {
+		if ok || !os.IsNotExist(errors.Cause(err)) {
+			log.Panicf("[main] Failed to load configuration file: %+v", err)
+		}
+	}
 	if mxmUserToken, ok := os.LookupEnv("MUSIXMATCH_USER_TOKEN"); !ok {
 		log.Println("[main] Warning: Musixmatch token not found")
 	} else {
@@ -33,6 +75,14 @@ func main() {
 		}
 	}
 	log.Printf("[main] Intializing MusicStream v%s...", MusicStream.Version)
+	for _, source := range common.RegisteredSources() {
+		client, err := source.NewClient()
+		if err != nil {
+			log.Printf("[main] Failed to initialize source %s: %+v", source.Name, err)
+			continue
+		}
+		config.Sources = append(config.Sources, client)
+	}
 	pluginsPath, err := filepath.Glob("plugins/**/*.plugin")
 	if err != nil {
 		log.Panic("[main] Cannot find any plugins")
@@ -45,6 +95,9 @@ func main() {
 			config.Plugins = append(config.Plugins, p)
 		}
 	}
+	if externalPlugins, ok := os.LookupEnv("EXTERNAL_PLUGINS"); ok && len(externalPlugins) > 0 {
+		config.ExternalPlugins = strings.Split(externalPlugins, ";")
+	}
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
 		port = "8080"
@@ -0,0 +1,6 @@
+//go:build source_csn
+// +build source_csn
+
+package main
+
+import _ "github.com/TrungNguyen1909/MusicStream/sources/csn"
@@ -0,0 +1,6 @@
+//go:build source_directurl
+// +build source_directurl
+
+package main
+
+import _ "github.com/TrungNguyen1909/MusicStream/sources/directurl"
@@ -0,0 +1,6 @@
+//go:build source_podcast
+// +build source_podcast
+
+package main
+
+import _ "github.com/TrungNguyen1909/MusicStream/sources/podcast"
@@ -0,0 +1,6 @@
+//go:build source_subsonic
+// +build source_subsonic
+
+package main
+
+import _ "github.com/TrungNguyen1909/MusicStream/sources/subsonic"
@@ -0,0 +1,6 @@
+//go:build source_youtube
+// +build source_youtube
+
+package main
+
+import _ "github.com/TrungNguyen1909/MusicStream/sources/youtube"
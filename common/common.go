@@ -19,7 +19,12 @@
 package common
 
 import (
+	"fmt"
 	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -38,6 +43,40 @@ type Stream interface {
 	Body() io.ReadCloser
 }
 
+//Container/codec hints for StreamInfo.Codec
+const (
+	CodecMP3    = "mp3"
+	CodecFLAC   = "flac"
+	CodecVorbis = "vorbis"
+	CodecOpus   = "opus"
+	CodecWAV    = "wav"
+	CodecAAC    = "aac"
+	CodecMP4    = "mp4"
+	CodecWebM   = "webm"
+)
+
+//StreamInfo contains optional details about a Stream, zero values mean unknown
+type StreamInfo struct {
+	//Codec is the container/codec of a FFmpegStream, e.g. CodecMP3, used to skip probing
+	Codec string `json:"codec"`
+	//ContentType is the MIME type of the body, used if Codec is empty
+	ContentType string `json:"contentType"`
+	//SampleRate is the sample rate of a RawStream, 48000 if unknown
+	SampleRate int `json:"sampleRate"`
+	//Channels is the number of interleaved s16le channels of a RawStream, 2 if unknown
+	Channels int `json:"channels"`
+	//ContentLength is the size of the body in bytes
+	ContentLength int64 `json:"contentLength"`
+	//Seekable specifies whether the body implements io.Seeker
+	Seekable bool `json:"seekable"`
+}
+
+//StreamWithInfo is a stream that provides details about its body
+type StreamWithInfo interface {
+	Stream
+	Info() StreamInfo
+}
+
 //Track represents a track from any sources
 type Track interface {
 	ID() string
@@ -62,6 +101,51 @@ type TrackWithLyrics interface {
 	GetLyrics() (LyricsResult, error)
 }
 
+//TrackWithTranslatedLyrics is a track that can fetch its own lyrics translated into a language
+type TrackWithTranslatedLyrics interface {
+	TrackWithLyrics
+	GetTranslatedLyrics(language string) (LyricsResult, error)
+}
+
+//TrackWithTranscript is a track whose source can fetch a transcript of it, e.g. a video's subtitles.
+//Transcripts are fetched by their own lyrics provider instead of the track's source lyrics
+type TrackWithTranscript interface {
+	Track
+	//GetTranscript returns the transcript translated into language if there's a translation, the source's default language is used if it's empty
+	GetTranscript(language string) (LyricsResult, error)
+}
+
+//LyricsProvider fetches lyrics for tracks from any sources
+type LyricsProvider interface {
+	Name() string
+	GetLyrics(track Track) (LyricsResult, error)
+}
+
+//TranslatingLyricsProvider is a LyricsProvider that can translate lyrics into a language
+type TranslatingLyricsProvider interface {
+	LyricsProvider
+	GetTranslatedLyrics(track Track, language string) (LyricsResult, error)
+}
+
+//TrackWithResumableStream is a track whose stream can be reopened from a byte offset of its body, e.g. with an HTTP range request
+type TrackWithResumableStream interface {
+	Track
+	StreamFrom(offset int64) (Stream, error)
+}
+
+//TrackWithProgress is a track which remembers how far it was played, e.g. to resume a podcast episode
+type TrackWithProgress interface {
+	Track
+	//SetProgress is called when the track stops playing, with the duration of audio that was streamed and whether it was played to the end
+	SetProgress(played time.Duration, finished bool)
+}
+
+//TrackWithSource is a track that knows the name of its MusicSource
+type TrackWithSource interface {
+	Track
+	Source() string
+}
+
 //TrackMetadata contains essential informations about a track for client
 type TrackMetadata struct {
 	Title      string       `json:"title"`
@@ -76,6 +160,8 @@ type TrackMetadata struct {
 	SpotifyURI string       `json:"spotifyURI"`
 	ID         string       `json:"id"`
 	Href       string       `json:"href"`
+	//Source is the name of the track's MusicSource, if known
+	Source string `json:"source,omitempty"`
 }
 
 //GetMetadata returns a new TrackMetadata created from a provided Track
@@ -92,6 +178,9 @@ func GetMetadata(track Track) (d TrackMetadata) {
 	d.ID = track.ID()
 	d.SpotifyURI = track.SpotifyURI()
 	d.Href = track.Href()
+	if strack, ok := track.(TrackWithSource); ok {
+		d.Source = strack.Source()
+	}
 	return
 }
 
@@ -103,12 +192,31 @@ type LyricsTime struct {
 	Total      float64 `json:"total"`
 }
 
+//NewLyricsTime returns the LyricsTime of total seconds
+func NewLyricsTime(total float64) LyricsTime {
+	hundredths := int(total*100 + 0.5)
+	return LyricsTime{
+		Minutes:    hundredths / 6000,
+		Seconds:    hundredths / 100 % 60,
+		Hundredths: hundredths % 100,
+		Total:      total,
+	}
+}
+
+//LyricsWord is a word or syllable of a synced lyrics line, the concatenation of a line's words is its text
+type LyricsWord struct {
+	Text string     `json:"text"`
+	Time LyricsTime `json:"time"`
+}
+
 //LyricsLine contains informations about a piece of lyrics
 type LyricsLine struct {
 	Text       string     `json:"text"`
 	Translated string     `json:"translated"`
 	Time       LyricsTime `json:"time"`
 	Original   string     `json:"original"`
+	//Words contains the timing of each word of Text, if known
+	Words []LyricsWord `json:"words,omitempty"`
 }
 
 //LyricsResult represents a result of a lyrics query
@@ -124,6 +232,16 @@ func GenerateID() (id string) {
 	return uuid.String()
 }
 
+//NormalizeText lowercases text and removes everything but letters and digits, to compare titles and artists
+func NormalizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
+
 //DefaultTrack represents the metadata will be shown when nothing is playing
 type DefaultTrack struct{}
 
@@ -193,6 +311,57 @@ type MusicSource interface {
 	DisplayName() string
 }
 
+//InvalidQueryError is an error of Search caused by the query rather than by the source, e.g. a URL which is not an audio file.
+//It is shown to the user but doesn't mark the source unhealthy
+type InvalidQueryError struct {
+	Reason string
+}
+
+func (err *InvalidQueryError) Error() string {
+	return err.Reason
+}
+
+//NewInvalidQueryError returns an InvalidQueryError with a formatted reason
+func NewInvalidQueryError(format string, args ...interface{}) error {
+	return &InvalidQueryError{Reason: fmt.Sprintf(format, args...)}
+}
+
+//IsInvalidQuery reports whether the cause of err is an InvalidQueryError
+func IsInvalidQuery(err error) bool {
+	_, ok := errors.Cause(err).(*InvalidQueryError)
+	return ok
+}
+
+//MusicSourceWithTrackByID is a music source that can get a track from its ID
+type MusicSourceWithTrackByID interface {
+	MusicSource
+	GetTrack(id string) (Track, error)
+}
+
+//HealthChecker is a music source which can check whether it's working, e.g. its API key is not exhausted
+type HealthChecker interface {
+	MusicSource
+	CheckHealth() error
+}
+
+//MusicSourceWithURL is a music source that recognizes the URLs of its tracks
+type MusicSourceWithURL interface {
+	MusicSource
+	MatchURL(rawURL string) bool
+}
+
+//MusicSourceWithCoverArt is a music source whose cover art is served by the server, e.g. because its URLs contain credentials.
+//Its tracks' CoverURL should return CoverArtURL
+type MusicSourceWithCoverArt interface {
+	MusicSource
+	GetCoverArt(id string) (body io.ReadCloser, contentType string, err error)
+}
+
+//CoverArtURL returns the path at which the server serves the cover art id of the source named source
+func CoverArtURL(source, id string) string {
+	return "/cover/" + url.PathEscape(source) + "?id=" + url.QueryEscape(id)
+}
+
 //MusicSourceInfo contains information about a music source
 type MusicSourceInfo struct {
 	//Name is the full name of the source
@@ -201,6 +370,10 @@ type MusicSourceInfo struct {
 	DisplayName string `json:"display_name"`
 	//ID is the source's id, assigned by the server, used for querying tracks
 	ID int `json:"id"`
+	//Healthy is false if the source's last health check or search failed
+	Healthy bool `json:"healthy"`
+	//Error is the reason of the last failure, if it's unhealthy
+	Error string `json:"error,omitempty"`
 }
 
 func GetMusicSourceInfo(s MusicSource) MusicSourceInfo {
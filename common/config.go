@@ -0,0 +1,152 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Types of ConfigOption
+const (
+	ConfigString  = "string"
+	ConfigInt     = "int"
+	ConfigBool    = "bool"
+	ConfigStrings = "strings"
+)
+
+//ConfigOption describes an option of a ConfigurableMusicSource
+type ConfigOption struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	//Type is either ConfigString, ConfigInt, ConfigBool or ConfigStrings
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	//Default is the value of an optional option which is not set
+	Default interface{} `json:"default,omitempty"`
+	//Env is an environment variable read if the option is not set in the config file
+	Env string `json:"env,omitempty"`
+}
+
+//ConfigurableMusicSource is a music source which is configured by the server before it's used
+type ConfigurableMusicSource interface {
+	MusicSource
+	ConfigSchema() []ConfigOption
+	//Configure receives the values validated by ValidateConfig, which are string, int, bool or []string
+	Configure(config map[string]interface{}) error
+}
+
+//ValidateConfig checks config, as decoded from JSON, against schema.
+//It returns the values converted to the options' types, with environment variables and defaults filled in
+func ValidateConfig(schema []ConfigOption, config map[string]interface{}) (values map[string]interface{}, err error) {
+	values = make(map[string]interface{})
+	known := make(map[string]bool)
+	for _, option := range schema {
+		known[option.Name] = true
+		value, ok := config[option.Name]
+		if !ok && len(option.Env) > 0 {
+			if env, exists := os.LookupEnv(option.Env); exists && len(env) > 0 {
+				if value, err = parseConfigEnv(option, env); err != nil {
+					return nil, errors.Wrapf(err, "option %s (from $%s)", option.Name, option.Env)
+				}
+				ok = true
+			}
+		}
+		if !ok {
+			value, ok = option.Default, option.Default != nil
+		}
+		if !ok {
+			if option.Required {
+				return nil, errors.Errorf("option %s is required", option.Name)
+			}
+			continue
+		}
+		if values[option.Name], err = convertConfigValue(option.Type, value); err != nil {
+			return nil, errors.Wrapf(err, "option %s", option.Name)
+		}
+	}
+	var unknown []string
+	for name := range config {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("unknown options: %s", strings.Join(unknown, ", "))
+	}
+	return
+}
+
+func parseConfigEnv(option ConfigOption, env string) (interface{}, error) {
+	switch option.Type {
+	case ConfigInt:
+		return strconv.Atoi(env)
+	case ConfigBool:
+		return strconv.ParseBool(env)
+	case ConfigStrings:
+		return strings.Split(env, ","), nil
+	}
+	return env, nil
+}
+
+func convertConfigValue(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case ConfigString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case ConfigInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case ConfigBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case ConfigStrings:
+		switch v := value.(type) {
+		case []string:
+			return v, nil
+		case []interface{}:
+			strs := make([]string, len(v))
+			for i, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return nil, errors.New("expected a list of strings")
+				}
+				strs[i] = str
+			}
+			return strs, nil
+		}
+	default:
+		return nil, errors.Errorf("unknown type %q", typ)
+	}
+	return nil, errors.Errorf("expected a value of type %s, got %v", typ, value)
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+var testSchema = []ConfigOption{
+	{Name: "key", Type: ConfigString, Required: true, Env: "MUSICSTREAM_TEST_KEY"},
+	{Name: "limit", Type: ConfigInt, Default: 10},
+	{Name: "hd", Type: ConfigBool},
+	{Name: "regions", Type: ConfigStrings},
+}
+
+func TestValidateConfig(t *testing.T) {
+	values, err := ValidateConfig(testSchema, map[string]interface{}{
+		"key":     "secret",
+		"hd":      true,
+		"regions": []interface{}{"us", "vn"},
+	})
+	if err != nil {
+		t.Fatal("ValidateConfig: ", err)
+	}
+	expected := map[string]interface{}{"key": "secret", "limit": 10, "hd": true, "regions": []string{"us", "vn"}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("values = %v, want %v", values, expected)
+	}
+	if values, err = ValidateConfig(testSchema, map[string]interface{}{"key": "k", "limit": float64(25)}); err != nil || values["limit"] != 25 {
+		t.Errorf("JSON numbers should be converted to int, got %v, %v", values, err)
+	}
+}
+
+func TestValidateConfigEnv(t *testing.T) {
+	os.Setenv("MUSICSTREAM_TEST_KEY", "from env")
+	defer os.Unsetenv("MUSICSTREAM_TEST_KEY")
+	values, err := ValidateConfig(testSchema, nil)
+	if err != nil || values["key"] != "from env" {
+		t.Errorf("ValidateConfig() = %v, %v", values, err)
+	}
+	if values, _ = ValidateConfig(testSchema, map[string]interface{}{"key": "file"}); values["key"] != "file" {
+		t.Errorf("the config file should take precedence over the environment, got %v", values["key"])
+	}
+}
+
+func TestValidateConfigErrors(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{},
+		{"key": 1},
+		{"key": "k", "limit": 1.5},
+		{"key": "k", "hd": "yes"},
+		{"key": "k", "regions": []interface{}{"us", 1}},
+		{"key": "k", "typo": true},
+	}
+	for _, config := range invalid {
+		if _, err := ValidateConfig(testSchema, config); err == nil {
+			t.Errorf("ValidateConfig(%v) should fail", config)
+		}
+	}
+}
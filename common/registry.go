@@ -0,0 +1,61 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"sort"
+	"sync"
+)
+
+//RegisteredSource is a music source compiled into the server
+type RegisteredSource struct {
+	Name      string
+	NewClient func() (MusicSource, error)
+}
+
+var (
+	sourcesMux sync.Mutex
+	sources    = make(map[string]func() (MusicSource, error))
+)
+
+//RegisterSource makes a music source available to the server by name, it should be called from the source package's init function.
+//If a source with the same name is already registered, the first one is kept
+func RegisterSource(name string, newClient func() (MusicSource, error)) {
+	sourcesMux.Lock()
+	defer sourcesMux.Unlock()
+	if newClient == nil {
+		panic("common: RegisterSource's newClient is nil")
+	}
+	if _, exists := sources[name]; !exists {
+		sources[name] = newClient
+	}
+}
+
+//RegisteredSources returns all registered sources, sorted by name
+func RegisteredSources() (registered []RegisteredSource) {
+	sourcesMux.Lock()
+	defer sourcesMux.Unlock()
+	for name, newClient := range sources {
+		registered = append(registered, RegisteredSource{Name: name, NewClient: newClient})
+	}
+	sort.Slice(registered, func(i, j int) bool {
+		return registered[i].Name < registered[j].Name
+	})
+	return
+}
@@ -0,0 +1,29 @@
+package common
+
+import "testing"
+
+type registryTestSource struct {
+	name string
+}
+
+func (s registryTestSource) Search(query string) ([]Track, error) { return nil, nil }
+func (s registryTestSource) Name() string                         { return s.name }
+func (s registryTestSource) DisplayName() string                  { return s.name }
+
+func TestRegisterSource(t *testing.T) {
+	newClient := func(name string) func() (MusicSource, error) {
+		return func() (MusicSource, error) {
+			return registryTestSource{name}, nil
+		}
+	}
+	RegisterSource("b", newClient("b"))
+	RegisterSource("a", newClient("a"))
+	RegisterSource("b", newClient("duplicate"))
+	registered := RegisteredSources()
+	if len(registered) != 2 || registered[0].Name != "a" || registered[1].Name != "b" {
+		t.Fatalf("RegisteredSources() = %v", registered)
+	}
+	if client, _ := registered[1].NewClient(); client.Name() != "b" {
+		t.Errorf("the first registration of a name should be kept, got %s", client.Name())
+	}
+}
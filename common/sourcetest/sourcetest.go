@@ -0,0 +1,50 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package sourcetest helps testing music sources
+package sourcetest
+
+import (
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+//NewClient returns a new client of a source, configured with config as the server would, or fails the test
+func NewClient(t testing.TB, newClient func() (common.MusicSource, error), config map[string]interface{}) common.MusicSource {
+	t.Helper()
+	client, err := newClient()
+	if err != nil {
+		t.Fatal("NewClient: ", err)
+	}
+	configurable, ok := client.(common.ConfigurableMusicSource)
+	if !ok {
+		if len(config) > 0 {
+			t.Fatalf("source %s does not have any options", client.Name())
+		}
+		return client
+	}
+	values, err := common.ValidateConfig(configurable.ConfigSchema(), config)
+	if err == nil {
+		err = configurable.Configure(values)
+	}
+	if err != nil {
+		t.Fatal("Configure: ", err)
+	}
+	return client
+}
@@ -0,0 +1,94 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+type bodyStream struct {
+	body io.ReadCloser
+	info StreamInfo
+}
+
+func (s *bodyStream) Format() int {
+	return FFmpegStream
+}
+func (s *bodyStream) Body() io.ReadCloser {
+	return s.body
+}
+func (s *bodyStream) Info() StreamInfo {
+	return s.info
+}
+
+//NewStream returns a FFmpegStream of the file read from body
+func NewStream(body io.ReadCloser, info StreamInfo) StreamWithInfo {
+	return &bodyStream{body: body, info: info}
+}
+
+//GetRange requests rawURL with httpClient, starting at offset bytes of its body.
+//The query of rawURL, which may contain credentials, is left out of the errors
+func GetRange(ctx context.Context, httpClient *http.Client, rawURL string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(RedactURLError(err))
+	}
+	expected := http.StatusOK
+	if offset > 0 {
+		expected = http.StatusPartialContent
+	}
+	if resp.StatusCode != expected {
+		resp.Body.Close()
+		return nil, errors.Errorf("%s: %s", redactURL(req.URL), resp.Status)
+	}
+	return resp, nil
+}
+
+//redactURL returns u without its user info and query
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User, redacted.RawQuery = nil, ""
+	return redacted.String()
+}
+
+//RedactURLError removes the user info and query of the URL of err, if it's a *url.Error returned by an http.Client,
+//so it can be logged and shown to clients
+func RedactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		if u, perr := url.Parse(urlErr.URL); perr == nil {
+			urlErr.URL = redactURL(u)
+		} else {
+			urlErr.URL = ""
+		}
+	}
+	return err
+}
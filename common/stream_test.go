@@ -0,0 +1,37 @@
+package common
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.mp3", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer server.Close()
+	for offset, expected := range map[int64]string{0: "0123456789", 4: "456789"} {
+		resp, err := GetRange(context.Background(), server.Client(), server.URL+"/file.mp3", offset)
+		if err != nil {
+			t.Fatalf("GetRange(%d): %v", offset, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != expected {
+			t.Errorf("GetRange(%d) = %q, want %q", offset, body, expected)
+		}
+	}
+	_, err := GetRange(context.Background(), server.Client(), server.URL+"/missing?t=secret", 0)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("GetRange of a missing file returned %v, want an error without the query", err)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package lyrics
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	//DefaultCacheTTL is how long lyrics are cached
+	DefaultCacheTTL = 7 * 24 * time.Hour
+	//DefaultNegativeCacheTTL is how long tracks without lyrics are cached
+	DefaultNegativeCacheTTL = 24 * time.Hour
+	//DefaultWriteDelay is how long a FileStore waits after a change before it writes its file
+	DefaultWriteDelay = time.Minute
+)
+
+//CacheEntry is a cached lyrics result
+type CacheEntry struct {
+	Result  common.LyricsResult `json:"result"`
+	Expires time.Time           `json:"expires"`
+}
+
+//CacheStore stores cache entries by key
+type CacheStore interface {
+	Get(key string) (entry CacheEntry, ok bool)
+	Set(keys []string, entry CacheEntry) error
+}
+
+//MemoryStore is an in-memory CacheStore
+type MemoryStore struct {
+	mux     sync.Mutex
+	entries map[string]CacheEntry
+}
+
+//NewMemoryStore returns a new empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]CacheEntry)}
+}
+
+//Get returns the entry of key
+func (store *MemoryStore) Get(key string) (entry CacheEntry, ok bool) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	entry, ok = store.entries[key]
+	return
+}
+
+//Set stores entry under all keys
+func (store *MemoryStore) Set(keys []string, entry CacheEntry) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	for _, key := range keys {
+		store.entries[key] = entry
+	}
+	return nil
+}
+
+//FileStore is a CacheStore persisted to a JSON file.
+//The file is rewritten WriteDelay after a Set, so that the changes made in the meantime are written together
+type FileStore struct {
+	MemoryStore
+	path  string
+	timer *time.Timer
+	//WriteDelay is how long changes are kept in memory before they are written
+	WriteDelay time.Duration
+}
+
+//NewFileStore returns a FileStore backed by the file at path, loading its entries if it exists
+func NewFileStore(path string) (store *FileStore, err error) {
+	store = &FileStore{MemoryStore: MemoryStore{entries: make(map[string]CacheEntry)}, path: path, WriteDelay: DefaultWriteDelay}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = json.Unmarshal(data, &store.entries); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return
+}
+
+//Set stores entry under all keys and schedules a write of the store
+func (store *FileStore) Set(keys []string, entry CacheEntry) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	for _, key := range keys {
+		store.entries[key] = entry
+	}
+	if store.timer == nil {
+		store.timer = time.AfterFunc(store.WriteDelay, func() {
+			if err := store.Flush(); err != nil {
+				log.Println("[Lyrics] cache: ", err)
+			}
+		})
+	}
+	return nil
+}
+
+//Close writes the pending changes to disk
+func (store *FileStore) Close() error {
+	return store.Flush()
+}
+
+//Flush writes the expired entries' pruned store to disk, if it was changed
+func (store *FileStore) Flush() error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	if store.timer == nil {
+		return nil
+	}
+	store.timer.Stop()
+	store.timer = nil
+	now := time.Now()
+	for key, e := range store.entries {
+		if now.After(e.Expires) {
+			delete(store.entries, key)
+		}
+	}
+	data, err := json.Marshal(store.entries)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), store.path))
+}
+
+//Cache is a LyricsProvider which caches the results of another provider
+type Cache struct {
+	provider common.LyricsProvider
+	store    CacheStore
+	//TTL is how long lyrics are cached
+	TTL time.Duration
+	//NegativeTTL is how long the absence of lyrics is cached
+	NegativeTTL time.Duration
+}
+
+//NewCache returns a new Cache of provider's results, stored in store
+func NewCache(provider common.LyricsProvider, store CacheStore) *Cache {
+	return &Cache{provider: provider, store: store, TTL: DefaultCacheTTL, NegativeTTL: DefaultNegativeCacheTTL}
+}
+
+//Close writes the pending changes of the cache's store, if it's persisted
+func (cache *Cache) Close() error {
+	if closer, ok := cache.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//Name returns the cached provider's name
+func (cache *Cache) Name() string {
+	return cache.provider.Name()
+}
+
+//GetLyrics returns the cached lyrics of track, querying the provider if there's none.
+//Radio tracks are never cached
+func (cache *Cache) GetLyrics(track common.Track) (result common.LyricsResult, err error) {
+	return cache.GetTranslatedLyrics(track, "")
+}
+
+//GetTranslatedLyrics returns the cached lyrics of track translated into language, querying the provider if there's none.
+//Translations into each language are cached separately.
+//The absence of lyrics is only cached for the track itself, another source may still have lyrics for the same song
+func (cache *Cache) GetTranslatedLyrics(track common.Track, language string) (result common.LyricsResult, err error) {
+	if track.IsRadio() {
+		return getLyrics(cache.provider, track, language)
+	}
+	keys := cacheKeys(track)
+	if len(language) > 0 {
+		for i := range keys {
+			keys[i] += "@" + language
+		}
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if entry, ok := cache.store.Get(key); ok && now.Before(entry.Expires) {
+			return entry.Result, nil
+		}
+	}
+	result, err = getLyrics(cache.provider, track, language)
+	if err != nil {
+		return
+	}
+	ttl := cache.TTL
+	if Score(result) == 0 {
+		ttl = cache.NegativeTTL
+		if _, ok := track.(common.TrackWithSource); ok && len(track.ID()) > 0 {
+			keys = keys[:1]
+		} else {
+			keys = nil
+		}
+	}
+	if ttl > 0 && len(keys) > 0 {
+		if e := cache.store.Set(keys, CacheEntry{Result: result, Expires: now.Add(ttl)}); e != nil {
+			log.Println("[Lyrics] cache: ", e)
+		}
+	}
+	return
+}
+
+//cacheKeys returns the keys of track, from the most to the least specific.
+//The first key is the track's source and ID, if its source is known
+func cacheKeys(track common.Track) (keys []string) {
+	if strack, ok := track.(common.TrackWithSource); ok && len(track.ID()) > 0 {
+		keys = append(keys, "id:"+strack.Source()+":"+track.ID())
+	}
+	if isrc := track.ISRC(); len(isrc) > 0 {
+		keys = append(keys, "isrc:"+isrc)
+	}
+	if uri := track.SpotifyURI(); len(uri) > 0 {
+		keys = append(keys, "spotify:"+uri)
+	}
+	if title := common.NormalizeText(track.Title()); len(title) > 0 {
+		keys = append(keys, "meta:"+common.NormalizeText(track.Artist())+":"+title)
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package lyrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+type cacheTrack struct {
+	lrcTrack
+	source, id, isrc string
+}
+
+func (track *cacheTrack) Source() string { return track.source }
+func (track *cacheTrack) ID() string     { return track.id }
+func (track *cacheTrack) IsRadio() bool  { return false }
+func (track *cacheTrack) ISRC() string   { return track.isrc }
+
+func TestCache(t *testing.T) {
+	provider := &stubProvider{name: "stub", result: synced}
+	cache := NewCache(provider, NewMemoryStore())
+	track := &cacheTrack{id: "1", isrc: "ISRC1", lrcTrack: lrcTrack{title: "Song", artist: "Artist"}}
+	for i := 0; i < 2; i++ {
+		if result, err := cache.GetLyrics(track); err != nil || len(result.SyncedLyrics) != 1 {
+			t.Fatalf("GetLyrics = %+v, %v", result, err)
+		}
+	}
+	//same song from another source
+	if _, err := cache.GetLyrics(&cacheTrack{id: "2", lrcTrack: lrcTrack{title: "song", artist: "ARTIST"}}); err != nil {
+		t.Fatal("GetLyrics: ", err)
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider was called %d times, want 1", provider.calls)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	provider := &stubProvider{name: "stub"}
+	cache := NewCache(provider, NewMemoryStore())
+	track := &cacheTrack{id: "1", lrcTrack: lrcTrack{title: "Song"}}
+	cache.GetLyrics(track)
+	cache.GetLyrics(track)
+	if provider.calls != 1 {
+		t.Errorf("provider was called %d times, want 1", provider.calls)
+	}
+	cache.NegativeTTL = time.Nanosecond
+	other := &cacheTrack{id: "2", lrcTrack: lrcTrack{title: "Other"}}
+	cache.GetLyrics(other)
+	time.Sleep(time.Millisecond)
+	cache.GetLyrics(other)
+	if provider.calls != 3 {
+		t.Errorf("expired entry was not refreshed, provider was called %d times, want 3", provider.calls)
+	}
+}
+
+func TestCacheNegativeIsPerSource(t *testing.T) {
+	provider := &stubProvider{name: "stub"}
+	cache := NewCache(provider, NewMemoryStore())
+	cache.GetLyrics(&cacheTrack{source: "a", id: "1", isrc: "ISRC1", lrcTrack: lrcTrack{title: "Song", artist: "Artist"}})
+	provider.result = synced
+	result, _ := cache.GetLyrics(&cacheTrack{source: "b", id: "1", isrc: "ISRC1", lrcTrack: lrcTrack{title: "Song", artist: "Artist"}})
+	if len(result.SyncedLyrics) != 1 || provider.calls != 2 {
+		t.Errorf("a track without lyrics hid another source's lyrics: %+v, %d calls", result, provider.calls)
+	}
+	if result, _ := cache.GetLyrics(&cacheTrack{source: "a", id: "1"}); len(result.SyncedLyrics) > 0 || provider.calls != 2 {
+		t.Errorf("the absence of lyrics should still be cached for the track, provider was called %d times", provider.calls)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lyricscache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lyrics.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore: ", err)
+	}
+	entry := CacheEntry{Result: common.LyricsResult{RawLyrics: "cached"}, Expires: time.Now().Add(time.Hour)}
+	if err = store.Set([]string{"a", "b"}, entry); err != nil {
+		t.Fatal("Set: ", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the file should only be written after WriteDelay: %v", err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal("NewFileStore: ", err)
+	}
+	if e, ok := store.Get("b"); !ok || e.Result.RawLyrics != "cached" {
+		t.Errorf("Get(b) = %+v, %v", e, ok)
+	}
+}
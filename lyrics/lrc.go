@@ -0,0 +1,404 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package lyrics
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//LRCProviderName is the name of LRCProvider
+const LRCProviderName = "lrc"
+
+const (
+	//lrcMaxDurationDelta is the maximum difference, in seconds, between a track's duration and the length tag of its LRC file
+	lrcMaxDurationDelta = 5
+	//lrcIndexRefresh is how often the LRC files in the lyrics directories are listed again
+	lrcIndexRefresh = 10 * time.Minute
+)
+
+//LRCTags contains the ID tags of an LRC file
+type LRCTags struct {
+	Artist   string
+	Title    string
+	Album    string
+	Author   string
+	Language string
+	//Length is the length of the song in seconds, 0 if unknown
+	Length int
+	//Offset is the global offset in milliseconds, positive values make the lyrics appear sooner
+	Offset int
+}
+
+type lrcTimedLine struct {
+	time  float64
+	order int
+	text  string
+	words []common.LyricsWord
+}
+
+//ParseLRC parses an LRC file, including the enhanced format's word timestamps.
+//Lines with multiple timestamps are repeated at each of them, lines without any are only kept in RawLyrics
+func ParseLRC(r io.Reader) (result common.LyricsResult, tags LRCTags, err error) {
+	var (
+		timed []lrcTimedLine
+		raw   []string
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if len(line) == 0 {
+			continue
+		}
+		var times []float64
+		isTag := false
+		for strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				break
+			}
+			field := line[1:end]
+			if t, ok := parseLRCTime(field); ok {
+				times = append(times, t)
+			} else if key, value, ok := splitLRCTag(field); ok {
+				tags.set(key, value)
+				isTag = true
+			} else {
+				break
+			}
+			line = strings.TrimSpace(line[end+1:])
+		}
+		if isTag && len(times) == 0 && len(line) == 0 {
+			continue
+		}
+		var lineTime float64
+		if len(times) > 0 {
+			lineTime = times[0]
+		}
+		text, words := parseWordTimestamps(line, lineTime)
+		for _, t := range times {
+			timedLine := lrcTimedLine{time: t, order: len(timed), text: text}
+			for _, word := range words {
+				//words of repeated lines are shifted along with them
+				timedLine.words = append(timedLine.words, common.LyricsWord{Text: word.text, Time: common.LyricsTime{Total: word.time + t - lineTime}})
+			}
+			timed = append(timed, timedLine)
+		}
+		if len(text) > 0 {
+			raw = append(raw, text)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	sort.Slice(timed, func(i, j int) bool {
+		if timed[i].time == timed[j].time {
+			return timed[i].order < timed[j].order
+		}
+		return timed[i].time < timed[j].time
+	})
+	offset := float64(tags.Offset) / 1000
+	for _, line := range timed {
+		t := clampTime(line.time - offset)
+		for i := range line.words {
+			line.words[i].Time = common.NewLyricsTime(clampTime(line.words[i].Time.Total - offset))
+		}
+		result.SyncedLyrics = append(result.SyncedLyrics, common.LyricsLine{Text: line.text, Time: common.NewLyricsTime(t), Words: line.words})
+	}
+	if len(timed) > 0 {
+		raw = raw[:0]
+		for _, line := range timed {
+			if len(line.text) > 0 {
+				raw = append(raw, line.text)
+			}
+		}
+	}
+	result.RawLyrics = strings.Join(raw, "\n")
+	result.Language = tags.Language
+	return
+}
+
+func (tags *LRCTags) set(key, value string) {
+	switch strings.ToLower(key) {
+	case "ar":
+		tags.Artist = value
+	case "ti":
+		tags.Title = value
+	case "al":
+		tags.Album = value
+	case "au", "by":
+		if len(tags.Author) == 0 {
+			tags.Author = value
+		}
+	case "la", "lang":
+		tags.Language = value
+	case "length":
+		if t, ok := parseLRCTime(value); ok {
+			tags.Length = int(t + 0.5)
+		}
+	case "offset":
+		if offset, err := strconv.Atoi(strings.TrimPrefix(value, "+")); err == nil {
+			tags.Offset = offset
+		}
+	}
+}
+
+func splitLRCTag(field string) (key, value string, ok bool) {
+	i := strings.IndexByte(field, ':')
+	if i <= 0 {
+		return
+	}
+	key = field[:i]
+	for _, c := range key {
+		if !unicode.IsLetter(c) {
+			return
+		}
+	}
+	return key, strings.TrimSpace(field[i+1:]), true
+}
+
+//parseLRCTime parses a mm:ss, mm:ss.xx or mm:ss:xx timestamp into seconds
+func parseLRCTime(s string) (t float64, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 3)
+	if len(parts) < 2 {
+		return
+	}
+	minutes, err := strconv.Atoi(parts[0])
+	if err != nil || minutes < 0 {
+		return
+	}
+	if len(parts) == 3 {
+		parts[1] += "." + parts[2]
+	}
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || seconds < 0 || seconds >= 60 {
+		return
+	}
+	return float64(minutes)*60 + seconds, true
+}
+
+func clampTime(t float64) float64 {
+	if t < 0 {
+		return 0
+	}
+	return t
+}
+
+type lrcWord struct {
+	time float64
+	text string
+}
+
+//parseWordTimestamps splits a line of enhanced LRC into its <mm:ss.xx> timed words.
+//Text before the first word timestamp starts at lineTime. words is nil if there's no word timestamp
+func parseWordTimestamps(line string, lineTime float64) (text string, words []lrcWord) {
+	if !strings.Contains(line, "<") {
+		return line, nil
+	}
+	var (
+		b       strings.Builder
+		current = lrcWord{time: lineTime}
+		timed   bool
+		rest    = line
+	)
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '<')
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(rest[start:], '>')
+		}
+		if end < 0 {
+			current.text += rest
+			break
+		}
+		t, ok := parseLRCTime(rest[start+1 : start+end])
+		if !ok {
+			current.text += rest[:start+end+1]
+			rest = rest[start+end+1:]
+			continue
+		}
+		current.text += rest[:start]
+		if len(strings.TrimSpace(current.text)) > 0 {
+			words = append(words, current)
+		}
+		current = lrcWord{time: t}
+		timed = true
+		rest = rest[start+end+1:]
+	}
+	if !timed {
+		return line, nil
+	}
+	if len(strings.TrimSpace(current.text)) > 0 {
+		words = append(words, current)
+	}
+	for i := range words {
+		b.WriteString(words[i].text)
+	}
+	if len(words) > 0 {
+		words[0].text = strings.TrimLeft(words[0].text, " \t")
+		words[len(words)-1].text = strings.TrimRight(words[len(words)-1].text, " \t")
+	}
+	return strings.Join(strings.Fields(b.String()), " "), words
+}
+
+//LRCProvider finds lyrics in LRC files next to the tracks' audio files or in lyrics directories
+type LRCProvider struct {
+	//Dirs are searched recursively for LRC files whose name contains the track's title
+	Dirs []string
+
+	mux      sync.Mutex
+	index    []lrcIndexEntry
+	indexed  time.Time
+	indexing bool
+}
+
+//lrcIndexEntry is an LRC file found in the lyrics directories
+type lrcIndexEntry struct {
+	path string
+	//name is the normalized file name, without its extension
+	name string
+}
+
+//Index lists the LRC files in the lyrics directories.
+//It's called by GetLyrics if they have never been listed, and in the background if they were listed more than 10 minutes ago
+func (p *LRCProvider) Index() {
+	var index []lrcIndexEntry
+	for _, dir := range p.Dirs {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".lrc") {
+				return nil
+			}
+			index = append(index, lrcIndexEntry{path: path, name: common.NormalizeText(strings.TrimSuffix(info.Name(), filepath.Ext(path)))})
+			return nil
+		})
+	}
+	p.mux.Lock()
+	p.index = index
+	p.indexed = time.Now()
+	p.indexing = false
+	p.mux.Unlock()
+}
+
+//files returns the indexed LRC files
+func (p *LRCProvider) files() []lrcIndexEntry {
+	p.mux.Lock()
+	indexed := !p.indexed.IsZero()
+	if indexed && !p.indexing && time.Since(p.indexed) > lrcIndexRefresh {
+		p.indexing = true
+		go p.Index()
+	}
+	p.mux.Unlock()
+	if !indexed {
+		p.Index()
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.index
+}
+
+//Name returns the provider's name
+func (p *LRCProvider) Name() string {
+	return LRCProviderName
+}
+
+//GetLyrics returns the lyrics from the first LRC file that matches the track's artist, title and duration
+func (p *LRCProvider) GetLyrics(track common.Track) (result common.LyricsResult, err error) {
+	if path := localPath(track.Href()); len(path) > 0 {
+		sidecar := strings.TrimSuffix(path, filepath.Ext(path)) + ".lrc"
+		if result, _, err = readLRC(sidecar); err == nil {
+			return
+		}
+	}
+	title := common.NormalizeText(track.Title())
+	if len(title) == 0 {
+		return common.LyricsResult{}, nil
+	}
+	if len(p.Dirs) == 0 {
+		return common.LyricsResult{}, nil
+	}
+	for _, file := range p.files() {
+		if !strings.Contains(file.name, title) {
+			continue
+		}
+		lyrics, tags, err := readLRC(file.path)
+		if err != nil || !tags.matches(track) {
+			continue
+		}
+		return lyrics, nil
+	}
+	return common.LyricsResult{}, nil
+}
+
+func readLRC(path string) (result common.LyricsResult, tags LRCTags, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ParseLRC(f)
+}
+
+//matches reports whether the tags don't contradict the track's metadata
+func (tags *LRCTags) matches(track common.Track) bool {
+	if len(tags.Title) > 0 && common.NormalizeText(tags.Title) != common.NormalizeText(track.Title()) {
+		return false
+	}
+	if artist := common.NormalizeText(track.Artist()); len(tags.Artist) > 0 && len(artist) > 0 {
+		tagArtist := common.NormalizeText(tags.Artist)
+		if !strings.Contains(tagArtist, artist) && !strings.Contains(artist, tagArtist) {
+			return false
+		}
+	}
+	if tags.Length > 0 && track.Duration() > 0 {
+		delta := tags.Length - track.Duration()
+		if delta < -lrcMaxDurationDelta || delta > lrcMaxDurationDelta {
+			return false
+		}
+	}
+	return true
+}
+
+//localPath returns the local file path of href, or "" if it's not a local file
+func localPath(href string) string {
+	if strings.HasPrefix(href, "file://") {
+		u, err := url.Parse(href)
+		if err != nil {
+			return ""
+		}
+		return filepath.FromSlash(u.Path)
+	}
+	if filepath.IsAbs(href) {
+		return href
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package lyrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+const testLRC = `[ar:Some Artist]
+[ti:Some Song]
+[length: 03:20]
+[offset:+500]
+[la:en]
+
+[00:12.00][01:02.50]Chorus line
+[00:05.10]<00:05.10>First <00:05.60>line
+[00:30.00]
+no timestamp
+`
+
+func TestParseLRC(t *testing.T) {
+	result, tags, err := ParseLRC(strings.NewReader(testLRC))
+	if err != nil {
+		t.Fatal("ParseLRC: ", err)
+	}
+	if tags.Artist != "Some Artist" || tags.Title != "Some Song" || tags.Length != 200 || tags.Offset != 500 {
+		t.Errorf("tags = %+v", tags)
+	}
+	if result.Language != "en" {
+		t.Errorf("Language = %q, want en", result.Language)
+	}
+	want := []struct {
+		text  string
+		total float64
+	}{
+		{"First line", 4.6},
+		{"Chorus line", 11.5},
+		{"", 29.5},
+		{"Chorus line", 62},
+	}
+	if len(result.SyncedLyrics) != len(want) {
+		t.Fatalf("len(SyncedLyrics) = %d, want %d", len(result.SyncedLyrics), len(want))
+	}
+	for i, w := range want {
+		line := result.SyncedLyrics[i]
+		if line.Text != w.text || line.Time.Total < w.total-0.001 || line.Time.Total > w.total+0.001 {
+			t.Errorf("line %d = %q at %v, want %q at %v", i, line.Text, line.Time.Total, w.text, w.total)
+		}
+	}
+	if line := result.SyncedLyrics[3]; line.Time.Minutes != 1 || line.Time.Seconds != 2 || line.Time.Hundredths != 0 {
+		t.Errorf("line 3 time = %+v", line.Time)
+	}
+	if words := result.SyncedLyrics[0].Words; len(words) != 2 || words[0].Text != "First " || words[1].Text != "line" || words[1].Time.Total < 5.099 || words[1].Time.Total > 5.101 {
+		t.Errorf("words = %+v", words)
+	}
+	if words := result.SyncedLyrics[1].Words; words != nil {
+		t.Errorf("line without word timestamps has words %+v", words)
+	}
+	if result.RawLyrics != "First line\nChorus line\nChorus line" {
+		t.Errorf("RawLyrics = %q", result.RawLyrics)
+	}
+}
+
+type lrcTrack struct {
+	common.DefaultTrack
+	title, artist, href string
+	duration            int
+}
+
+func (track *lrcTrack) Title() string  { return track.title }
+func (track *lrcTrack) Artist() string { return track.artist }
+func (track *lrcTrack) Href() string   { return track.href }
+func (track *lrcTrack) Duration() int  { return track.duration }
+
+func TestLRCProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "Some Artist - Some Song.lrc"), []byte(testLRC), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "local.lrc"), []byte("[00:01.00]sidecar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider := &LRCProvider{Dirs: []string{dir}}
+	tests := []struct {
+		track *lrcTrack
+		found bool
+	}{
+		{&lrcTrack{title: "Some Song", artist: "Some Artist", duration: 198}, true},
+		{&lrcTrack{title: "some song!", duration: 0}, true},
+		{&lrcTrack{title: "Some Song", artist: "Another Artist"}, false},
+		{&lrcTrack{title: "Some Song", duration: 260}, false},
+		{&lrcTrack{title: "Other Song"}, false},
+	}
+	for _, test := range tests {
+		result, err := provider.GetLyrics(test.track)
+		if err != nil {
+			t.Fatal("GetLyrics: ", err)
+		}
+		if found := len(result.SyncedLyrics) > 0; found != test.found {
+			t.Errorf("GetLyrics(%+v) found = %v, want %v", test.track, found, test.found)
+		}
+	}
+	result, _ := provider.GetLyrics(&lrcTrack{title: "Unrelated", href: filepath.Join(dir, "local.mp3")})
+	if len(result.SyncedLyrics) != 1 || result.SyncedLyrics[0].Text != "sidecar" {
+		t.Errorf("sidecar lyrics = %+v", result)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "New Song.lrc"), []byte("[00:01.00]new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := provider.GetLyrics(&lrcTrack{title: "New Song"}); len(result.SyncedLyrics) > 0 {
+		t.Error("lyrics directories should not be listed again for each track")
+	}
+	provider.Index()
+	if result, _ := provider.GetLyrics(&lrcTrack{title: "New Song"}); len(result.SyncedLyrics) != 1 {
+		t.Errorf("new LRC files should be found once the directories are indexed again, got %+v", result)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package lyrics queries an ordered chain of lyrics providers
+package lyrics
+
+import (
+	"log"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//Names of the providers of this package
+const (
+	SourceProviderName     = "source"
+	TranscriptProviderName = "transcript"
+)
+
+//SourceProvider returns the lyrics fetched by the track's own source, if it implements common.TrackWithLyrics.
+//Tracks which implement common.TrackWithTranscript are left to TranscriptProvider
+type SourceProvider struct{}
+
+//Name returns the provider's name
+func (SourceProvider) Name() string {
+	return SourceProviderName
+}
+
+//GetLyrics returns the lyrics from the track's source
+func (SourceProvider) GetLyrics(track common.Track) (common.LyricsResult, error) {
+	if _, ok := track.(common.TrackWithTranscript); ok {
+		return common.LyricsResult{}, nil
+	}
+	if ltrack, ok := track.(common.TrackWithLyrics); ok {
+		return ltrack.GetLyrics()
+	}
+	return common.LyricsResult{}, nil
+}
+
+//GetTranslatedLyrics returns the lyrics from the track's source, translated into language if the track supports it
+func (p SourceProvider) GetTranslatedLyrics(track common.Track, language string) (common.LyricsResult, error) {
+	if _, ok := track.(common.TrackWithTranscript); ok {
+		return common.LyricsResult{}, nil
+	}
+	if ltrack, ok := track.(common.TrackWithTranslatedLyrics); ok {
+		return ltrack.GetTranslatedLyrics(language)
+	}
+	return p.GetLyrics(track)
+}
+
+//TranscriptProvider returns the transcript of the track, if it implements common.TrackWithTranscript
+type TranscriptProvider struct{}
+
+//Name returns the provider's name
+func (TranscriptProvider) Name() string {
+	return TranscriptProviderName
+}
+
+//GetLyrics returns the track's transcript in its source's default language
+func (p TranscriptProvider) GetLyrics(track common.Track) (common.LyricsResult, error) {
+	return p.GetTranslatedLyrics(track, "")
+}
+
+//GetTranslatedLyrics returns the track's transcript, translated into language if there's a translation
+func (TranscriptProvider) GetTranslatedLyrics(track common.Track, language string) (common.LyricsResult, error) {
+	if ttrack, ok := track.(common.TrackWithTranscript); ok {
+		return ttrack.GetTranscript(language)
+	}
+	return common.LyricsResult{}, nil
+}
+
+//Chain queries its providers in order and merges their results.
+//Synced lyrics are preferred over plain lyrics and translated ones over untranslated ones,
+//ties are broken by the providers' order
+type Chain struct {
+	providers []common.LyricsProvider
+}
+
+//NewChain returns a new Chain of the provided providers
+func NewChain(providers ...common.LyricsProvider) *Chain {
+	return &Chain{providers: providers}
+}
+
+//Add appends a provider to the end of the chain
+func (chain *Chain) Add(provider common.LyricsProvider) {
+	chain.providers = append(chain.providers, provider)
+}
+
+//Providers returns the providers of the chain in order
+func (chain *Chain) Providers() []common.LyricsProvider {
+	return chain.providers
+}
+
+//Name returns the chain's name
+func (chain *Chain) Name() string {
+	return "chain"
+}
+
+//GetLyrics returns the merged lyrics of track from all providers, translated into their default language.
+//It stops at the first provider that returns translated synced lyrics
+func (chain *Chain) GetLyrics(track common.Track) (result common.LyricsResult, err error) {
+	return chain.GetTranslatedLyrics(track, "")
+}
+
+//GetTranslatedLyrics returns the merged lyrics of track from all providers, translated into language by those which support it.
+//An empty language uses the providers' default language
+func (chain *Chain) GetTranslatedLyrics(track common.Track, language string) (result common.LyricsResult, err error) {
+	best := -1
+	for _, provider := range chain.providers {
+		lyrics, e := getLyrics(provider, track, language)
+		if e != nil {
+			log.Printf("[Lyrics] %s: GetLyrics: %v", provider.Name(), e)
+			err = e
+			continue
+		}
+		if len(result.RawLyrics) == 0 && len(lyrics.RawLyrics) > 0 {
+			result.RawLyrics = lyrics.RawLyrics
+		}
+		if score := Score(lyrics); score > best {
+			best = score
+			raw := result.RawLyrics
+			result = lyrics
+			if len(result.RawLyrics) == 0 {
+				result.RawLyrics = raw
+			}
+		}
+		if best&scoreBest == scoreBest {
+			break
+		}
+	}
+	if best > 0 {
+		err = nil
+	}
+	return
+}
+
+//getLyrics calls provider.GetTranslatedLyrics, or provider.GetLyrics if it can't translate or language is empty, recovering from its panics
+func getLyrics(provider common.LyricsProvider, track common.Track, language string) (result common.LyricsResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panicked: %v", r)
+		}
+	}()
+	if translator, ok := provider.(common.TranslatingLyricsProvider); ok && len(language) > 0 {
+		return translator.GetTranslatedLyrics(track, language)
+	}
+	return provider.GetLyrics(track)
+}
+
+const (
+	scorePlain      = 1
+	scoreSynced     = 2
+	scoreTranslated = 4
+	scoreBest       = scoreSynced | scoreTranslated
+)
+
+//Score ranks a lyrics result, 0 means there are no lyrics
+func Score(result common.LyricsResult) (score int) {
+	if len(result.RawLyrics) > 0 {
+		score = scorePlain
+	}
+	for _, line := range result.SyncedLyrics {
+		if len(line.Text) > 0 || len(line.Translated) > 0 {
+			score |= scoreSynced
+		}
+		if len(line.Translated) > 0 {
+			score |= scoreTranslated
+		}
+	}
+	return
+}
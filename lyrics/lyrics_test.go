@@ -0,0 +1,100 @@
+package lyrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+type stubProvider struct {
+	name   string
+	result common.LyricsResult
+	err    error
+	calls  int
+}
+
+func (p *stubProvider) Name() string {
+	return p.name
+}
+
+func (p *stubProvider) GetLyrics(track common.Track) (common.LyricsResult, error) {
+	p.calls++
+	return p.result, p.err
+}
+
+var (
+	plain      = common.LyricsResult{RawLyrics: "plain"}
+	synced     = common.LyricsResult{SyncedLyrics: []common.LyricsLine{{Text: "synced"}}}
+	translated = common.LyricsResult{SyncedLyrics: []common.LyricsLine{{Text: "synced", Translated: "translated"}}}
+)
+
+func TestChainPrefersSynced(t *testing.T) {
+	chain := NewChain(&stubProvider{name: "a", result: plain}, &stubProvider{name: "b", result: synced})
+	result, err := chain.GetLyrics(&common.DefaultTrack{})
+	if err != nil {
+		t.Fatal("GetLyrics: ", err)
+	}
+	if len(result.SyncedLyrics) != 1 || result.RawLyrics != "plain" {
+		t.Errorf("result = %+v, want synced lyrics with the plain lyrics merged", result)
+	}
+}
+
+func TestChainStopsAtTranslated(t *testing.T) {
+	last := &stubProvider{name: "c", result: synced}
+	chain := NewChain(&stubProvider{name: "a", err: errors.New("failed")}, &stubProvider{name: "b", result: translated}, last)
+	result, err := chain.GetLyrics(&common.DefaultTrack{})
+	if err != nil {
+		t.Fatal("GetLyrics: ", err)
+	}
+	if result.SyncedLyrics[0].Translated != "translated" {
+		t.Errorf("result = %+v, want the translated lyrics", result)
+	}
+	if last.calls != 0 {
+		t.Error("providers after a translated synced result should not be queried")
+	}
+}
+
+func TestChainKeepsOrder(t *testing.T) {
+	chain := NewChain(&stubProvider{name: "a", result: common.LyricsResult{RawLyrics: "first"}}, &stubProvider{name: "b", result: plain})
+	if result, _ := chain.GetLyrics(&common.DefaultTrack{}); result.RawLyrics != "first" {
+		t.Errorf("RawLyrics = %q, want the first provider's", result.RawLyrics)
+	}
+}
+
+func TestChainError(t *testing.T) {
+	chain := NewChain(&stubProvider{name: "a", err: errors.New("failed")}, &stubProvider{name: "b"})
+	if _, err := chain.GetLyrics(&common.DefaultTrack{}); err == nil {
+		t.Error("GetLyrics should fail when no provider has lyrics and one of them failed")
+	}
+}
+
+type transcriptTrack struct {
+	common.DefaultTrack
+}
+
+func (track *transcriptTrack) GetLyrics() (common.LyricsResult, error) {
+	return track.GetTranscript("")
+}
+
+func (track *transcriptTrack) GetTranscript(language string) (common.LyricsResult, error) {
+	return common.LyricsResult{RawLyrics: "transcript " + language}, nil
+}
+
+func TestTranscriptProvider(t *testing.T) {
+	track := &transcriptTrack{}
+	if result, _ := (SourceProvider{}).GetLyrics(track); len(result.RawLyrics) > 0 {
+		t.Error("SourceProvider should leave transcripts to TranscriptProvider")
+	}
+	if result, _ := (TranscriptProvider{}).GetTranslatedLyrics(track, "vi"); result.RawLyrics != "transcript vi" {
+		t.Errorf("RawLyrics = %q, want the transcript in vi", result.RawLyrics)
+	}
+	mxm := &stubProvider{name: "musixmatch", result: plain}
+	result, _ := NewChain(mxm, TranscriptProvider{}).GetLyrics(track)
+	if result.RawLyrics != "plain" {
+		t.Errorf("RawLyrics = %q, want the first provider's", result.RawLyrics)
+	}
+	if result, _ := (TranscriptProvider{}).GetLyrics(&common.DefaultTrack{}); len(result.RawLyrics) > 0 {
+		t.Error("tracks without a transcript should have no transcript")
+	}
+}
@@ -27,15 +27,25 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/TrungNguyen1909/MusicStream/common"
 	"github.com/pkg/errors"
 )
 
+//ProviderName is the name of the MusixMatch lyrics provider
+const ProviderName = "musixmatch"
+
 type mxmResponse struct {
 	Message struct {
 		Body struct {
 			MacroCalls struct {
+				MatcherTrackGet struct {
+					Message struct {
+						//Body is an empty string instead of an object if there's no match
+						Body json.RawMessage `json:"body"`
+					} `json:"message"`
+				} `json:"matcher.track.get"`
 				TrackLyricsGet struct {
 					Message struct {
 						Body struct {
@@ -102,6 +112,38 @@ type mxmResponse struct {
 	} `json:"message"`
 }
 
+type mxmMatcherBody struct {
+	Track struct {
+		CommontrackID int `json:"commontrack_id"`
+		HasRichsync   int `json:"has_richsync"`
+		TrackLength   int `json:"track_length"`
+	} `json:"track"`
+}
+
+type mxmRichsyncResponse struct {
+	Message struct {
+		Body struct {
+			Richsync struct {
+				RichsyncBody   string `json:"richsync_body"`
+				RichsyncLength int    `json:"richsync_length"`
+			} `json:"richsync"`
+		} `json:"body"`
+	} `json:"message"`
+}
+
+//richsyncLine is a line of Musixmatch's richsync body
+type richsyncLine struct {
+	//Start and End are the line's time in seconds
+	Start float64 `json:"ts"`
+	End   float64 `json:"te"`
+	Words []struct {
+		Text string `json:"c"`
+		//Offset is the word's time relative to the line's start
+		Offset float64 `json:"o"`
+	} `json:"l"`
+	Text string `json:"x"`
+}
+
 //Client represents a MusixMatch lyrics Client
 type Client struct {
 	httpClient  *http.Client
@@ -109,17 +151,29 @@ type Client struct {
 	obUserToken string
 }
 
-//GetLyrics returns the lyrics of the song with provided information
+//Name returns the provider's name
+func (client *Client) Name() string {
+	return ProviderName
+}
+
+//GetLyrics returns the lyrics of the song with provided information, translated into English
 func (client *Client) GetLyrics(track common.Track) (result common.LyricsResult, err error) {
+	return client.GetTranslatedLyrics(track, "en")
+}
+
+//GetTranslatedLyrics returns the lyrics of the song with provided information, translated into language
+func (client *Client) GetTranslatedLyrics(track common.Track, language string) (result common.LyricsResult, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("[MusixMatch]: %v\n", r)
 		}
 	}()
-	rawURL := "http://apic.musixmatch.com/ws/1.1/macro.subtitles.get?format=json&user_language=en&tags=playing&namespace=lyrics_synched&f_subtitle_length_max_deviation=1&subtitle_format=mxm&app_id=mac-ios-v2.0&part=subtitle_translated%2Clyrics_translated&selected_language=en"
+	rawURL := "http://apic.musixmatch.com/ws/1.1/macro.subtitles.get?format=json&tags=playing&namespace=lyrics_synched&f_subtitle_length_max_deviation=1&subtitle_format=mxm&app_id=mac-ios-v2.0&part=subtitle_translated%2Clyrics_translated"
 
 	reqURL, _ := url.Parse(rawURL)
 	queries := reqURL.Query()
+	queries.Add("user_language", language)
+	queries.Add("selected_language", language)
 	queries.Add("usertoken", client.userToken)
 	if len(client.obUserToken) > 0 {
 		queries.Add("OB-USER-TOKEN", client.obUserToken)
@@ -140,28 +194,8 @@ func (client *Client) GetLyrics(track common.Track) (result common.LyricsResult,
 		queries.Add("track_spotify_id", track.SpotifyURI())
 	}
 	reqURL.RawQuery = queries.Encode()
-	req, _ := http.NewRequest("GET", reqURL.String(), nil)
-	req.Header.Set("Host", "apic.musixmatch.com")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.4 Safari/605.1.15")
-	req.Header.Set("Accept-Language", "en-us")
-	req.Header.Set("Accept-Encoding", "gzip, deflate")
-	resp, err := client.httpClient.Do(req)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	var reader io.ReadCloser
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, err = gzip.NewReader(resp.Body)
-		defer reader.Close()
-	default:
-		reader = resp.Body
-	}
 	var d mxmResponse
-	err = json.NewDecoder(reader).Decode(&d)
+	err = client.get(reqURL.String(), &d)
 	if err != nil {
 		return
 	}
@@ -170,7 +204,7 @@ func (client *Client) GetLyrics(track common.Track) (result common.LyricsResult,
 	subtitle := d.Message.Body.MacroCalls.TrackSubtitlesGet.Message.Body.SubtitleList[0].Subtitle
 	result.Language = subtitle.SubtitleLanguage
 	sd := subtitle.SubtitleBody
-	if result.Language != "en" && len(subtitle.SubtitleTranslated.SubtitleBody) > 0 {
+	if result.Language != language && len(subtitle.SubtitleTranslated.SubtitleBody) > 0 {
 		st := subtitle.SubtitleTranslated.SubtitleBody
 		var subtitleTranslated []common.LyricsLine
 		err = json.Unmarshal(([]byte)(st), &subtitleTranslated)
@@ -194,6 +228,15 @@ func (client *Client) GetLyrics(track common.Track) (result common.LyricsResult,
 			result.SyncedLyrics[i].Original = originalSyncedLyrics[i].Text
 		}
 	}
+	var matcher mxmMatcherBody
+	if json.Unmarshal(d.Message.Body.MacroCalls.MatcherTrackGet.Message.Body, &matcher) == nil && matcher.Track.HasRichsync != 0 {
+		richsync, err := client.getRichsync(matcher.Track.CommontrackID, matcher.Track.TrackLength)
+		if err != nil {
+			log.Println("[MusixMatch] getRichsync: ", err)
+		} else {
+			addWords(result.SyncedLyrics, richsync)
+		}
+	}
 	if n := len(result.SyncedLyrics); n > 0 && (result.SyncedLyrics[n-1].Text != "" || result.SyncedLyrics[n-1].Translated != "" || result.SyncedLyrics[n-1].Original != "") {
 		result.SyncedLyrics = append(result.SyncedLyrics, common.LyricsLine{
 			Time: common.LyricsTime{
@@ -204,6 +247,89 @@ func (client *Client) GetLyrics(track common.Track) (result common.LyricsResult,
 	return
 }
 
+//get sends a GET request to Musixmatch and decodes the JSON response into v
+func (client *Client) get(rawURL string, v interface{}) (err error) {
+	req, _ := http.NewRequest("GET", rawURL, nil)
+	req.Header.Set("Host", "apic.musixmatch.com")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.4 Safari/605.1.15")
+	req.Header.Set("Accept-Language", "en-us")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var reader io.ReadCloser
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(resp.Body)
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+	default:
+		reader = resp.Body
+	}
+	return json.NewDecoder(reader).Decode(v)
+}
+
+//getRichsync returns the word-synced lyrics of a track
+func (client *Client) getRichsync(commontrackID, length int) (lines []richsyncLine, err error) {
+	reqURL, _ := url.Parse("http://apic.musixmatch.com/ws/1.1/track.richsync.get?format=json&app_id=mac-ios-v2.0&f_richsync_length_max_deviation=1")
+	queries := reqURL.Query()
+	queries.Add("usertoken", client.userToken)
+	if len(client.obUserToken) > 0 {
+		queries.Add("OB-USER-TOKEN", client.obUserToken)
+	}
+	queries.Add("commontrack_id", strconv.Itoa(commontrackID))
+	if length > 0 {
+		queries.Add("f_richsync_length", strconv.Itoa(length))
+	}
+	reqURL.RawQuery = queries.Encode()
+	var d mxmRichsyncResponse
+	if err = client.get(reqURL.String(), &d); err != nil {
+		return
+	}
+	if len(d.Message.Body.Richsync.RichsyncBody) == 0 {
+		return nil, errors.WithStack(errors.New("richsync not found"))
+	}
+	err = json.Unmarshal([]byte(d.Message.Body.Richsync.RichsyncBody), &lines)
+	return
+}
+
+//richsyncMaxDelta is the maximum difference, in seconds, between the start of a subtitle line and its richsync line
+const richsyncMaxDelta = 1.0
+
+//addWords sets the words of each of the synced lines from the richsync line that starts at about the same time
+func addWords(syncedLyrics []common.LyricsLine, richsync []richsyncLine) {
+	j := 0
+	for i := range syncedLyrics {
+		line := &syncedLyrics[i]
+		for j < len(richsync) && richsync[j].Start < line.Time.Total-richsyncMaxDelta {
+			j++
+		}
+		if j >= len(richsync) {
+			return
+		}
+		if richsync[j].Start > line.Time.Total+richsyncMaxDelta {
+			continue
+		}
+		words := make([]common.LyricsWord, 0, len(richsync[j].Words))
+		for _, word := range richsync[j].Words {
+			if len(strings.TrimSpace(word.Text)) == 0 && len(words) > 0 {
+				//spaces are separate segments in richsync
+				words[len(words)-1].Text += word.Text
+				continue
+			}
+			words = append(words, common.LyricsWord{Text: word.Text, Time: common.NewLyricsTime(richsync[j].Start + word.Offset)})
+		}
+		line.Words = words
+		j++
+	}
+}
+
 //NewClient returns a new MusixMatch client with provided tokens
 func NewClient(MXMUserToken, MXMOBUserToken string) (client *Client, err error) {
 	if len(MXMUserToken) <= 0 {
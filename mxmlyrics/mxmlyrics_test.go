@@ -0,0 +1,35 @@
+package mxmlyrics
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+const testRichsync = `[
+	{"ts": 10.2, "te": 12.0, "l": [{"c": "Hello", "o": 0}, {"c": " ", "o": 0.4}, {"c": "world", "o": 0.5}], "x": "Hello world"},
+	{"ts": 30.0, "te": 31.0, "l": [{"c": "Unmatched", "o": 0}], "x": "Unmatched"}
+]`
+
+func TestAddWords(t *testing.T) {
+	var richsync []richsyncLine
+	if err := json.Unmarshal([]byte(testRichsync), &richsync); err != nil {
+		t.Fatal(err)
+	}
+	lines := []common.LyricsLine{
+		{Text: "Hello world", Time: common.LyricsTime{Total: 10.3}},
+		{Text: "No richsync", Time: common.LyricsTime{Total: 20}},
+	}
+	addWords(lines, richsync)
+	words := lines[0].Words
+	if len(words) != 2 || words[0].Text != "Hello " || words[1].Text != "world" {
+		t.Fatalf("words = %+v", words)
+	}
+	if words[1].Time.Total != 10.7 {
+		t.Errorf("second word starts at %v, want 10.7", words[1].Time.Total)
+	}
+	if lines[1].Words != nil {
+		t.Errorf("line without richsync has words %+v", lines[1].Words)
+	}
+}
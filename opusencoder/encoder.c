@@ -0,0 +1,79 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <opus.h>
+struct GoSlice {
+	void *data;
+	long long len;
+	long long cap;
+};
+typedef struct Encoder {
+	OpusEncoder *enc;
+	int num_channels;
+	int sample_rate;
+	int frame_size;
+	int64_t granulepos;
+} Encoder;
+
+static Encoder *encoder_start(int channels, int sample_rate, long bitrate, int frame_size)
+{
+	int err = 0;
+	Encoder *state = calloc(1, sizeof(Encoder));
+
+	state->sample_rate = sample_rate;
+	state->num_channels = channels;
+	state->frame_size = frame_size;
+	state->enc = opus_encoder_create(sample_rate, state->num_channels, OPUS_APPLICATION_AUDIO, &err);
+	if (err != OPUS_OK) {
+		fprintf(stderr, "encoder_start() failed: opus_encoder_create(): %s\n", opus_strerror(err));
+		free(state);
+		return NULL;
+	}
+	opus_encoder_ctl(state->enc, OPUS_SET_BITRATE(bitrate));
+	return state;
+}
+
+// encode encodes exactly one frame of s16le interleaved pcm into a single opus packet
+static long encode(Encoder *state, char *outputSlice, char *inputSlice)
+{
+	struct GoSlice *outSlice = (struct GoSlice *)outputSlice;
+	struct GoSlice *dataSlice = (struct GoSlice *)inputSlice;
+	unsigned char *out = (unsigned char *)outSlice->data;
+	const opus_int16 *pcm = (const opus_int16 *)dataSlice->data;
+	long out_size = outSlice->len;
+	long data_size = dataSlice->len;
+	if (data_size < state->frame_size * state->num_channels * 2) {
+		return 0;
+	}
+	opus_int32 ret = opus_encode(state->enc, pcm, state->frame_size, out, out_size);
+	if (ret < 0) {
+		fprintf(stderr, "encode() failed: opus_encode(): %s\n", opus_strerror(ret));
+		return 0;
+	}
+	state->granulepos += state->frame_size;
+	return ret;
+}
+
+static void encoder_finish(Encoder *state)
+{
+	opus_encoder_destroy(state->enc);
+	free(state);
+}
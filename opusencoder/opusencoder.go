@@ -0,0 +1,64 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opusencoder
+
+/*
+#include "encoder.c"
+#cgo pkg-config: opus
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+//FrameSize is the number of samples per channel in every encoded packet (20ms at 48kHz)
+const FrameSize = 960
+
+//Encoder encodes s16le interleaved PCM into raw Opus packets, one packet per frame
+type Encoder struct {
+	encoder *C.struct_Encoder
+	mux     sync.Mutex
+}
+
+func NewEncoder(channels int32, sampleRate int32, bitRate uint) *Encoder {
+	encoder := &Encoder{}
+	encoder.encoder = C.encoder_start(C.int(channels), C.int(sampleRate), C.long(bitRate), C.int(FrameSize))
+	return encoder
+}
+
+//Encode encodes exactly one frame (FrameSize*channels*2 bytes) of data into out and returns the packet's size
+func (encoder *Encoder) Encode(out []byte, data []byte) int {
+	encoder.mux.Lock()
+	defer encoder.mux.Unlock()
+	return int(C.encode(encoder.encoder, (*C.char)(unsafe.Pointer(&out)), (*C.char)(unsafe.Pointer(&data))))
+}
+
+func (encoder *Encoder) Close() {
+	encoder.mux.Lock()
+	defer encoder.mux.Unlock()
+	C.encoder_finish(encoder.encoder)
+	encoder.encoder = nil
+}
+
+func (encoder *Encoder) GranulePos() int64 {
+	encoder.mux.Lock()
+	defer encoder.mux.Unlock()
+	return int64(encoder.encoder.granulepos)
+}
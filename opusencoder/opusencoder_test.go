@@ -0,0 +1,40 @@
+package opusencoder
+
+import "testing"
+
+//sawtooth returns a frame of s16le PCM with a different signal on each channel
+func sawtooth(channels int) []byte {
+	frame := make([]byte, FrameSize*channels*2)
+	for i := 0; i < FrameSize; i++ {
+		for c := 0; c < channels; c++ {
+			sample := int16((i*(c+1)*37)%2000 - 1000)
+			frame[(i*channels+c)*2] = byte(sample)
+			frame[(i*channels+c)*2+1] = byte(sample >> 8)
+		}
+	}
+	return frame
+}
+
+func TestEncode(t *testing.T) {
+	for _, channels := range []int32{1, 2} {
+		encoder := NewEncoder(channels, 48000, 128000)
+		out := make([]byte, 4000)
+		if n := encoder.Encode(out, make([]byte, FrameSize*int(channels)*2-1)); n != 0 {
+			t.Errorf("%d channels: a partial frame was encoded into %d bytes", channels, n)
+		}
+		for i := 1; i <= 3; i++ {
+			n := encoder.Encode(out, sawtooth(int(channels)))
+			if n <= 0 {
+				t.Fatalf("%d channels: Encode() = %d", channels, n)
+			}
+			//the stereo flag of the packet's TOC byte
+			if stereo := out[0]&0x04 != 0; stereo != (channels == 2) {
+				t.Errorf("%d channels: packet's stereo flag is %v", channels, stereo)
+			}
+			if pos := encoder.GranulePos(); pos != int64(i*FrameSize) {
+				t.Errorf("%d channels: GranulePos() = %d, want %d", channels, pos, i*FrameSize)
+			}
+		}
+		encoder.Close()
+	}
+}
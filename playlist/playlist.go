@@ -0,0 +1,373 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package playlist reads and writes playlist files
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Playlist formats
+const (
+	FormatM3U  = "m3u"
+	FormatPLS  = "pls"
+	FormatXSPF = "xspf"
+	FormatJSON = "json"
+)
+
+//MaxEntries is the maximum number of entries read from a playlist
+const MaxEntries = 1000
+
+//Entry is a track of a playlist
+type Entry struct {
+	//Source is the name of the MusicSource of the track, if known
+	Source string `json:"source,omitempty"`
+	//ID is the track's ID in Source
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	//Cover is the URL of the track's cover art
+	Cover string `json:"cover,omitempty"`
+	//Duration is the track's duration in seconds, 0 if unknown
+	Duration int `json:"duration,omitempty"`
+	//Location is the track's URL
+	Location string `json:"href,omitempty"`
+}
+
+//Query returns the text used to search for the entry
+func (entry Entry) Query() string {
+	if len(entry.Artist) > 0 && len(entry.Title) > 0 {
+		return entry.Artist + " - " + entry.Title
+	}
+	if len(entry.Title) > 0 {
+		return entry.Title
+	}
+	return entry.Location
+}
+
+//jsonPlaylist is MusicStream's JSON playlist format, a bare list of entries is accepted too
+type jsonPlaylist struct {
+	Name   string  `json:"name,omitempty"`
+	Tracks []Entry `json:"tracks"`
+}
+
+//FormatFromName returns the format of a playlist from its file name, extension or MIME type, or "" if unknown
+func FormatFromName(name string) string {
+	name = strings.ToLower(name)
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSpace(name)
+	switch {
+	case strings.HasSuffix(name, "m3u"), strings.HasSuffix(name, "m3u8"), strings.HasSuffix(name, "mpegurl"):
+		return FormatM3U
+	case strings.HasSuffix(name, "pls"), strings.HasSuffix(name, "scpls"):
+		return FormatPLS
+	case strings.HasSuffix(name, "xspf"), strings.HasSuffix(name, "xspf+xml"):
+		return FormatXSPF
+	case strings.HasSuffix(name, "json"):
+		return FormatJSON
+	}
+	return ""
+}
+
+//Sniff returns the format of a playlist from its content
+func Sniff(data []byte) string {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case len(data) == 0:
+		return FormatM3U
+	case bytes.HasPrefix(bytes.ToLower(data), []byte("[playlist]")):
+		return FormatPLS
+	case data[0] == '<':
+		return FormatXSPF
+	case data[0] == '{' || data[0] == '[':
+		return FormatJSON
+	}
+	return FormatM3U
+}
+
+//Parse reads the entries of a playlist, format is detected from its content if it's empty
+func Parse(r io.Reader, format string) (entries []Entry, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(format) == 0 {
+		format = Sniff(data)
+	}
+	switch format {
+	case FormatM3U:
+		entries, err = parseM3U(data)
+	case FormatPLS:
+		entries, err = parsePLS(data)
+	case FormatXSPF:
+		entries, err = parseXSPF(data)
+	case FormatJSON:
+		entries, err = parseJSON(data)
+	default:
+		return nil, errors.WithStack(errors.New("unsupported playlist format"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > MaxEntries {
+		return nil, errors.WithStack(errors.Errorf("playlist has more than %d entries", MaxEntries))
+	}
+	return
+}
+
+//splitTitle splits an "Artist - Title" string
+func splitTitle(s string) (artist, title string) {
+	if i := strings.Index(s, " - "); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+3:])
+	}
+	return "", strings.TrimSpace(s)
+}
+
+func parseM3U(data []byte) (entries []Entry, err error) {
+	var current Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case len(line) == 0:
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := line[len("#EXTINF:"):]
+			comma := strings.IndexByte(info, ',')
+			if comma < 0 {
+				continue
+			}
+			//the duration may be followed by attributes
+			if fields := strings.Fields(info[:comma]); len(fields) > 0 {
+				if duration, err := strconv.ParseFloat(fields[0], 64); err == nil && duration > 0 {
+					current.Duration = int(duration + 0.5)
+				}
+			}
+			current.Artist, current.Title = splitTitle(info[comma+1:])
+		case strings.HasPrefix(line, "#"):
+		default:
+			current.Location = line
+			entries = append(entries, current)
+			current = Entry{}
+		}
+	}
+	return entries, errors.WithStack(scanner.Err())
+}
+
+func parsePLS(data []byte) (entries []Entry, err error) {
+	byIndex := make(map[int]*Entry)
+	var order []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(line[:eq])), strings.TrimSpace(line[eq+1:])
+		var field string
+		for _, prefix := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, prefix) {
+				field = prefix
+				break
+			}
+		}
+		if len(field) == 0 {
+			continue
+		}
+		index, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+		entry, ok := byIndex[index]
+		if !ok {
+			if len(order) >= MaxEntries {
+				return nil, errors.WithStack(errors.Errorf("playlist has more than %d entries", MaxEntries))
+			}
+			entry = &Entry{}
+			byIndex[index] = entry
+			order = append(order, index)
+		}
+		switch field {
+		case "file":
+			entry.Location = value
+		case "title":
+			entry.Artist, entry.Title = splitTitle(value)
+		case "length":
+			if length, err := strconv.Atoi(value); err == nil && length > 0 {
+				entry.Duration = length
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Ints(order)
+	for _, index := range order {
+		if entry := byIndex[index]; len(entry.Location) > 0 || len(entry.Title) > 0 {
+			entries = append(entries, *entry)
+		}
+	}
+	return
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Image    string `xml:"image,omitempty"`
+	//Duration is in milliseconds
+	Duration int `xml:"duration,omitempty"`
+}
+
+func parseXSPF(data []byte) (entries []Entry, err error) {
+	var p xspfPlaylist
+	if err = xml.Unmarshal(data, &p); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, track := range p.Tracks {
+		entries = append(entries, Entry{
+			Title:    strings.TrimSpace(track.Title),
+			Artist:   strings.TrimSpace(track.Creator),
+			Album:    strings.TrimSpace(track.Album),
+			Cover:    strings.TrimSpace(track.Image),
+			Duration: (track.Duration + 500) / 1000,
+			Location: strings.TrimSpace(track.Location),
+		})
+	}
+	return
+}
+
+func parseJSON(data []byte) (entries []Entry, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		var p jsonPlaylist
+		err = json.Unmarshal(data, &p)
+		entries = p.Tracks
+	}
+	return entries, errors.WithStack(err)
+}
+
+//ContentType returns the MIME type of format
+func ContentType(format string) string {
+	switch format {
+	case FormatM3U:
+		return "audio/x-mpegurl; charset=utf-8"
+	case FormatPLS:
+		return "audio/x-scpls"
+	case FormatXSPF:
+		return "application/xspf+xml"
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+//Extension returns the file extension of format, M3U playlists are written as UTF-8 so they are .m3u8 files
+func Extension(format string) string {
+	if format == FormatM3U {
+		return ".m3u8"
+	}
+	return "." + format
+}
+
+//Write writes a playlist of entries in the provided format, name is the playlist's title
+func Write(w io.Writer, entries []Entry, format, name string) (err error) {
+	switch format {
+	case FormatM3U:
+		err = writeM3U(w, entries)
+	case FormatXSPF:
+		err = writeXSPF(w, entries, name)
+	case FormatJSON:
+		err = json.NewEncoder(w).Encode(jsonPlaylist{Name: name, Tracks: entries})
+	default:
+		return errors.WithStack(errors.New("unsupported playlist format"))
+	}
+	return errors.WithStack(err)
+}
+
+func writeM3U(w io.Writer, entries []Entry) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("#EXTM3U\n")
+	for _, entry := range entries {
+		//tracks without a URL, e.g. Subsonic's, are written with their artist and title as location,
+		//an empty line would be skipped, they're searched for by their title when imported
+		location := strings.ReplaceAll(entry.Location, "\n", " ")
+		if len(location) == 0 {
+			location = strings.ReplaceAll(entry.Query(), "\n", " ")
+		}
+		if len(location) == 0 {
+			continue
+		}
+		duration := entry.Duration
+		if duration <= 0 {
+			duration = -1
+		}
+		title := entry.Title
+		if len(entry.Artist) > 0 {
+			title = entry.Artist + " - " + title
+		}
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n%s\n", duration, strings.ReplaceAll(title, "\n", " "), location)
+	}
+	return b.Flush()
+}
+
+func writeXSPF(w io.Writer, entries []Entry, name string) error {
+	p := xspfPlaylist{Version: "1", XMLNS: "http://xspf.org/ns/0/", Title: name}
+	for _, entry := range entries {
+		p.Tracks = append(p.Tracks, xspfTrack{
+			Location: entry.Location,
+			Title:    entry.Title,
+			Creator:  entry.Artist,
+			Album:    entry.Album,
+			Image:    entry.Cover,
+			Duration: entry.Duration * 1000,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(p)
+}
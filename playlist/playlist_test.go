@@ -0,0 +1,147 @@
+package playlist
+
+import (
+	"strings"
+	"testing"
+)
+
+const testM3U = `#EXTM3U
+#EXTINF:215 tvg-id="x",Some Artist - Some Song
+https://www.youtube.com/watch?v=abc
+# comment
+#EXTINF:-1,Untitled
+/music/local file.mp3
+`
+
+const testPLS = `[playlist]
+File2=https://example.com/2.mp3
+Title1=Artist One - First
+File1=https://example.com/1.mp3
+Length1=120
+NumberOfEntries=2
+Version=2
+`
+
+const testXSPF = `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track>
+      <location>https://example.com/a.ogg</location>
+      <title>Song A</title>
+      <creator>Artist A</creator>
+      <duration>200400</duration>
+    </track>
+  </trackList>
+</playlist>`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, data, format string
+		want               []Entry
+	}{
+		{"m3u", testM3U, FormatM3U, []Entry{
+			{Artist: "Some Artist", Title: "Some Song", Duration: 215, Location: "https://www.youtube.com/watch?v=abc"},
+			{Title: "Untitled", Location: "/music/local file.mp3"},
+		}},
+		{"pls", testPLS, FormatPLS, []Entry{
+			{Artist: "Artist One", Title: "First", Duration: 120, Location: "https://example.com/1.mp3"},
+			{Location: "https://example.com/2.mp3"},
+		}},
+		{"xspf", testXSPF, FormatXSPF, []Entry{
+			{Artist: "Artist A", Title: "Song A", Duration: 200, Location: "https://example.com/a.ogg"},
+		}},
+		{"json list", `[{"source": "Youtube", "id": "abc", "title": "T"}]`, FormatJSON, []Entry{
+			{Source: "Youtube", ID: "abc", Title: "T"},
+		}},
+		{"json object", `{"name": "n", "tracks": [{"artist": "A", "title": "T", "duration": 3}]}`, FormatJSON, []Entry{
+			{Artist: "A", Title: "T", Duration: 3},
+		}},
+	}
+	for _, test := range tests {
+		if format := Sniff([]byte(test.data)); format != test.format {
+			t.Errorf("%s: Sniff = %q, want %q", test.name, format, test.format)
+		}
+		entries, err := Parse(strings.NewReader(test.data), "")
+		if err != nil {
+			t.Errorf("%s: Parse: %v", test.name, err)
+			continue
+		}
+		if len(entries) != len(test.want) {
+			t.Errorf("%s: got %d entries, want %d", test.name, len(entries), len(test.want))
+			continue
+		}
+		for i := range entries {
+			if entries[i] != test.want[i] {
+				t.Errorf("%s: entry %d = %+v, want %+v", test.name, i, entries[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	tests := map[string]string{
+		"list.M3U8":                       FormatM3U,
+		"audio/x-mpegurl":                 FormatM3U,
+		"audio/x-scpls":                   FormatPLS,
+		"application/xspf+xml":            FormatXSPF,
+		"application/json; charset=utf-8": FormatJSON,
+		"text/plain":                      "",
+	}
+	for name, want := range tests {
+		if format := FormatFromName(name); format != want {
+			t.Errorf("FormatFromName(%q) = %q, want %q", name, format, want)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Source: "Youtube", ID: "abc", Title: "Song", Artist: "Artist", Album: "Album", Cover: "https://example.com/c.jpg", Duration: 201, Location: "https://youtu.be/abc"},
+		{Title: "No Artist", Location: "https://example.com/b.mp3"},
+	}
+	for _, format := range []string{FormatM3U, FormatXSPF, FormatJSON} {
+		var b strings.Builder
+		if err := Write(&b, entries, format, "test"); err != nil {
+			t.Fatalf("%s: Write: %v", format, err)
+		}
+		parsed, err := Parse(strings.NewReader(b.String()), "")
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", format, err)
+		}
+		if len(parsed) != len(entries) {
+			t.Fatalf("%s: got %d entries, want %d", format, len(parsed), len(entries))
+		}
+		for i, entry := range parsed {
+			want := entries[i]
+			if format != FormatJSON {
+				//only the JSON format keeps the source and ID
+				want.Source, want.ID = "", ""
+			}
+			if format == FormatM3U {
+				want.Album, want.Cover = "", ""
+			}
+			if entry != want {
+				t.Errorf("%s: entry %d = %+v, want %+v", format, i, entry, want)
+			}
+		}
+	}
+}
+
+func TestWriteM3UWithoutLocation(t *testing.T) {
+	entries := []Entry{
+		{Source: "Subsonic", ID: "42", Title: "Song", Artist: "Artist", Duration: 201},
+		{Source: "Subsonic", ID: "43"},
+	}
+	var b strings.Builder
+	if err := Write(&b, entries, FormatM3U, "test"); err != nil {
+		t.Fatal("Write: ", err)
+	}
+	parsed, err := Parse(strings.NewReader(b.String()), "")
+	if err != nil {
+		t.Fatal("Parse: ", err)
+	}
+	want := Entry{Title: "Song", Artist: "Artist", Duration: 201, Location: "Artist - Song"}
+	if len(parsed) != 1 || parsed[0] != want {
+		t.Errorf("Parse() = %+v, want [%+v]", parsed, want)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package playlist
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//ErrNotFound is returned when a playlist does not exist
+var ErrNotFound = errors.New("playlist not found")
+
+//Playlist is a named list of tracks saved on the server
+type Playlist struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Tracks  []Entry   `json:"tracks"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+//Store stores saved playlists
+type Store interface {
+	//List returns all playlists sorted by name, without their tracks
+	List() ([]Playlist, error)
+	Get(id string) (Playlist, error)
+	Create(name string) (Playlist, error)
+	//Update atomically modifies a playlist with update and saves it, unless update fails
+	Update(id string, update func(p *Playlist) error) (Playlist, error)
+	Delete(id string) error
+}
+
+//MemoryStore is a Store which keeps playlists in memory
+type MemoryStore struct {
+	mux       sync.Mutex
+	playlists map[string]Playlist
+	//save persists a playlist before it is stored in memory, deleted is set when it is being deleted
+	save func(p Playlist, deleted bool) error
+}
+
+//NewMemoryStore returns a new empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{playlists: make(map[string]Playlist)}
+}
+
+func copyPlaylist(p Playlist) Playlist {
+	p.Tracks = append([]Entry(nil), p.Tracks...)
+	return p
+}
+
+//List returns all playlists sorted by name, without their tracks
+func (store *MemoryStore) List() (playlists []Playlist, err error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	playlists = make([]Playlist, 0, len(store.playlists))
+	for _, p := range store.playlists {
+		p.Tracks = nil
+		playlists = append(playlists, p)
+	}
+	sort.Slice(playlists, func(i, j int) bool {
+		return strings.ToLower(playlists[i].Name) < strings.ToLower(playlists[j].Name)
+	})
+	return
+}
+
+//Get returns the playlist with the provided id
+func (store *MemoryStore) Get(id string) (Playlist, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	p, ok := store.playlists[id]
+	if !ok {
+		return Playlist{}, ErrNotFound
+	}
+	return copyPlaylist(p), nil
+}
+
+//Create creates an empty playlist
+func (store *MemoryStore) Create(name string) (p Playlist, err error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	now := time.Now()
+	p = Playlist{ID: common.GenerateID(), Name: name, Tracks: []Entry{}, Created: now, Updated: now}
+	if store.save != nil {
+		if err = store.save(p, false); err != nil {
+			return Playlist{}, err
+		}
+	}
+	store.playlists[p.ID] = p
+	return
+}
+
+//Update atomically modifies a playlist with update and saves it, unless update fails
+func (store *MemoryStore) Update(id string, update func(p *Playlist) error) (Playlist, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	p, ok := store.playlists[id]
+	if !ok {
+		return Playlist{}, ErrNotFound
+	}
+	p = copyPlaylist(p)
+	if err := update(&p); err != nil {
+		return Playlist{}, err
+	}
+	p.ID = id
+	p.Updated = time.Now()
+	if store.save != nil {
+		if err := store.save(p, false); err != nil {
+			return Playlist{}, err
+		}
+	}
+	store.playlists[id] = p
+	return copyPlaylist(p), nil
+}
+
+//Delete deletes a playlist
+func (store *MemoryStore) Delete(id string) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	p, ok := store.playlists[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if store.save != nil {
+		if err := store.save(p, true); err != nil {
+			return err
+		}
+	}
+	delete(store.playlists, id)
+	return nil
+}
+
+//FileStore is a Store which keeps each playlist in a JSON file of a directory
+type FileStore struct {
+	MemoryStore
+	dir string
+}
+
+//NewFileStore returns a FileStore backed by dir, loading the playlists stored in it
+func NewFileStore(dir string) (store *FileStore, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	store = &FileStore{MemoryStore: *NewMemoryStore(), dir: dir}
+	store.save = store.saveFile
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var p Playlist
+		if err = json.Unmarshal(data, &p); err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+		if len(p.ID) == 0 {
+			continue
+		}
+		store.playlists[p.ID] = p
+	}
+	return
+}
+
+func (store *FileStore) path(id string) string {
+	return filepath.Join(store.dir, filepath.Base(id)+".json")
+}
+
+func (store *FileStore) saveFile(p Playlist, deleted bool) error {
+	path := store.path(p.ID)
+	if deleted {
+		return errors.WithStack(os.Remove(path))
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := ioutil.TempFile(store.dir, ".playlist-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), path))
+}
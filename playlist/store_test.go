@@ -0,0 +1,57 @@
+package playlist
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "playlists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal("NewFileStore: ", err)
+	}
+	p, err := store.Create("Road trip")
+	if err != nil {
+		t.Fatal("Create: ", err)
+	}
+	_, err = store.Update(p.ID, func(p *Playlist) error {
+		p.Name = "Summer"
+		p.Tracks = append(p.Tracks, Entry{Source: "youtube", ID: "abc", Title: "Song"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Update: ", err)
+	}
+	if _, err = store.Update("missing", func(p *Playlist) error { return nil }); err != ErrNotFound {
+		t.Errorf("Update(missing) = %v, want ErrNotFound", err)
+	}
+	reloaded, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal("NewFileStore: ", err)
+	}
+	p, err = reloaded.Get(p.ID)
+	if err != nil {
+		t.Fatal("Get: ", err)
+	}
+	if p.Name != "Summer" || len(p.Tracks) != 1 || p.Tracks[0].Source != "youtube" || p.Tracks[0].ID != "abc" {
+		t.Errorf("reloaded playlist = %+v", p)
+	}
+	if list, _ := reloaded.List(); len(list) != 1 || list[0].Tracks != nil {
+		t.Errorf("List() = %+v", list)
+	}
+	if err = reloaded.Delete(p.ID); err != nil {
+		t.Fatal("Delete: ", err)
+	}
+	if _, err = reloaded.Get(p.ID); err != ErrNotFound {
+		t.Errorf("Get(deleted) = %v, want ErrNotFound", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left after Delete", len(files))
+	}
+}
@@ -0,0 +1,34 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+
+	"github.com/TrungNguyen1909/MusicStream/rpcplugin"
+)
+
+//main runs the source as an out-of-process plugin, it's not used when this is built as a Go plugin
+func main() {
+	client, err := NewClient()
+	if err != nil {
+		log.Fatalf("[%s] NewClient: %+v", Name, err)
+	}
+	rpcplugin.Serve(client)
+}
@@ -0,0 +1,364 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package rpcplugin
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//exitTimeout is how long a plugin's process is given to exit after its stdin is closed, before it's killed
+const exitTimeout = 5 * time.Second
+
+//Source is a MusicSource provided by an out-of-process plugin
+type Source struct {
+	client      *rpc.Client
+	cmd         *exec.Cmd
+	name        string
+	displayName string
+	config      []common.ConfigOption
+	coverArt    bool
+}
+
+//pipe joins a process' stdout and stdin into a connection
+type pipe struct {
+	io.ReadCloser
+	stdin io.WriteCloser
+}
+
+func (p pipe) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p pipe) Close() error {
+	err := p.stdin.Close()
+	p.ReadCloser.Close()
+	return err
+}
+
+//Start starts the plugin's executable name with args, its stderr is forwarded to the server's
+func Start(name string, args ...string) (source *Source, err error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	source, err = NewSource(pipe{stdout, stdin})
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	source.cmd = cmd
+	return
+}
+
+//NewSource returns a Source which talks to a plugin over conn
+func NewSource(conn io.ReadWriteCloser) (*Source, error) {
+	source := &Source{client: jsonrpc.NewClient(conn)}
+	var info InfoReply
+	if err := source.call("Info", InfoArgs{ProtocolVersion: ProtocolVersion}, &info); err != nil {
+		source.client.Close()
+		return nil, err
+	}
+	if info.ProtocolVersion != ProtocolVersion {
+		source.client.Close()
+		return nil, errors.Errorf("plugin %s speaks protocol version %d instead of %d", info.Name, info.ProtocolVersion, ProtocolVersion)
+	}
+	if len(info.Name) == 0 {
+		source.client.Close()
+		return nil, errors.New("plugin has no name")
+	}
+	source.name = info.Name
+	source.displayName = info.DisplayName
+	source.config = info.Config
+	source.coverArt = info.CoverArt
+	return source, nil
+}
+
+func (source *Source) call(method string, args interface{}, reply interface{}) error {
+	return errors.WithStack(source.client.Call(serviceName+"."+method, args, reply))
+}
+
+//Name returns the plugin's name
+func (source *Source) Name() string {
+	return source.name
+}
+
+//DisplayName returns the plugin's display name
+func (source *Source) DisplayName() string {
+	return source.displayName
+}
+
+//ConfigSchema returns the plugin's options
+func (source *Source) ConfigSchema() []common.ConfigOption {
+	return source.config
+}
+
+//Configure sends the validated configuration to the plugin
+func (source *Source) Configure(config map[string]interface{}) error {
+	if len(source.config) == 0 {
+		return nil
+	}
+	return source.call("Configure", ConfigureArgs{Config: config}, &Empty{})
+}
+
+//CheckHealth runs the plugin's health check, which also fails if the plugin has exited
+func (source *Source) CheckHealth() error {
+	return source.call("CheckHealth", Empty{}, &Empty{})
+}
+
+//Search searches for tracks on the plugin
+func (source *Source) Search(query string) (tracks []common.Track, err error) {
+	var reply SearchReply
+	if err = source.call("Search", SearchArgs{Query: query}, &reply); err != nil {
+		return
+	}
+	if len(reply.InvalidQuery) > 0 {
+		return nil, &common.InvalidQueryError{Reason: reply.InvalidQuery}
+	}
+	tracks = make([]common.Track, len(reply.Tracks))
+	for i, info := range reply.Tracks {
+		tracks[i] = newTrack(source, info)
+	}
+	return
+}
+
+//GetCoverArt returns the cover art id from the plugin
+func (source *Source) GetCoverArt(id string) (body io.ReadCloser, contentType string, err error) {
+	if !source.coverArt {
+		return nil, "", errors.Errorf("plugin %s does not serve cover art", source.name)
+	}
+	var reply CoverArtReply
+	if err = source.call("GetCoverArt", CoverArtArgs{ID: id}, &reply); err != nil {
+		return nil, "", err
+	}
+	return ioutil.NopCloser(bytes.NewReader(reply.Data)), reply.ContentType, nil
+}
+
+//Close closes the connection to the plugin and waits for its process to exit
+func (source *Source) Close() error {
+	err := source.client.Close()
+	if source.cmd == nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		source.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(exitTimeout):
+		log.Printf("[rpcplugin] Killing plugin %s", source.name)
+		source.cmd.Process.Kill()
+		<-exited
+	}
+	return err
+}
+
+//Track is a track of an out-of-process plugin
+type Track struct {
+	source *Source
+	info   TrackInfo
+}
+
+//transcriptTrack is a Track which implements common.TrackWithTranscript,
+//it's a separate type because the lyrics providers tell transcripts from lyrics by the track's interfaces
+type transcriptTrack struct {
+	*Track
+}
+
+func newTrack(source *Source, info TrackInfo) common.Track {
+	track := &Track{source: source, info: info}
+	if info.Transcript {
+		return transcriptTrack{track}
+	}
+	return track
+}
+
+func (track *Track) ID() string {
+	return track.info.ID
+}
+
+func (track *Track) IsRadio() bool {
+	return track.info.IsRadio
+}
+
+func (track *Track) Title() string {
+	return track.info.Title
+}
+
+func (track *Track) Artist() string {
+	return track.info.Artist
+}
+
+func (track *Track) Artists() string {
+	return track.info.Artists
+}
+
+func (track *Track) Album() string {
+	return track.info.Album
+}
+
+func (track *Track) ISRC() string {
+	return track.info.ISRC
+}
+
+func (track *Track) Href() string {
+	return track.info.Href
+}
+
+func (track *Track) CoverURL() string {
+	return track.info.CoverURL
+}
+
+func (track *Track) Duration() int {
+	return track.info.Duration
+}
+
+func (track *Track) SpotifyURI() string {
+	return track.info.SpotifyURI
+}
+
+func (track *Track) PlayID() string {
+	return track.info.PlayID
+}
+
+//Source returns the name of the track's plugin
+func (track *Track) Source() string {
+	return track.source.name
+}
+
+//Populate populates the track on the plugin and updates its metadata
+func (track *Track) Populate() (err error) {
+	var info TrackInfo
+	if err = track.source.call("Populate", TrackArgs{Track: track.info}, &info); err != nil {
+		return
+	}
+	track.info = info
+	return
+}
+
+//GetLyrics returns the track's lyrics from the plugin, they're empty if it doesn't support them
+func (track *Track) GetLyrics() (lyrics common.LyricsResult, err error) {
+	if !track.info.Lyrics {
+		return
+	}
+	err = track.source.call("GetLyrics", TrackArgs{Track: track.info}, &lyrics)
+	return
+}
+
+//GetTranslatedLyrics returns the track's lyrics translated into language from the plugin,
+//they're untranslated if it doesn't support translations
+func (track *Track) GetTranslatedLyrics(language string) (lyrics common.LyricsResult, err error) {
+	if !track.info.TranslatedLyrics {
+		return track.GetLyrics()
+	}
+	err = track.source.call("GetTranslatedLyrics", LyricsArgs{Track: track.info, Language: language}, &lyrics)
+	return
+}
+
+//GetTranscript returns the track's transcript from the plugin
+func (track transcriptTrack) GetTranscript(language string) (transcript common.LyricsResult, err error) {
+	err = track.source.call("GetTranscript", LyricsArgs{Track: track.info, Language: language}, &transcript)
+	return
+}
+
+//Stream opens the track's stream on the plugin, its body is read over the connection
+func (track *Track) Stream() (common.Stream, error) {
+	var reply StreamReply
+	if err := track.source.call("Stream", TrackArgs{Track: track.info}, &reply); err != nil {
+		return nil, err
+	}
+	return &stream{
+		format: reply.Format,
+		info:   reply.Info,
+		body:   &streamBody{source: track.source, id: reply.Stream},
+	}, nil
+}
+
+type stream struct {
+	format int
+	info   common.StreamInfo
+	body   *streamBody
+}
+
+func (s *stream) Format() int {
+	return s.format
+}
+
+func (s *stream) Body() io.ReadCloser {
+	return s.body
+}
+
+func (s *stream) Info() common.StreamInfo {
+	return s.info
+}
+
+//streamBody reads a plugin's stream with Plugin.Read calls
+type streamBody struct {
+	source *Source
+	id     int64
+	buffer []byte
+	eof    bool
+	closed bool
+}
+
+func (body *streamBody) Read(p []byte) (n int, err error) {
+	for len(body.buffer) == 0 {
+		if body.eof {
+			return 0, io.EOF
+		}
+		var reply ReadReply
+		if err = body.source.call("Read", ReadArgs{Stream: body.id, Size: readSize}, &reply); err != nil {
+			return 0, err
+		}
+		body.buffer, body.eof = reply.Data, reply.EOF
+	}
+	n = copy(p, body.buffer)
+	body.buffer = body.buffer[n:]
+	return
+}
+
+func (body *streamBody) Close() error {
+	if body.closed {
+		return nil
+	}
+	body.closed = true
+	return body.source.call("CloseStream", StreamArgs{Stream: body.id}, &Empty{})
+}
@@ -0,0 +1,149 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package rpcplugin runs music sources as subprocesses, which speak JSON-RPC 1.0 over their stdin and stdout.
+//
+//This lets sources be built independently of the server, with any toolchain or language, unlike Go plugins.
+//The protocol is described in docs/PLUGINS.md
+package rpcplugin
+
+import (
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+//ProtocolVersion is the version of the protocol, the server refuses plugins that speak another one
+const ProtocolVersion = 1
+
+//serviceName is the prefix of all methods, e.g. Plugin.Search
+const serviceName = "Plugin"
+
+//readSize is the maximum number of bytes requested by each Plugin.Read call
+const readSize = 32 * 1024
+
+//maxCoverArtSize is the largest cover art returned by Plugin.GetCoverArt
+const maxCoverArtSize = 10 * 1024 * 1024
+
+//Empty is the argument and reply of methods which don't have any
+type Empty struct{}
+
+//InfoArgs is the argument of Plugin.Info
+type InfoArgs struct {
+	ProtocolVersion int `json:"protocolVersion"`
+}
+
+//InfoReply is the reply of Plugin.Info
+type InfoReply struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	Name            string `json:"name"`
+	DisplayName     string `json:"displayName"`
+	//Config describes the plugin's options, Plugin.Configure is only called if there's any
+	Config []common.ConfigOption `json:"config,omitempty"`
+	//CoverArt specifies whether Plugin.GetCoverArt is supported
+	CoverArt bool `json:"coverArt,omitempty"`
+}
+
+//ConfigureArgs is the argument of Plugin.Configure
+type ConfigureArgs struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+//SearchArgs is the argument of Plugin.Search
+type SearchArgs struct {
+	Query string `json:"query"`
+}
+
+//SearchReply is the reply of Plugin.Search
+type SearchReply struct {
+	Tracks []TrackInfo `json:"tracks"`
+	//InvalidQuery is the reason why the query can't be searched, if it's not the source's fault
+	InvalidQuery string `json:"invalidQuery,omitempty"`
+}
+
+//TrackInfo is a track's metadata, sent by plugins in replies and back to them in requests about the track
+type TrackInfo struct {
+	//Handle identifies the track in the plugin, it is chosen by the plugin and opaque to the server
+	Handle     string `json:"handle"`
+	ID         string `json:"id"`
+	IsRadio    bool   `json:"isRadio"`
+	Title      string `json:"title"`
+	Artist     string `json:"artist"`
+	Artists    string `json:"artists"`
+	Album      string `json:"album"`
+	ISRC       string `json:"isrc"`
+	Href       string `json:"href"`
+	CoverURL   string `json:"cover"`
+	Duration   int    `json:"duration"`
+	SpotifyURI string `json:"spotifyURI"`
+	PlayID     string `json:"playId"`
+	//Lyrics specifies whether Plugin.GetLyrics is supported for the track
+	Lyrics bool `json:"lyrics"`
+	//TranslatedLyrics specifies whether Plugin.GetTranslatedLyrics is supported for the track
+	TranslatedLyrics bool `json:"translatedLyrics,omitempty"`
+	//Transcript specifies whether Plugin.GetTranscript is supported for the track
+	Transcript bool `json:"transcript,omitempty"`
+}
+
+//TrackArgs is the argument of methods about a track
+type TrackArgs struct {
+	Track TrackInfo `json:"track"`
+}
+
+//LyricsArgs is the argument of Plugin.GetTranslatedLyrics and Plugin.GetTranscript
+type LyricsArgs struct {
+	Track    TrackInfo `json:"track"`
+	Language string    `json:"language"`
+}
+
+//StreamReply is the reply of Plugin.Stream
+type StreamReply struct {
+	//Stream identifies the opened stream in Plugin.Read and Plugin.CloseStream
+	Stream int64 `json:"stream"`
+	//Format is either common.RawStream or common.FFmpegStream
+	Format int               `json:"format"`
+	Info   common.StreamInfo `json:"info"`
+}
+
+//ReadArgs is the argument of Plugin.Read
+type ReadArgs struct {
+	Stream int64 `json:"stream"`
+	Size   int   `json:"size"`
+}
+
+//ReadReply is the reply of Plugin.Read
+type ReadReply struct {
+	//Data is base64 encoded in JSON
+	Data []byte `json:"data"`
+	EOF  bool   `json:"eof"`
+}
+
+//CoverArtArgs is the argument of Plugin.GetCoverArt
+type CoverArtArgs struct {
+	ID string `json:"id"`
+}
+
+//CoverArtReply is the reply of Plugin.GetCoverArt
+type CoverArtReply struct {
+	//Data is base64 encoded in JSON
+	Data        []byte `json:"data"`
+	ContentType string `json:"contentType"`
+}
+
+//StreamArgs is the argument of Plugin.CloseStream
+type StreamArgs struct {
+	Stream int64 `json:"stream"`
+}
@@ -0,0 +1,250 @@
+package rpcplugin
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+type testSource struct{}
+
+func (testSource) Name() string        { return "Test" }
+func (testSource) DisplayName() string { return "T" }
+func (testSource) Search(query string) ([]common.Track, error) {
+	if query == "fail" {
+		return nil, errors.New("search failed")
+	}
+	if query == "invalid" {
+		return nil, common.NewInvalidQueryError("invalid query")
+	}
+	return []common.Track{&testTrack{title: query}}, nil
+}
+
+type testTrack struct {
+	common.DefaultTrack
+	title     string
+	populated bool
+}
+
+func (track *testTrack) ID() string      { return "id-" + track.title }
+func (track *testTrack) Title() string   { return track.title }
+func (track *testTrack) IsRadio() bool   { return false }
+func (track *testTrack) Album() string   { return track.album() }
+func (track *testTrack) Populate() error { track.populated = true; return nil }
+func (track *testTrack) album() string {
+	if track.populated {
+		return "Populated"
+	}
+	return ""
+}
+func (track *testTrack) GetLyrics() (common.LyricsResult, error) {
+	return common.LyricsResult{RawLyrics: "la la la"}, nil
+}
+func (track *testTrack) Stream() (common.Stream, error) {
+	return testStream{}, nil
+}
+
+type testStream struct{}
+
+func (testStream) Format() int { return common.FFmpegStream }
+func (testStream) Body() io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(testData))
+}
+func (testStream) Info() common.StreamInfo {
+	return common.StreamInfo{Codec: common.CodecMP3, Seekable: true}
+}
+
+var testData = bytes.Repeat([]byte("0123456789"), readSize/4)
+
+func TestSource(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go ServeConn(testSource{}, serverConn)
+	source, err := NewSource(clientConn)
+	if err != nil {
+		t.Fatal("NewSource: ", err)
+	}
+	defer source.Close()
+	if source.Name() != "Test" || source.DisplayName() != "T" {
+		t.Errorf("Name() = %q, DisplayName() = %q", source.Name(), source.DisplayName())
+	}
+	if _, err = source.Search("fail"); err == nil {
+		t.Error("Search should return the plugin's error")
+	}
+	if _, err = source.Search("invalid"); !common.IsInvalidQuery(err) || err.Error() != "invalid query" {
+		t.Errorf("Search() error = %v, want the plugin's InvalidQueryError", err)
+	}
+	tracks, err := source.Search("song")
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("Search() = %v, %v", tracks, err)
+	}
+	track := tracks[0]
+	if track.Title() != "song" || track.ID() != "id-song" {
+		t.Errorf("track = %q, %q", track.Title(), track.ID())
+	}
+	if err = track.Populate(); err != nil || track.Album() != "Populated" {
+		t.Errorf("after Populate(): %v, Album() = %q", err, track.Album())
+	}
+	lyrics, err := track.(common.TrackWithLyrics).GetLyrics()
+	if err != nil || lyrics.RawLyrics != "la la la" {
+		t.Errorf("GetLyrics() = %v, %v", lyrics, err)
+	}
+	stream, err := track.Stream()
+	if err != nil {
+		t.Fatal("Stream: ", err)
+	}
+	info := stream.(common.StreamWithInfo).Info()
+	if stream.Format() != common.FFmpegStream || info.Codec != common.CodecMP3 || info.Seekable {
+		t.Errorf("stream format = %d, info = %+v", stream.Format(), info)
+	}
+	body := stream.Body()
+	data, err := ioutil.ReadAll(body)
+	if err != nil || !bytes.Equal(data, testData) {
+		t.Errorf("read %d bytes, %v, want %d bytes", len(data), err, len(testData))
+	}
+	if err = body.Close(); err != nil {
+		t.Error("Close: ", err)
+	}
+}
+
+//testSourceByID looks tracks up by their ID, their streams fail unless they are populated
+type testSourceByID struct {
+	testSource
+}
+
+func (testSourceByID) GetTrack(id string) (common.Track, error) {
+	return &testTrack{title: strings.TrimPrefix(id, "id-")}, nil
+}
+
+type populatedTrack struct {
+	*testTrack
+}
+
+func (track populatedTrack) Stream() (common.Stream, error) {
+	if !track.populated {
+		return nil, errors.New("Metadata not populated")
+	}
+	return testStream{}, nil
+}
+
+func (testSourceByID) Search(query string) ([]common.Track, error) {
+	return []common.Track{populatedTrack{&testTrack{title: query}}}, nil
+}
+
+func TestPopulateForgottenTrack(t *testing.T) {
+	p := newPlugin(testSourceByID{})
+	var search SearchReply
+	if err := p.Search(SearchArgs{Query: "song"}, &search); err != nil {
+		t.Fatal("Search: ", err)
+	}
+	info := search.Tracks[0]
+	p.tracks = make(map[string]common.Track)
+	var populated TrackInfo
+	if err := p.Populate(TrackArgs{Track: info}, &populated); err != nil {
+		t.Fatal("Populate: ", err)
+	}
+	if populated.Handle == info.Handle || populated.Album != "Populated" {
+		t.Errorf("Populate() = %+v, want a new handle of the populated track", populated)
+	}
+	track, known, err := p.track(populated)
+	if err != nil || !known || !track.(*testTrack).populated {
+		t.Errorf("the populated track was not remembered: %v, %v, %v", track, known, err)
+	}
+}
+
+//testCoverSource serves the cover art named "cover"
+type testCoverSource struct {
+	testSource
+}
+
+func (testCoverSource) GetCoverArt(id string) (io.ReadCloser, string, error) {
+	if id != "cover" {
+		return nil, "", errors.New("cover art not found")
+	}
+	return ioutil.NopCloser(strings.NewReader("image")), "image/png", nil
+}
+
+func TestGetCoverArt(t *testing.T) {
+	for _, plugin := range []common.MusicSource{testSource{}, testCoverSource{}} {
+		serverConn, clientConn := net.Pipe()
+		go ServeConn(plugin, serverConn)
+		source, err := NewSource(clientConn)
+		if err != nil {
+			t.Fatal("NewSource: ", err)
+		}
+		body, contentType, err := source.GetCoverArt("cover")
+		if _, supported := plugin.(common.MusicSourceWithCoverArt); !supported {
+			if err == nil {
+				t.Error("GetCoverArt() should fail if the plugin does not serve cover art")
+			}
+		} else if err != nil || contentType != "image/png" {
+			t.Errorf("GetCoverArt() = %q, %v", contentType, err)
+		} else if data, _ := ioutil.ReadAll(body); string(data) != "image" {
+			t.Errorf("GetCoverArt() = %q", data)
+		}
+		if _, _, err = source.GetCoverArt("other"); err == nil {
+			t.Error("GetCoverArt() should return the plugin's error")
+		}
+		source.Close()
+	}
+}
+
+//testLyricsSource returns a track without lyrics and a track with a translated transcript
+type testLyricsSource struct {
+	testSource
+}
+
+type transcriptTestTrack struct {
+	*testTrack
+}
+
+func (track transcriptTestTrack) GetTranslatedLyrics(language string) (common.LyricsResult, error) {
+	return common.LyricsResult{RawLyrics: "la la la " + language}, nil
+}
+
+func (track transcriptTestTrack) GetTranscript(language string) (common.LyricsResult, error) {
+	return common.LyricsResult{RawLyrics: "transcript " + language}, nil
+}
+
+func (testLyricsSource) Search(query string) ([]common.Track, error) {
+	return []common.Track{&common.DefaultTrack{}, transcriptTestTrack{&testTrack{title: query}}}, nil
+}
+
+func TestLyrics(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go ServeConn(testLyricsSource{}, serverConn)
+	source, err := NewSource(clientConn)
+	if err != nil {
+		t.Fatal("NewSource: ", err)
+	}
+	defer source.Close()
+	tracks, err := source.Search("video")
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("Search() = %v, %v", tracks, err)
+	}
+	plain, video := tracks[0], tracks[1]
+	if _, ok := plain.(common.TrackWithTranscript); ok {
+		t.Error("a track without a transcript should not implement common.TrackWithTranscript")
+	}
+	lyrics, err := plain.(common.TrackWithLyrics).GetLyrics()
+	if err != nil || lyrics.RawLyrics != "" {
+		t.Errorf("GetLyrics() of a track without lyrics = %v, %v", lyrics, err)
+	}
+	lyrics, err = video.(common.TrackWithTranslatedLyrics).GetTranslatedLyrics("vi")
+	if err != nil || lyrics.RawLyrics != "la la la vi" {
+		t.Errorf("GetTranslatedLyrics() = %v, %v", lyrics, err)
+	}
+	ttrack, ok := video.(common.TrackWithTranscript)
+	if !ok {
+		t.Fatal("the track's transcript is not forwarded")
+	}
+	lyrics, err = ttrack.GetTranscript("en")
+	if err != nil || lyrics.RawLyrics != "transcript en" {
+		t.Errorf("GetTranscript() = %v, %v", lyrics, err)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package rpcplugin
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//maxTracks is the number of tracks remembered by a plugin, older tracks' handles become invalid
+const maxTracks = 4096
+
+//plugin is the RPC service which exposes a MusicSource
+type plugin struct {
+	source common.MusicSource
+	mux    sync.Mutex
+	tracks map[string]common.Track
+	//order contains the handles of tracks, from the oldest one
+	order      []string
+	nextHandle int64
+	streams    map[int64]io.ReadCloser
+	nextStream int64
+}
+
+func newPlugin(source common.MusicSource) *plugin {
+	return &plugin{
+		source:  source,
+		tracks:  make(map[string]common.Track),
+		streams: make(map[int64]io.ReadCloser),
+	}
+}
+
+//Serve serves source over the process' stdin and stdout until stdin is closed.
+//It should be called from the main function of a plugin's executable, e.g.
+//
+//	func main() {
+//		client, err := NewClient()
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		rpcplugin.Serve(client)
+//	}
+//
+//Anything written to os.Stdout afterwards is redirected to os.Stderr so it doesn't corrupt the protocol
+func Serve(source common.MusicSource) {
+	conn := struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{os.Stdin, os.Stdout, os.Stdin}
+	os.Stdout = os.Stderr
+	log.SetOutput(os.Stderr)
+	ServeConn(source, conn)
+}
+
+//ServeConn serves source over conn until it is closed
+func ServeConn(source common.MusicSource, conn io.ReadWriteCloser) {
+	p := newPlugin(source)
+	defer p.closeStreams()
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, p); err != nil {
+		log.Panic("[rpcplugin] RegisterName: ", err)
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+func (p *plugin) closeStreams() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for id, stream := range p.streams {
+		stream.Close()
+		delete(p.streams, id)
+	}
+}
+
+//addTrack remembers track and returns its info
+func (p *plugin) addTrack(track common.Track) TrackInfo {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.nextHandle++
+	handle := strconv.FormatInt(p.nextHandle, 10)
+	p.tracks[handle] = track
+	p.order = append(p.order, handle)
+	if len(p.order) > maxTracks {
+		delete(p.tracks, p.order[0])
+		p.order = p.order[1:]
+	}
+	return newTrackInfo(handle, track)
+}
+
+//track returns the track of info, or looks it up by its ID if the handle was forgotten.
+//known is false if the track was looked up, it is then not remembered
+func (p *plugin) track(info TrackInfo) (track common.Track, known bool, err error) {
+	p.mux.Lock()
+	track, known = p.tracks[info.Handle]
+	p.mux.Unlock()
+	if known {
+		return track, true, nil
+	}
+	if source, ok := p.source.(common.MusicSourceWithTrackByID); ok && len(info.ID) > 0 {
+		track, err = source.GetTrack(info.ID)
+		return track, false, err
+	}
+	return nil, false, errors.Errorf("unknown track handle %q", info.Handle)
+}
+
+func newTrackInfo(handle string, track common.Track) TrackInfo {
+	_, lyrics := track.(common.TrackWithLyrics)
+	_, translatedLyrics := track.(common.TrackWithTranslatedLyrics)
+	_, transcript := track.(common.TrackWithTranscript)
+	return TrackInfo{
+		Handle:           handle,
+		ID:               track.ID(),
+		IsRadio:          track.IsRadio(),
+		Title:            track.Title(),
+		Artist:           track.Artist(),
+		Artists:          track.Artists(),
+		Album:            track.Album(),
+		ISRC:             track.ISRC(),
+		Href:             track.Href(),
+		CoverURL:         track.CoverURL(),
+		Duration:         track.Duration(),
+		SpotifyURI:       track.SpotifyURI(),
+		PlayID:           track.PlayID(),
+		Lyrics:           lyrics,
+		TranslatedLyrics: translatedLyrics,
+		Transcript:       transcript,
+	}
+}
+
+func (p *plugin) Info(args InfoArgs, reply *InfoReply) error {
+	*reply = InfoReply{
+		ProtocolVersion: ProtocolVersion,
+		Name:            p.source.Name(),
+		DisplayName:     p.source.DisplayName(),
+	}
+	if source, ok := p.source.(common.ConfigurableMusicSource); ok {
+		reply.Config = source.ConfigSchema()
+	}
+	_, reply.CoverArt = p.source.(common.MusicSourceWithCoverArt)
+	return nil
+}
+
+func (p *plugin) Configure(args ConfigureArgs, reply *Empty) error {
+	source, ok := p.source.(common.ConfigurableMusicSource)
+	if !ok {
+		return errors.New("plugin does not have any options")
+	}
+	//values are validated by the server, but numbers are decoded as float64
+	config, err := common.ValidateConfig(source.ConfigSchema(), args.Config)
+	if err != nil {
+		return err
+	}
+	return source.Configure(config)
+}
+
+func (p *plugin) CheckHealth(args Empty, reply *Empty) error {
+	if checker, ok := p.source.(common.HealthChecker); ok {
+		return checker.CheckHealth()
+	}
+	return nil
+}
+
+func (p *plugin) Search(args SearchArgs, reply *SearchReply) error {
+	tracks, err := p.source.Search(args.Query)
+	if common.IsInvalidQuery(err) {
+		reply.InvalidQuery = err.Error()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	reply.Tracks = make([]TrackInfo, len(tracks))
+	for i, track := range tracks {
+		reply.Tracks[i] = p.addTrack(track)
+	}
+	return nil
+}
+
+//Populate populates the track, a track which was looked up again is remembered with a new handle
+func (p *plugin) Populate(args TrackArgs, reply *TrackInfo) error {
+	track, known, err := p.track(args.Track)
+	if err != nil {
+		return err
+	}
+	if err = track.Populate(); err != nil {
+		return err
+	}
+	if !known {
+		*reply = p.addTrack(track)
+		return nil
+	}
+	*reply = newTrackInfo(args.Track.Handle, track)
+	return nil
+}
+
+func (p *plugin) GetCoverArt(args CoverArtArgs, reply *CoverArtReply) error {
+	source, ok := p.source.(common.MusicSourceWithCoverArt)
+	if !ok {
+		return errors.New("cover art is not supported")
+	}
+	body, contentType, err := source.GetCoverArt(args.ID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxCoverArtSize+1))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(data) > maxCoverArtSize {
+		return errors.New("cover art is too large")
+	}
+	*reply = CoverArtReply{Data: data, ContentType: contentType}
+	return nil
+}
+
+func (p *plugin) GetLyrics(args TrackArgs, reply *common.LyricsResult) (err error) {
+	track, _, err := p.track(args.Track)
+	if err != nil {
+		return err
+	}
+	if ltrack, ok := track.(common.TrackWithLyrics); ok {
+		*reply, err = ltrack.GetLyrics()
+	}
+	return
+}
+
+func (p *plugin) GetTranslatedLyrics(args LyricsArgs, reply *common.LyricsResult) (err error) {
+	track, _, err := p.track(args.Track)
+	if err != nil {
+		return err
+	}
+	if ltrack, ok := track.(common.TrackWithTranslatedLyrics); ok {
+		*reply, err = ltrack.GetTranslatedLyrics(args.Language)
+	} else if ltrack, ok := track.(common.TrackWithLyrics); ok {
+		*reply, err = ltrack.GetLyrics()
+	}
+	return
+}
+
+func (p *plugin) GetTranscript(args LyricsArgs, reply *common.LyricsResult) (err error) {
+	track, _, err := p.track(args.Track)
+	if err != nil {
+		return err
+	}
+	if ttrack, ok := track.(common.TrackWithTranscript); ok {
+		*reply, err = ttrack.GetTranscript(args.Language)
+	}
+	return
+}
+
+func (p *plugin) Stream(args TrackArgs, reply *StreamReply) error {
+	track, _, err := p.track(args.Track)
+	if err != nil {
+		return err
+	}
+	stream, err := track.Stream()
+	if err != nil {
+		return err
+	}
+	reply.Format = stream.Format()
+	if istream, ok := stream.(common.StreamWithInfo); ok {
+		reply.Info = istream.Info()
+	}
+	//the server can't seek in the pipe
+	reply.Info.Seekable = false
+	p.mux.Lock()
+	p.nextStream++
+	reply.Stream = p.nextStream
+	p.streams[reply.Stream] = stream.Body()
+	p.mux.Unlock()
+	return nil
+}
+
+func (p *plugin) Read(args ReadArgs, reply *ReadReply) error {
+	p.mux.Lock()
+	body, ok := p.streams[args.Stream]
+	p.mux.Unlock()
+	if !ok {
+		return errors.Errorf("unknown stream %d", args.Stream)
+	}
+	size := args.Size
+	if size <= 0 || size > readSize {
+		size = readSize
+	}
+	reply.Data = make([]byte, size)
+	n, err := io.ReadFull(body, reply.Data)
+	reply.Data = reply.Data[:n]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		reply.EOF = true
+		return nil
+	}
+	return err
+}
+
+func (p *plugin) CloseStream(args StreamArgs, reply *Empty) error {
+	p.mux.Lock()
+	body, ok := p.streams[args.Stream]
+	delete(p.streams, args.Stream)
+	p.mux.Unlock()
+	if !ok {
+		return errors.Errorf("unknown stream %d", args.Stream)
+	}
+	return body.Close()
+}
@@ -0,0 +1,155 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	broadcastBufferSize = 512
+	//broadcastMaxResyncs is the number of times in a row a subscriber may fall behind the whole buffer before it is disconnected.
+	//Falling behind again is counted in a row unless the subscriber has read a whole buffer of chunks in order since then
+	broadcastMaxResyncs = 5
+	//burstBacklogSamples is the amount of audio, in samples, sent to new subscribers right after they connect
+	burstBacklogSamples = 2 * 48000
+)
+
+var errSlowSubscriber = errors.New("subscriber is too slow")
+
+//broadcastBuffer is a single-producer, multiple-consumer ring buffer of encoded chunks.
+//The producer never waits for subscribers, each of them keeps its own cursor
+type broadcastBuffer struct {
+	slots []atomic.Value
+	mask  int64
+	//head is the chunkID of the latest published chunk
+	head int64
+	//wait holds a chan struct{} which is closed whenever a new chunk is published
+	wait atomic.Value
+}
+
+//broadcastSubscriber reads chunks from a broadcastBuffer
+type broadcastSubscriber struct {
+	b       *broadcastBuffer
+	cursor  int64
+	resyncs int
+	//inOrder is the number of chunks read in order since the last resync
+	inOrder int
+}
+
+func newBroadcastBuffer(size int) *broadcastBuffer {
+	if size <= 0 || size&(size-1) != 0 {
+		panic("broadcastBuffer: size must be a power of two")
+	}
+	b := &broadcastBuffer{
+		slots: make([]atomic.Value, size),
+		mask:  int64(size - 1),
+	}
+	b.wait.Store(make(chan struct{}))
+	return b
+}
+
+//Publish assigns the next chunkID to c and makes it available to all subscribers
+func (b *broadcastBuffer) Publish(c *chunk) {
+	id := atomic.LoadInt64(&b.head) + 1
+	c.chunkID = id
+	b.slots[id&b.mask].Store(c)
+	atomic.StoreInt64(&b.head, id)
+	wait := b.wait.Load().(chan struct{})
+	b.wait.Store(make(chan struct{}))
+	close(wait)
+}
+
+//Subscribe returns a new subscriber which starts at the next published chunk
+func (b *broadcastBuffer) Subscribe() *broadcastSubscriber {
+	return &broadcastSubscriber{b: b, cursor: atomic.LoadInt64(&b.head) + 1}
+}
+
+//SubscribeWithBacklog returns a new subscriber which starts at the earliest chunk
+//published within the last backlog samples that aligned accepts as a starting point.
+//Only the newer half of the buffer is considered so the backlog is not overwritten while being sent
+func (b *broadcastBuffer) SubscribeWithBacklog(backlog int64, aligned func([]byte) bool) *broadcastSubscriber {
+	head := atomic.LoadInt64(&b.head)
+	sub := &broadcastSubscriber{b: b, cursor: head + 1}
+	latest := b.load(head)
+	if latest == nil {
+		return sub
+	}
+	for id := head; id > 0 && head-id < int64(len(b.slots)/2); id-- {
+		c := b.load(id)
+		if c == nil || latest.encoderPos-c.encoderPos > backlog {
+			break
+		}
+		if aligned(c.buffer) {
+			sub.cursor = id
+		}
+	}
+	return sub
+}
+
+func (b *broadcastBuffer) load(id int64) *chunk {
+	c, _ := b.slots[id&b.mask].Load().(*chunk)
+	if c == nil || c.chunkID != id {
+		return nil
+	}
+	return c
+}
+
+//Next returns the next chunk for the subscriber.
+//If there's none, it returns a channel which will be closed when a new chunk is published.
+//A subscriber that falls behind the whole buffer skips ahead to the latest chunk,
+//if it keeps doing so, errSlowSubscriber is returned.
+func (sub *broadcastSubscriber) Next() (c *chunk, wait <-chan struct{}, err error) {
+	wait = sub.b.wait.Load().(chan struct{})
+	head := atomic.LoadInt64(&sub.b.head)
+	if sub.cursor > head {
+		return nil, wait, nil
+	}
+	if head-sub.cursor >= int64(len(sub.b.slots)) {
+		if sub.resync() {
+			return nil, wait, errSlowSubscriber
+		}
+		sub.cursor = head
+	}
+	c = sub.b.load(sub.cursor)
+	if c == nil {
+		//overwritten while being read
+		if sub.resync() {
+			return nil, wait, errSlowSubscriber
+		}
+		sub.cursor = atomic.LoadInt64(&sub.b.head)
+		if c = sub.b.load(sub.cursor); c == nil {
+			return nil, wait, nil
+		}
+	}
+	sub.cursor++
+	if sub.inOrder++; sub.inOrder >= len(sub.b.slots) {
+		sub.resyncs = 0
+	}
+	return c, nil, nil
+}
+
+//resync counts a resync of the subscriber, it returns true if the subscriber should be disconnected
+func (sub *broadcastSubscriber) resync() bool {
+	sub.inOrder = 0
+	sub.resyncs++
+	return sub.resyncs > broadcastMaxResyncs
+}
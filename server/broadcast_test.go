@@ -0,0 +1,186 @@
+package server
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBroadcastBufferOrder(t *testing.T) {
+	b := newBroadcastBuffer(8)
+	sub := b.Subscribe()
+	for i := 0; i < 5; i++ {
+		b.Publish(&chunk{encoderPos: int64(i)})
+	}
+	for i := 0; i < 5; i++ {
+		c, _, err := sub.Next()
+		if err != nil || c == nil {
+			t.Fatalf("sub.Next() = %v, %v", c, err)
+		}
+		if c.encoderPos != int64(i) || c.chunkID != int64(i+1) {
+			t.Errorf("chunk %d: encoderPos = %d, chunkID = %d", i, c.encoderPos, c.chunkID)
+		}
+	}
+	if c, wait, _ := sub.Next(); c != nil || wait == nil {
+		t.Error("sub.Next() should wait when there's no new chunk")
+	}
+}
+
+func TestBroadcastBufferWait(t *testing.T) {
+	b := newBroadcastBuffer(8)
+	sub := b.Subscribe()
+	_, wait, _ := sub.Next()
+	select {
+	case <-wait:
+		t.Fatal("wait is closed before a chunk is published")
+	default:
+	}
+	b.Publish(&chunk{})
+	select {
+	case <-wait:
+	default:
+		t.Fatal("wait is not closed after a chunk is published")
+	}
+	if c, _, _ := sub.Next(); c == nil || c.chunkID != 1 {
+		t.Error("sub.Next() did not return the published chunk")
+	}
+}
+
+func TestBroadcastBufferSkipAhead(t *testing.T) {
+	b := newBroadcastBuffer(8)
+	sub := b.Subscribe()
+	for i := 0; i < 20; i++ {
+		b.Publish(&chunk{})
+	}
+	c, _, err := sub.Next()
+	if err != nil {
+		t.Fatal("sub.Next(): ", err)
+	}
+	if c == nil || c.chunkID != 20 {
+		t.Errorf("lagging subscriber should skip to the latest chunk, got %v", c)
+	}
+}
+
+func TestBroadcastBufferSlowSubscriber(t *testing.T) {
+	b := newBroadcastBuffer(8)
+	sub := b.Subscribe()
+	var err error
+	for i := 0; i <= broadcastMaxResyncs && err == nil; i++ {
+		for j := 0; j < 20; j++ {
+			b.Publish(&chunk{})
+		}
+		_, _, err = sub.Next()
+	}
+	if err != errSlowSubscriber {
+		t.Errorf("err = %v, want errSlowSubscriber", err)
+	}
+}
+
+func TestBroadcastBufferBacklog(t *testing.T) {
+	b := newBroadcastBuffer(16)
+	for i := 0; i < 6; i++ {
+		buf := []byte("data")
+		if i%2 == 0 {
+			buf = []byte("OggS")
+		}
+		b.Publish(&chunk{buffer: buf, encoderPos: int64(i * 1000)})
+	}
+	sub := b.SubscribeWithBacklog(3500, isOggPageStart)
+	c, _, err := sub.Next()
+	if err != nil || c == nil {
+		t.Fatalf("sub.Next() = %v, %v", c, err)
+	}
+	if c.encoderPos != 2000 {
+		t.Errorf("backlog starts at %d, want 2000", c.encoderPos)
+	}
+	sub = b.SubscribeWithBacklog(0, isOggPageStart)
+	if c, _, _ := sub.Next(); c != nil {
+		t.Errorf("subscriber without an aligned backlog should start at the next chunk, got %d", c.chunkID)
+	}
+}
+
+func TestBroadcastBufferResyncReset(t *testing.T) {
+	b := newBroadcastBuffer(8)
+	sub := b.Subscribe()
+	for i := 0; i < 3*broadcastMaxResyncs; i++ {
+		for j := 0; j < 20; j++ {
+			b.Publish(&chunk{})
+		}
+		if _, _, err := sub.Next(); err != nil {
+			t.Fatalf("resync %d: %v", i, err)
+		}
+		//catch up with a whole buffer of chunks read in order
+		for j := 0; j < 8; j++ {
+			b.Publish(&chunk{})
+			if _, _, err := sub.Next(); err != nil {
+				t.Fatalf("resync %d: %v", i, err)
+			}
+		}
+	}
+}
+
+const benchmarkListeners = 1000
+
+func BenchmarkBroadcastBuffer(b *testing.B) {
+	buffer := newBroadcastBuffer(broadcastBufferSize)
+	last := int64(b.N)
+	var wg sync.WaitGroup
+	for i := 0; i < benchmarkListeners; i++ {
+		sub := buffer.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c, wait, err := sub.Next()
+				if err != nil {
+					return
+				}
+				if c == nil {
+					<-wait
+					continue
+				}
+				if c.chunkID == last {
+					return
+				}
+			}
+		}()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.Publish(&chunk{buffer: make([]byte, 1024)})
+	}
+	wg.Wait()
+}
+
+//BenchmarkChannelPingPong measures the previous distributor, where the encoder
+//hands every chunk to each subscriber and waits for them to re-register
+func BenchmarkChannelPingPong(b *testing.B) {
+	channels := []chan chan *chunk{
+		make(chan chan *chunk, benchmarkListeners),
+		make(chan chan *chunk, benchmarkListeners),
+	}
+	last := int64(b.N)
+	var wg sync.WaitGroup
+	for i := 0; i < benchmarkListeners; i++ {
+		channel := make(chan *chunk, 1)
+		channels[0] <- channel
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range channel {
+				if c.chunkID == last {
+					return
+				}
+				channels[c.chunkID%2] <- channel
+			}
+		}()
+	}
+	b.ResetTimer()
+	for i := int64(1); i <= last; i++ {
+		c := &chunk{buffer: make([]byte, 1024), chunkID: i}
+		current := channels[(i-1)%2]
+		for sent := 0; sent < benchmarkListeners; sent++ {
+			(<-current) <- c
+		}
+	}
+	wg.Wait()
+}
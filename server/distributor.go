@@ -27,9 +27,20 @@ import (
 	"time"
 
 	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/opusencoder"
 	"github.com/gorilla/websocket"
 )
 
+//isOggPageStart reports whether buf starts with an Ogg page
+func isOggPageStart(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("OggS"))
+}
+
+//isMP3FrameStart reports whether buf starts with an MPEG audio frame header
+func isMP3FrameStart(buf []byte) bool {
+	return len(buf) >= 2 && buf[0] == 0xFF && buf[1]&0xE0 == 0xE0
+}
+
 func (s *Server) pushPCMAudio(pcm []byte) {
 	s.bufferingChannel <- &chunk{buffer: pcm}
 }
@@ -74,16 +85,8 @@ func (s *Server) streamVorbis(streamContext context.Context, encodedDuration cha
 			if n > 0 {
 				Chunk := &chunk{}
 				Chunk.buffer = output
-				Chunk.channel = ((s.currentVorbisChannel + 1) % 2)
-				Chunk.chunkID = atomic.AddInt64(s.vorbisChunkID, 1)
 				Chunk.encoderPos = pos
-				sent := int64(0)
-				for len(s.vorbisChannel[s.currentVorbisChannel]) > 0 || sent < atomic.LoadInt64(s.vorbisSubscribers) {
-					c := <-s.vorbisChannel[s.currentVorbisChannel]
-					c <- Chunk
-					sent++
-				}
-				s.currentVorbisChannel = (s.currentVorbisChannel + 1) % 2
+				s.vorbisBroadcast.Publish(Chunk)
 				bufferedTime = encodedTime
 				time.Sleep(bufferedTime - time.Since(start))
 			}
@@ -133,16 +136,8 @@ func (s *Server) streamMP3(streamContext context.Context, encodedDuration chan t
 			if n > 0 {
 				Chunk := &chunk{}
 				Chunk.buffer = output
-				Chunk.channel = ((s.currentMP3Channel + 1) % 2)
-				Chunk.chunkID = atomic.AddInt64(s.mp3ChunkID, 1)
 				Chunk.encoderPos = pos
-				sent := int64(0)
-				for len(s.mp3Channel[s.currentMP3Channel]) > 0 || sent < atomic.LoadInt64(s.mp3Subscribers) {
-					c := <-s.mp3Channel[s.currentMP3Channel]
-					c <- Chunk
-					sent++
-				}
-				s.currentMP3Channel = (s.currentMP3Channel + 1) % 2
+				s.mp3Broadcast.Publish(Chunk)
 				bufferedTime = encodedTime
 				time.Sleep(bufferedTime - time.Since(start))
 			}
@@ -153,6 +148,50 @@ func (s *Server) streamMP3(streamContext context.Context, encodedDuration chan t
 	}()
 	return source
 }
+func (s *Server) streamOpus(streamContext context.Context, encodedDuration chan time.Duration) chan *chunk {
+	var encodedTime time.Duration
+	var bufferedTime time.Duration
+	source := make(chan *chunk, 5000)
+	go func() {
+		defer func() {
+			encodedDuration <- bufferedTime
+		}()
+		var buffer bytes.Buffer
+		start := time.Now()
+		frame := make([]byte, opusencoder.FrameSize*4)
+		for {
+			var Chunk *chunk
+			select {
+			case <-streamContext.Done():
+				for len(source) > 0 {
+					<-source
+				}
+				return
+			case Chunk = <-source:
+			}
+			buffer.Write(Chunk.buffer)
+			if Chunk.buffer == nil && buffer.Len()%len(frame) != 0 {
+				buffer.Write(make([]byte, len(frame)-buffer.Len()%len(frame)))
+			}
+			for buffer.Len() >= len(frame) {
+				_, _ = buffer.Read(frame)
+				output := make([]byte, 4000)
+				pos := s.opusEncoder.GranulePos()
+				n := s.opusEncoder.Encode(output, frame)
+				encodedTime += (time.Duration)(opusencoder.FrameSize/48) * time.Millisecond
+				if n > 0 {
+					s.opusBroadcast.Publish(&chunk{buffer: output[:n], encoderPos: pos})
+					bufferedTime = encodedTime
+					time.Sleep(bufferedTime - time.Since(start))
+				}
+			}
+			if Chunk.buffer == nil {
+				return
+			}
+		}
+	}()
+	return source
+}
 
 func (s *Server) updateStartPos(push bool) {
 	pos := int64(s.vorbisEncoder.GranulePos())
@@ -171,8 +210,10 @@ func (s *Server) streamToClients(streamContext context.Context) time.Time {
 	interrupted := false
 	timeVorbis := make(chan time.Duration)
 	timeMP3 := make(chan time.Duration)
+	timeOpus := make(chan time.Duration)
 	vorbisStream := s.streamVorbis(streamContext, timeVorbis)
 	mp3Stream := s.streamMP3(streamContext, timeMP3)
+	opusStream := s.streamOpus(streamContext, timeOpus)
 	var vorbisTime, mp3Time time.Duration
 	for {
 		select {
@@ -184,6 +225,7 @@ func (s *Server) streamToClients(streamContext context.Context) time.Time {
 			Chunk := <-s.bufferingChannel
 			vorbisStream <- Chunk
 			mp3Stream <- Chunk
+			opusStream <- Chunk
 			if Chunk.buffer == nil {
 				break
 			}
@@ -203,12 +245,14 @@ func (s *Server) streamToClients(streamContext context.Context) time.Time {
 			case <-streamContext.Done():
 			case vorbisTime = <-timeVorbis:
 				mp3Time = <-timeMP3
+				<-timeOpus
 				interrupted = true
 			}
 		}
 	} else {
 		vorbisTime = <-timeVorbis
 		mp3Time = <-timeMP3
+		<-timeOpus
 	}
 	streamTime := vorbisTime
 	if vorbisTime < mp3Time {
@@ -218,20 +262,33 @@ func (s *Server) streamToClients(streamContext context.Context) time.Time {
 	return start.Add(streamTime)
 }
 
-func (s *Server) setTrack(trackMeta common.TrackMetadata) {
+//setTrack sets the playing track and notifies the clients, each with the lyrics in its language if they are known
+func (s *Server) setTrack(trackMeta common.TrackMetadata, tl *trackLyrics) {
+	s.trackMetaMux.Lock()
 	s.currentTrackMeta.Store(trackMeta)
-	data := Response{
-		Operation: opSetClientsTrack,
-		Success:   true,
-		Data: map[string]interface{}{
-			"track":       trackMeta,
-			"pos":         <-s.deltaChannel,
-			"fallbackpos": <-s.deltaChannel,
-			"listeners":   atomic.LoadInt32(&s.listenersCount),
-		},
-	}
-	s.webSocketNotify(data)
+	s.currentLyrics = tl
+	s.trackMetaMux.Unlock()
+	pos, fallbackPos := <-s.deltaChannel, <-s.deltaChannel
+	s.webSocketNotifyByLanguage(func(language string) (Response, bool) {
+		meta := trackMeta
+		if tl != nil {
+			if lyrics, ok := tl.get(language, s.lyricsLanguage); ok {
+				meta.Lyrics = lyrics
+			}
+		}
+		return Response{
+			Operation: opSetClientsTrack,
+			Success:   true,
+			Data: map[string]interface{}{
+				"track":       meta,
+				"pos":         pos,
+				"fallbackpos": fallbackPos,
+				"listeners":   atomic.LoadInt32(&s.listenersCount),
+			},
+		}, true
+	})
 }
+
 func (s *Server) setListenerCount() {
 	data := Response{
 		Operation: opSetClientsListeners,
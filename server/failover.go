@@ -0,0 +1,339 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	//streamRetries is the number of times a track's stream is reopened before giving up
+	streamRetries = 3
+	//streamRetryDelay is the delay before the first retry, it doubles after each one
+	streamRetryDelay = time.Second
+	//maxFallbackDurationDelta is the largest difference in seconds between a track and its replacement from another source
+	maxFallbackDurationDelta = 15
+)
+
+//Stages and actions of opStreamError notifications
+const (
+	streamStageStart    = "start"
+	streamStagePlayback = "playback"
+
+	streamActionRetry    = "retry"
+	streamActionResume   = "resume"
+	streamActionFallback = "fallback"
+	streamActionFailed   = "failed"
+)
+
+//streamError is the data of opStreamError notifications
+type streamError struct {
+	PlayID string               `json:"playId"`
+	Track  common.TrackMetadata `json:"track"`
+	//Stage is either streamStageStart or streamStagePlayback
+	Stage string `json:"stage"`
+	//Action is what the server does about the error
+	Action  string `json:"action"`
+	Attempt int    `json:"attempt"`
+	Reason  string `json:"reason"`
+	//Offset is the byte offset of the body where playback is resumed
+	Offset int64 `json:"offset,omitempty"`
+	//Fallback is the track from another source which is played instead
+	Fallback *common.TrackMetadata `json:"fallback,omitempty"`
+}
+
+func (s *Server) notifyStreamError(e streamError) {
+	log.Printf("[MusicStream] Stream error: %s: %s - %s: %s (%s, attempt %d)", e.Stage, e.Track.Title, e.Track.Artist, e.Reason, e.Action, e.Attempt)
+	s.webSocketNotify(Response{
+		Operation: opStreamError,
+		Success:   e.Action != streamActionFailed,
+		Reason:    e.Reason,
+		Data: map[string]interface{}{
+			"error": e,
+		},
+	})
+}
+
+//retryDelay returns the delay before the attempt-th retry
+func retryDelay(attempt int) time.Duration {
+	return streamRetryDelay << uint(attempt-1)
+}
+
+//sleepContext waits for d, it returns false if ctx is done before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+//resumableStream replaces the body of a stream with a resumingBody
+type resumableStream struct {
+	common.Stream
+	body io.ReadCloser
+}
+
+func (stream *resumableStream) Body() io.ReadCloser {
+	return stream.body
+}
+
+func (stream *resumableStream) Info() (info common.StreamInfo) {
+	if streamWithInfo, ok := stream.Stream.(common.StreamWithInfo); ok {
+		info = streamWithInfo.Info()
+	}
+	info.Seekable = false
+	return
+}
+
+//resumingBody reads a track's stream body, and reopens it where it stopped if it fails or ends too early.
+//The body is reopened with StreamFrom if the track implements common.TrackWithResumableStream,
+//otherwise the stream is opened again and the bytes which were already read are skipped
+type resumingBody struct {
+	s      *Server
+	ctx    context.Context
+	track  common.Track
+	body   io.ReadCloser
+	offset int64
+	length int64
+	mux    sync.Mutex
+	closed bool
+}
+
+func (body *resumingBody) Read(p []byte) (n int, err error) {
+	for {
+		n, err = body.body.Read(p)
+		body.offset += int64(n)
+		if err == nil || (err == io.EOF && (body.length <= 0 || body.offset >= body.length)) {
+			return
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if resumeErr := body.resume(err); resumeErr != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+//resume reopens the body at the current offset after it failed with cause
+func (body *resumingBody) resume(cause error) error {
+	meta := common.GetMetadata(body.track)
+	for attempt := 1; attempt <= streamRetries; attempt++ {
+		body.s.notifyStreamError(streamError{
+			PlayID:  meta.PlayID,
+			Track:   meta,
+			Stage:   streamStagePlayback,
+			Action:  streamActionResume,
+			Attempt: attempt,
+			Reason:  cause.Error(),
+			Offset:  body.offset,
+		})
+		if !sleepContext(body.ctx, retryDelay(attempt)) {
+			return body.ctx.Err()
+		}
+		var newBody io.ReadCloser
+		if newBody, cause = body.reopen(); cause != nil {
+			continue
+		}
+		body.mux.Lock()
+		if body.closed {
+			body.mux.Unlock()
+			newBody.Close()
+			return errors.New("body is closed")
+		}
+		body.body.Close()
+		body.body = newBody
+		body.mux.Unlock()
+		return nil
+	}
+	body.s.notifyStreamError(streamError{
+		PlayID:  meta.PlayID,
+		Track:   meta,
+		Stage:   streamStagePlayback,
+		Action:  streamActionFailed,
+		Attempt: streamRetries,
+		Reason:  cause.Error(),
+		Offset:  body.offset,
+	})
+	return cause
+}
+
+func (body *resumingBody) reopen() (io.ReadCloser, error) {
+	if track, ok := body.track.(common.TrackWithResumableStream); ok {
+		stream, err := track.StreamFrom(body.offset)
+		if err == nil && stream.Body() != nil {
+			return stream.Body(), nil
+		}
+		log.Printf("[MusicStream] StreamFrom(%d) failed: %+v", body.offset, err)
+	}
+	stream, err := body.track.Stream()
+	if err != nil {
+		return nil, err
+	}
+	newBody := stream.Body()
+	if newBody == nil {
+		return nil, errors.New("Invalid stream")
+	}
+	if _, err = io.CopyN(ioutil.Discard, newBody, body.offset); err != nil {
+		newBody.Close()
+		return nil, errors.Wrap(err, "skipping to offset")
+	}
+	return newBody, nil
+}
+
+func (body *resumingBody) Close() error {
+	body.mux.Lock()
+	defer body.mux.Unlock()
+	body.closed = true
+	return body.body.Close()
+}
+
+//openTrack opens and decodes a track's stream, it's retried with backoff if it fails
+func (s *Server) openTrack(ctx context.Context, track common.Track) (rawStream io.ReadCloser, err error) {
+	meta := common.GetMetadata(track)
+	for attempt := 1; ; attempt++ {
+		if rawStream, err = s.openStream(ctx, track); err == nil {
+			return
+		}
+		log.Printf("[MusicStream] Failed to open %s - %s: %+v", meta.Title, meta.Artist, err)
+		if attempt > streamRetries {
+			return nil, err
+		}
+		s.notifyStreamError(streamError{
+			PlayID:  meta.PlayID,
+			Track:   meta,
+			Stage:   streamStageStart,
+			Action:  streamActionRetry,
+			Attempt: attempt,
+			Reason:  err.Error(),
+		})
+		if !sleepContext(ctx, retryDelay(attempt)) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) openStream(ctx context.Context, track common.Track) (io.ReadCloser, error) {
+	stream, err := track.Stream()
+	if err != nil {
+		return nil, err
+	}
+	body := stream.Body()
+	if body == nil {
+		return nil, errors.New("Invalid stream")
+	}
+	if _, seekable := body.(io.Seeker); !seekable {
+		resuming := &resumingBody{s: s, ctx: ctx, track: track, body: body}
+		if streamWithInfo, ok := stream.(common.StreamWithInfo); ok {
+			resuming.length = streamWithInfo.Info().ContentLength
+		}
+		stream = &resumableStream{Stream: stream, body: resuming}
+	}
+	rawStream, err := GetRawStream(stream, s.preferNativeDecoder)
+	if err != nil {
+		stream.Body().Close()
+		return nil, err
+	}
+	return rawStream, nil
+}
+
+//findAlternative looks up the same song as track on the other healthy sources
+func (s *Server) findAlternative(track common.Track) (common.Track, error) {
+	var source string
+	if strack, ok := track.(common.TrackWithSource); ok {
+		source = strack.Source()
+	}
+	query := track.Title()
+	if len(track.Artist()) > 0 {
+		query = track.Artist() + " " + query
+	}
+	for _, alternative := range s.sources {
+		if alternative.Name() == source {
+			continue
+		}
+		if healthy, _ := s.sourceStatus(alternative); !healthy || isSourceSpecific(alternative, query) {
+			continue
+		}
+		found, err := s.searchTrack(alternative, query)
+		if err != nil {
+			continue
+		}
+		if track.Duration() > 0 && found.Duration() > 0 {
+			if delta := track.Duration() - found.Duration(); delta > maxFallbackDurationDelta || delta < -maxFallbackDurationDelta {
+				log.Printf("[MusicStream] Alternative %s - %s on %s has a different duration", found.Title(), found.Artist(), alternative.Name())
+				continue
+			}
+		}
+		return found, nil
+	}
+	return nil, errors.New("no alternative found")
+}
+
+//openTrackWithFallback opens a track's stream, or the stream of the same song on another source if it fails.
+//It returns the track which is played
+func (s *Server) openTrackWithFallback(ctx context.Context, track common.Track) (common.Track, io.ReadCloser, error) {
+	rawStream, err := s.openTrack(ctx, track)
+	if err == nil || ctx.Err() != nil {
+		return track, rawStream, err
+	}
+	meta := common.GetMetadata(track)
+	alternative, altErr := s.findAlternative(track)
+	if ctx.Err() != nil {
+		return track, nil, ctx.Err()
+	}
+	if altErr == nil {
+		altMeta := common.GetMetadata(alternative)
+		s.notifyStreamError(streamError{
+			PlayID:   meta.PlayID,
+			Track:    meta,
+			Stage:    streamStageStart,
+			Action:   streamActionFallback,
+			Attempt:  streamRetries + 1,
+			Reason:   err.Error(),
+			Fallback: &altMeta,
+		})
+		if rawStream, altErr = s.openTrack(ctx, alternative); altErr == nil {
+			return alternative, rawStream, nil
+		}
+	}
+	s.notifyStreamError(streamError{
+		PlayID:  meta.PlayID,
+		Track:   meta,
+		Stage:   streamStageStart,
+		Action:  streamActionFailed,
+		Attempt: streamRetries + 1,
+		Reason:  err.Error(),
+	})
+	return track, nil, err
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+var failoverTestData = bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+//failingReader returns an error after limit bytes
+type failingReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > r.limit {
+		p = p[:r.limit]
+	}
+	n, err := r.r.Read(p)
+	r.limit -= n
+	return n, err
+}
+
+type failoverTestStream struct {
+	body io.ReadCloser
+}
+
+func (stream failoverTestStream) Format() int         { return common.FFmpegStream }
+func (stream failoverTestStream) Body() io.ReadCloser { return stream.body }
+
+type failoverTestTrack struct {
+	common.DefaultTrack
+	opened  int
+	offsets []int64
+}
+
+//Stream fails in the middle of the body the first time
+func (track *failoverTestTrack) Stream() (common.Stream, error) {
+	track.opened++
+	var r io.Reader = bytes.NewReader(failoverTestData)
+	if track.opened == 1 {
+		r = &failingReader{r: r, limit: len(failoverTestData) / 3}
+	}
+	return failoverTestStream{ioutil.NopCloser(r)}, nil
+}
+
+type resumableTestTrack struct {
+	failoverTestTrack
+}
+
+func (track *resumableTestTrack) StreamFrom(offset int64) (common.Stream, error) {
+	track.offsets = append(track.offsets, offset)
+	return failoverTestStream{ioutil.NopCloser(bytes.NewReader(failoverTestData[offset:]))}, nil
+}
+
+func readResumingBody(t *testing.T, track common.Track, length int64) {
+	s := &Server{}
+	stream, err := track.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &resumingBody{s: s, ctx: context.Background(), track: track, body: stream.Body(), length: length}
+	data, err := ioutil.ReadAll(body)
+	if err != nil || !bytes.Equal(data, failoverTestData) {
+		t.Errorf("read %d bytes, %v, want %d bytes", len(data), err, len(failoverTestData))
+	}
+}
+
+func TestResumingBodyReopen(t *testing.T) {
+	track := &failoverTestTrack{}
+	readResumingBody(t, track, 0)
+	if track.opened != 2 {
+		t.Errorf("the stream was opened %d times, want 2", track.opened)
+	}
+}
+
+func TestResumingBodyStreamFrom(t *testing.T) {
+	track := &resumableTestTrack{}
+	readResumingBody(t, track, int64(len(failoverTestData)))
+	if track.opened != 1 || len(track.offsets) != 1 || track.offsets[0] != int64(len(failoverTestData)/3) {
+		t.Errorf("opened = %d, StreamFrom offsets = %v", track.opened, track.offsets)
+	}
+}
+
+func TestResumingBodyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	track := &failoverTestTrack{}
+	stream, _ := track.Stream()
+	body := &resumingBody{s: &Server{}, ctx: ctx, track: track, body: stream.Body()}
+	if _, err := ioutil.ReadAll(body); err == nil {
+		t.Error("a canceled body should not be resumed")
+	}
+}
+
+//brokenTestTrack can't be opened
+type brokenTestTrack struct {
+	common.DefaultTrack
+}
+
+func (track *brokenTestTrack) Stream() (common.Stream, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestOpenTrackSkipped(t *testing.T) {
+	s := &Server{}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.streamContext, s.skipFunc = ctx, cancel
+	opened := make(chan error, 1)
+	go func() {
+		_, _, err := s.openTrackWithFallback(ctx, &brokenTestTrack{})
+		opened <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if resp := skip(s, wsMessage{Operation: opClientRequestSkip}); !resp.Success {
+		t.Fatalf("skip() while the track is retried = %+v", resp)
+	}
+	select {
+	case err := <-opened:
+		if errors.Cause(err) != context.Canceled {
+			t.Errorf("openTrackWithFallback() = %v, want context.Canceled", err)
+		}
+	case <-time.After(streamRetryDelay / 2):
+		t.Error("the retries were not stopped by skip()")
+	}
+}
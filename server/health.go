@@ -0,0 +1,166 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	healthCheckInterval = 5 * time.Minute
+	healthCheckTimeout  = 30 * time.Second
+)
+
+//sourceHealth is the last known status of a music source
+type sourceHealth struct {
+	mux         sync.Mutex
+	healthy     bool
+	lastError   string
+	lastChecked time.Time
+}
+
+func (s *Server) initSourcesHealth() {
+	s.sourcesHealth = make(map[string]*sourceHealth, len(s.sources))
+	for _, source := range s.sources {
+		s.sourcesHealth[source.Name()] = &sourceHealth{healthy: true}
+	}
+}
+
+//setSourceHealth records the result of a health check or a search on source, err is nil if it succeeded.
+//Errors caused by an invalid query are ignored, as the source is working.
+//Clients are notified with the new sources' list if the status changed
+func (s *Server) setSourceHealth(source common.MusicSource, err error) {
+	health, ok := s.sourcesHealth[source.Name()]
+	if !ok || common.IsInvalidQuery(err) {
+		return
+	}
+	health.mux.Lock()
+	changed := health.healthy != (err == nil)
+	health.healthy = err == nil
+	health.lastChecked = time.Now()
+	health.lastError = ""
+	if err != nil {
+		health.lastError = err.Error()
+	}
+	health.mux.Unlock()
+	if !changed {
+		return
+	}
+	if err != nil {
+		log.Printf("[MusicStream] Source %s is unhealthy: %v", source.Name(), err)
+	} else {
+		log.Printf("[MusicStream] Source %s is healthy", source.Name())
+	}
+	s.webSocketNotify(getSourcesList(s, wsMessage{}))
+}
+
+//sourceStatus returns the status of a source for getSourcesList
+func (s *Server) sourceStatus(source common.MusicSource) (healthy bool, lastError string) {
+	health, ok := s.sourcesHealth[source.Name()]
+	if !ok {
+		return true, ""
+	}
+	health.mux.Lock()
+	defer health.mux.Unlock()
+	return health.healthy, health.lastError
+}
+
+//checkHealth runs source's health check, which fails if it doesn't return in time
+func checkHealth(source common.HealthChecker) error {
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- errors.Errorf("health check panicked: %v", r)
+			}
+		}()
+		result <- source.CheckHealth()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(healthCheckTimeout):
+		return errors.New("health check timed out")
+	}
+}
+
+//healthMonitor periodically probes the sources which implement common.HealthChecker
+func (s *Server) healthMonitor() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, source := range s.sources {
+			checker, ok := source.(common.HealthChecker)
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.setSourceHealth(checker, checkHealth(checker))
+			}()
+		}
+		wg.Wait()
+		<-ticker.C
+	}
+}
+
+//isSourceSpecific reports whether query only makes sense on source, e.g. it's a URL
+func isSourceSpecific(source common.MusicSource, query string) bool {
+	if isHTTPURL(query) {
+		return true
+	}
+	if usource, ok := source.(common.MusicSourceWithURL); ok && usource.MatchURL(query) {
+		return true
+	}
+	return false
+}
+
+//searchWithFallback searches for query on the source at selector.
+//If it fails and search fallback is enabled, the following healthy sources are tried in order,
+//unless the query is specific to the selected source.
+//It returns errNoResult if one of the fallback sources found nothing, errSearchFailed if they all failed
+func (s *Server) searchWithFallback(selector int, query string) (track common.Track, err error) {
+	source := s.sources[selector]
+	track, err = s.searchTrack(source, query)
+	if err != errSearchFailed || !s.searchFallback || isSourceSpecific(source, query) {
+		return
+	}
+	result := errSearchFailed
+	for i := 1; i < len(s.sources); i++ {
+		fallback := s.sources[(selector+i)%len(s.sources)]
+		if healthy, _ := s.sourceStatus(fallback); !healthy {
+			continue
+		}
+		log.Printf("[MusicStream] Search failed on %s, falling back to %s", source.Name(), fallback.Name())
+		if track, err = s.searchTrack(fallback, query); err == nil {
+			return
+		}
+		if err != errSearchFailed {
+			result = errNoResult
+		}
+	}
+	return nil, result
+}
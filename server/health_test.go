@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+type healthTestSource struct {
+	name string
+	err  error
+}
+
+func (source *healthTestSource) Name() string        { return source.name }
+func (source *healthTestSource) DisplayName() string { return source.name }
+func (source *healthTestSource) Search(query string) ([]common.Track, error) {
+	if source.err != nil {
+		return nil, source.err
+	}
+	return []common.Track{&healthTestTrack{title: source.name}}, nil
+}
+func (source *healthTestSource) MatchURL(rawURL string) bool {
+	return rawURL == source.name+":track"
+}
+
+type healthTestTrack struct {
+	common.DefaultTrack
+	title string
+}
+
+func (track *healthTestTrack) Title() string   { return track.title }
+func (track *healthTestTrack) Populate() error { return nil }
+func (track *healthTestTrack) PlayID() string  { return "play-" + track.title }
+
+func TestSearchWithFallback(t *testing.T) {
+	broken := &healthTestSource{name: "broken", err: errors.New("quota exceeded")}
+	down := &healthTestSource{name: "down", err: errors.New("down")}
+	working := &healthTestSource{name: "working"}
+	s := &Server{sources: []common.MusicSource{broken, down, working}}
+	s.initSourcesHealth()
+	if _, err := s.searchWithFallback(0, "song"); err != errSearchFailed {
+		t.Errorf("search without fallback = %v, want errSearchFailed", err)
+	}
+	if healthy, reason := s.sourceStatus(broken); healthy || reason != "quota exceeded" {
+		t.Errorf("failed source's status = %v, %q", healthy, reason)
+	}
+	s.searchFallback = true
+	track, err := s.searchWithFallback(0, "song")
+	if err != nil || track.Title() != "working" {
+		t.Fatalf("searchWithFallback() = %v, %v", track, err)
+	}
+	if healthy, _ := s.sourceStatus(down); healthy {
+		t.Error("the failed fallback source should be unhealthy")
+	}
+	if _, err = s.searchWithFallback(0, "broken:track"); err != errSearchFailed {
+		t.Error("source-specific queries should not fall back")
+	}
+	if _, err = s.searchWithFallback(0, "https://example.com/song.mp3"); err != errSearchFailed {
+		t.Error("URLs should not fall back")
+	}
+	broken.err = nil
+	if track, err = s.searchWithFallback(0, "song"); err != nil || track.Title() != "broken" {
+		t.Errorf("searchWithFallback() = %v, %v", track, err)
+	}
+	if healthy, reason := s.sourceStatus(broken); !healthy || len(reason) > 0 {
+		t.Errorf("recovered source's status = %v, %q", healthy, reason)
+	}
+}
+
+func TestInvalidQueryKeepsHealth(t *testing.T) {
+	picky := &healthTestSource{name: "picky", err: common.NewInvalidQueryError("not an audio file")}
+	empty := &playlistTestSource{name: "empty"}
+	s := &Server{sources: []common.MusicSource{&healthTestSource{name: "broken", err: errors.New("down")}, picky, empty}, searchFallback: true}
+	s.initSourcesHealth()
+	if _, err := s.searchTrack(picky, "song"); err == nil || err.Error() != "not an audio file" {
+		t.Errorf("searchTrack() error = %v, want the query's error", err)
+	}
+	if healthy, _ := s.sourceStatus(picky); !healthy {
+		t.Error("an invalid query should not mark the source unhealthy")
+	}
+	if results, failed := s.federatedSearch("song"); len(results) != 1 || len(failed) != 1 || failed[0] != 0 {
+		t.Errorf("federatedSearch() = %v, failed %v", results, failed)
+	}
+	if _, err := s.searchWithFallback(0, "missing song"); err != errNoResult {
+		t.Errorf("searchWithFallback() error = %v, want errNoResult when a fallback found nothing", err)
+	}
+}
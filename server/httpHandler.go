@@ -21,21 +21,30 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/playlist"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 )
 
 func (s *Server) audioHandler(c echo.Context) (err error) {
+	return s.serveAudio(c, c.Request().URL.Path == "/fallback")
+}
+
+//serveAudio streams the room to the client, as MP3 if fallback is set, otherwise as Ogg/Vorbis
+func (s *Server) serveAudio(c echo.Context, fallback bool) (err error) {
 	r := c.Request()
 	w := c.Response()
 	notify := r.Context().Done()
@@ -46,13 +55,12 @@ func (s *Server) audioHandler(c echo.Context) (err error) {
 	w.Header().Set("pragma", "no-cache")
 	w.Header().Set("status", "200")
 	w.Header().Set("Accept-Ranges", "none")
-	channel := make(chan *chunk, 500)
-	var bufferChannel []chan chan *chunk
-	var chanidx int
+	var broadcast *broadcastBuffer
+	var aligned func([]byte) bool
 	startPos := int64(defaultStartPos)
-	isMP3Stream := false
 	chunkID := int64(-1)
-	if r.URL.Path == "/fallback" {
+	var out io.Writer = w
+	if fallback {
 		w.Header().Set("Content-Type", "audio/mpeg")
 		isRanged := len(r.Header.Get("Range")) > 0
 		if isRanged {
@@ -60,9 +68,16 @@ func (s *Server) audioHandler(c echo.Context) (err error) {
 			_, _ = w.Write(s.mp3Header)
 			return
 		}
-		_, _ = w.Write(s.mp3Header)
-		isMP3Stream = true
-		bufferChannel = s.mp3Channel
+		if r.Header.Get("Icy-MetaData") == "1" {
+			w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+			w.Header().Set("icy-name", "MusicStream")
+			out = newICYWriter(w, func() string {
+				return icyStreamTitle(s.currentTrackMeta.Load().(common.TrackMetadata))
+			})
+		}
+		_, _ = out.Write(s.mp3Header)
+		broadcast = s.mp3Broadcast
+		aligned = isMP3FrameStart
 	} else {
 		w.Header().Set("Content-Type", "audio/ogg")
 		isRanged := len(r.Header.Get("Range")) > 0
@@ -77,24 +92,16 @@ func (s *Server) audioHandler(c echo.Context) (err error) {
 			}
 		}
 		_, _ = w.Write(s.oggHeader)
-		bufferChannel = s.vorbisChannel
-	}
-	if isMP3Stream {
-		atomic.AddInt64(s.mp3Subscribers, 1)
-		defer atomic.AddInt64(s.mp3Subscribers, -1)
-	} else {
-		atomic.AddInt64(s.vorbisSubscribers, 1)
-		defer atomic.AddInt64(s.vorbisSubscribers, -1)
+		broadcast = s.vorbisBroadcast
+		aligned = isOggPageStart
 	}
 	firstChunk := true
-	defer func() { <-channel }()
 	atomic.AddInt32(&s.listenersCount, 1)
 	s.newListenerC <- 1
 	go s.setListenerCount()
 	defer s.setListenerCount()
 	defer atomic.AddInt32(&s.listenersCount, -1)
-	bufferChannel[0] <- channel
-	bufferChannel[1] <- channel
+	subscriber := broadcast.SubscribeWithBacklog(burstBacklogSamples, aligned)
 	w.Flush()
 	audioDisconnect := make(chan int, 1)
 	if cookie, err := c.Cookie(cookieSessionID); err == nil && len(cookie.Value) > 0 {
@@ -117,53 +124,58 @@ func (s *Server) audioHandler(c echo.Context) (err error) {
 		}()
 	}
 	for err == nil {
-		select {
-		case <-notify:
-			return
-		case <-audioDisconnect:
+		Chunk, wait, serr := subscriber.Next()
+		if serr != nil {
+			log.Println("[", r.URL.Path, "]", "[WARN] disconnecting listener: ", serr)
 			return
-		case Chunk := <-channel:
-			chanidx = Chunk.channel
-			if !firstChunk {
-				bufferChannel[chanidx] <- channel
-			} else {
-				firstChunk = false
-				if cookie, err := c.Cookie(cookieSessionID); err == nil && len(cookie.Value) > 0 {
-					var ctx *authenticatedContext
-					ctx_, _ := s.authCtxs.LoadOrStore(cookie.Value, newAuthenticatedContext(cookie.Value))
-					ctx = ctx_.(*authenticatedContext)
+		}
+		if Chunk == nil {
+			select {
+			case <-notify:
+				return
+			case <-audioDisconnect:
+				return
+			case <-wait:
+			}
+			continue
+		}
+		if firstChunk {
+			firstChunk = false
+			if cookie, err := c.Cookie(cookieSessionID); err == nil && len(cookie.Value) > 0 {
+				var ctx *authenticatedContext
+				ctx_, _ := s.authCtxs.LoadOrStore(cookie.Value, newAuthenticatedContext(cookie.Value))
+				ctx = ctx_.(*authenticatedContext)
+				ctx.L.Lock()
+				if ctx.WS != nil {
+					ctx.WS.WriteMessage(websocket.TextMessage, Response{
+						Operation: opClientAudioStartPos,
+						Success:   true,
+						Data: map[string]interface{}{
+							"startPos": Chunk.encoderPos,
+						},
+					}.EncodeJSON())
+				}
+				ctx.StartPos = Chunk.encoderPos
+				startPos = Chunk.encoderPos
+				s.authCtxs.Store(cookie.Value, ctx)
+				ctx.L.Unlock()
+				defer func() {
 					ctx.L.Lock()
-					if ctx.WS != nil {
-						ctx.WS.WriteMessage(websocket.TextMessage, Response{
-							Operation: opClientAudioStartPos,
-							Success:   true,
-							Data: map[string]interface{}{
-								"startPos": Chunk.encoderPos,
-							},
-						}.EncodeJSON())
+					if ctx.StartPos == startPos {
+						ctx.StartPos = defaultStartPos
+						if ctx.WS == nil {
+							s.authCtxs.Delete(ctx.ContextID)
+						}
 					}
-					ctx.StartPos = Chunk.encoderPos
-					startPos = Chunk.encoderPos
-					s.authCtxs.Store(cookie.Value, ctx)
 					ctx.L.Unlock()
-					defer func() {
-						ctx.L.Lock()
-						if ctx.StartPos == startPos {
-							ctx.StartPos = defaultStartPos
-							if ctx.WS == nil {
-								s.authCtxs.Delete(ctx.ContextID)
-							}
-						}
-						ctx.L.Unlock()
-					}()
-				}
-			}
-			if chunkID != -1 && chunkID+1 != Chunk.chunkID {
-				log.Println("[", r.URL.Path, "]", "[WARN] chunks from ", chunkID+1, " to ", Chunk.chunkID-1, " were unexpectedly dropped")
+				}()
 			}
-			chunkID = Chunk.chunkID
-			_, err = w.Write(Chunk.buffer)
 		}
+		if chunkID != -1 && chunkID+1 != Chunk.chunkID {
+			log.Println("[", r.URL.Path, "]", "[WARN] listener fell behind, chunks from ", chunkID+1, " to ", Chunk.chunkID-1, " were skipped")
+		}
+		chunkID = Chunk.chunkID
+		_, err = out.Write(Chunk.buffer)
 	}
 	return
 }
@@ -200,16 +212,30 @@ func (s *Server) wsHandler(c echo.Context) (err error) {
 		return
 	}
 	ws := &webSocket{conn: _c, mux: &sync.Mutex{}}
+	cookie, err := c.Cookie(cookieSessionID)
+	if err == nil && len(cookie.Value) > 0 {
+		ctx_, _ := s.authCtxs.LoadOrStore(cookie.Value, newAuthenticatedContext(cookie.Value))
+		ws.ctx = ctx_.(*authenticatedContext)
+	} else {
+		ws.ctx = newAuthenticatedContext("")
+	}
+	if cookie, err := c.Cookie(cookieLyricsLanguage); err == nil {
+		ws.ctx.L.Lock()
+		if len(ws.ctx.Language) == 0 {
+			ws.ctx.Language = normalizeLanguage(cookie.Value)
+		}
+		ws.ctx.L.Unlock()
+	}
+	err = nil
 	s.connections.Store(ws, ws)
 	defer ws.Close()
 	defer s.connections.Delete(ws)
+	defer s.closeRTCSession(ws)
 	s.newListenerC <- 1
 	_ = ws.WriteMessage(websocket.TextMessage, getSourcesList(s, wsMessage{}).EncodeJSON())
-	_ = ws.WriteMessage(websocket.TextMessage, getPlaying(s, wsMessage{}).EncodeJSON())
+	_ = ws.WriteMessage(websocket.TextMessage, getPlaying(s, wsMessage{socket: ws}).EncodeJSON())
 	_ = ws.WriteMessage(websocket.TextMessage, getQueue(s, wsMessage{}).EncodeJSON())
-	if cookie, err := c.Cookie(cookieSessionID); err == nil && len(cookie.Value) > 0 {
-		ctx_, _ := s.authCtxs.LoadOrStore(cookie.Value, newAuthenticatedContext(cookie.Value))
-		ctx := ctx_.(*authenticatedContext)
+	if ctx := ws.ctx; len(ctx.ContextID) > 0 {
 		ctx.L.Lock()
 		ws.WriteMessage(websocket.TextMessage, Response{
 			Operation: opClientAudioStartPos,
@@ -242,6 +268,7 @@ func (s *Server) wsHandler(c echo.Context) (err error) {
 		if err != nil {
 			break
 		}
+		msg.socket = ws
 		err = ws.WriteMessage(websocket.TextMessage, s.handleMessage(&msg))
 	}
 	if !websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
@@ -304,6 +331,23 @@ func (s *Server) skipHandler(c echo.Context) (err error) {
 	_, _ = w.Write(s.handleMessage(&wsMessage{Operation: opClientRequestSkip}))
 	return
 }
+
+//coverHandler serves the cover art of the sources which keep its URLs on the server
+func (s *Server) coverHandler(c echo.Context) error {
+	source, ok := s.findSource(c.Param("source")).(common.MusicSourceWithCoverArt)
+	id := c.QueryParam("id")
+	if !ok || len(id) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	body, contentType, err := source.GetCoverArt(id)
+	if err != nil {
+		log.Printf("[MusicStream] %s: GetCoverArt: %v", source.Name(), err)
+		return echo.NewHTTPError(http.StatusBadGateway)
+	}
+	defer body.Close()
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	return c.Stream(http.StatusOK, contentType, body)
+}
 func (s *Server) queueHandler(c echo.Context) (err error) {
 	w := c.Response()
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
@@ -330,6 +374,39 @@ func (s *Server) removeTrackHandler(c echo.Context) (err error) {
 	_, _ = w.Write(s.handleMessage(&msg))
 	return
 }
+func (s *Server) importPlaylistHandler(c echo.Context) (err error) {
+	r := c.Request()
+	w := c.Response()
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	source := s.sources[0]
+	if selector := c.QueryParam("source"); len(selector) > 0 {
+		if id, err := strconv.Atoi(selector); err == nil && id >= 0 && id < len(s.sources) {
+			source = s.sources[id]
+		} else if source = s.findSource(selector); source == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, Response{
+				Operation: opClientImportPlaylist,
+				Success:   false,
+				Reason:    "Invalid source!",
+			})
+		}
+	}
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = playlist.FormatFromName(r.Header.Get("Content-Type"))
+	}
+	entries, err := readPlaylist(r.Body, format)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, Response{
+			Operation: opClientImportPlaylist,
+			Success:   false,
+			Reason:    err.Error(),
+		})
+	}
+	_, _ = w.Write(s.importPlaylist(entries, source, nil).EncodeJSON())
+	return nil
+}
 
 // HandleError defines an error handler that complies with echo's standards.
 func (s *Server) HandleError(err error, c echo.Context) {
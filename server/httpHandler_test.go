@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+//coverTestSource serves the cover art named "cover"
+type coverTestSource struct {
+	*playlistTestSource
+}
+
+func (coverTestSource) GetCoverArt(id string) (io.ReadCloser, string, error) {
+	if id != "cover" {
+		return nil, "", errors.New("not found")
+	}
+	return ioutil.NopCloser(strings.NewReader("image")), "image/png", nil
+}
+
+func TestCoverHandler(t *testing.T) {
+	s := &Server{sources: []common.MusicSource{
+		&playlistTestSource{name: "Plain"},
+		coverTestSource{&playlistTestSource{name: "Covers"}},
+	}}
+	e := echo.New()
+	e.GET("/cover/:source", s.coverHandler)
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{common.CoverArtURL("Covers", "cover"), http.StatusOK},
+		{common.CoverArtURL("Covers", "other"), http.StatusBadGateway},
+		{common.CoverArtURL("Plain", "cover"), http.StatusNotFound},
+		{common.CoverArtURL("Unknown", "cover"), http.StatusNotFound},
+		{"/cover/Covers", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != c.status {
+			t.Errorf("GET %s: status %d, want %d", c.path, rec.Code, c.status)
+		}
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", common.CoverArtURL("Covers", "cover"), nil))
+	if rec.Body.String() != "image" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("GET cover = %q (%s)", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+const (
+	icyMetaInt       = 16000
+	icyMaxMetaLength = 255 * 16
+)
+
+//icyWriter interleaves SHOUTcast/Icecast in-band metadata blocks with the audio data
+type icyWriter struct {
+	w         io.Writer
+	remaining int
+	lastTitle string
+	title     func() string
+}
+
+func newICYWriter(w io.Writer, title func() string) *icyWriter {
+	return &icyWriter{w: w, remaining: icyMetaInt, title: title}
+}
+
+func icyStreamTitle(meta common.TrackMetadata) string {
+	title := meta.Title
+	if len(meta.Artist) > 0 {
+		title = meta.Artist + " - " + title
+	}
+	return title
+}
+
+//metadataBlock returns the next metadata block, which is a single zero byte if the title has not changed
+func (icy *icyWriter) metadataBlock() []byte {
+	title := icy.title()
+	if title == icy.lastTitle {
+		return []byte{0}
+	}
+	icy.lastTitle = title
+	meta := fmt.Sprintf("StreamTitle='%s';", strings.ReplaceAll(title, "'", "’"))
+	if len(meta) > icyMaxMetaLength {
+		end := icyMaxMetaLength - 2
+		for end > 0 && !utf8.RuneStart(meta[end]) {
+			end--
+		}
+		meta = meta[:end] + "';"
+	}
+	blocks := (len(meta) + 15) / 16
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], meta)
+	return block
+}
+
+func (icy *icyWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if icy.remaining == 0 {
+			if _, err = icy.w.Write(icy.metadataBlock()); err != nil {
+				return
+			}
+			icy.remaining = icyMetaInt
+		}
+		sz := len(p)
+		if sz > icy.remaining {
+			sz = icy.remaining
+		}
+		var written int
+		written, err = icy.w.Write(p[:sz])
+		n += written
+		icy.remaining -= written
+		if err != nil {
+			return
+		}
+		p = p[sz:]
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICYWriterFraming(t *testing.T) {
+	var out bytes.Buffer
+	title := "Artist - Title"
+	icy := newICYWriter(&out, func() string { return title })
+	audio := bytes.Repeat([]byte{0xAA}, icyMetaInt*2+100)
+	//written in uneven pieces to cross the metadata intervals
+	for p := audio; len(p) > 0; {
+		n := 7000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := icy.Write(p[:n]); err != nil {
+			t.Fatal("Write: ", err)
+		}
+		p = p[n:]
+	}
+	data := out.Bytes()
+	first := data[icyMetaInt:]
+	meta := "StreamTitle='Artist - Title';"
+	blocks := int(first[0])
+	if blocks != (len(meta)+15)/16 || string(bytes.TrimRight(first[1:1+blocks*16], "\x00")) != meta {
+		t.Fatalf("first metadata block = %q", first[:1+blocks*16])
+	}
+	second := first[1+blocks*16+icyMetaInt:]
+	if second[0] != 0 {
+		t.Errorf("unchanged title should be sent as an empty block, got length %d", second[0])
+	}
+	if audioLen := len(data) - (1 + blocks*16) - 1; audioLen != len(audio) {
+		t.Errorf("got %d bytes of audio, want %d", audioLen, len(audio))
+	}
+}
+
+func TestICYMetadataTruncation(t *testing.T) {
+	title := strings.Repeat("é", icyMaxMetaLength)
+	icy := newICYWriter(nil, func() string { return title })
+	block := icy.metadataBlock()
+	meta := string(bytes.TrimRight(block[1:], "\x00"))
+	if len(block)-1 > icyMaxMetaLength {
+		t.Errorf("metadata is %d bytes long", len(block)-1)
+	}
+	if !utf8.ValidString(meta) || !strings.HasSuffix(meta, "';") {
+		t.Errorf("metadata was not truncated on a rune boundary: %q", meta[len(meta)-8:])
+	}
+}
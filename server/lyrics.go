@@ -0,0 +1,208 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultLyricsLanguage = "en"
+	maxLanguageLength     = 16
+)
+
+//trackLyrics holds the lyrics of a track translated into each of the languages requested by the clients
+type trackLyrics struct {
+	track   common.Track
+	playID  string
+	mux     sync.Mutex
+	results map[string]common.LyricsResult
+	pending map[string]bool
+}
+
+func newTrackLyrics(track common.Track) *trackLyrics {
+	return &trackLyrics{
+		track:   track,
+		playID:  track.PlayID(),
+		results: make(map[string]common.LyricsResult),
+		pending: make(map[string]bool),
+	}
+}
+
+func hasLyrics(lyrics common.LyricsResult) bool {
+	return len(lyrics.RawLyrics) > 0 || len(lyrics.SyncedLyrics) > 0
+}
+
+//lookup returns the lyrics in language, ok is false if they haven't been fetched
+func (tl *trackLyrics) lookup(language string) (lyrics common.LyricsResult, ok bool) {
+	tl.mux.Lock()
+	defer tl.mux.Unlock()
+	lyrics, ok = tl.results[language]
+	return
+}
+
+//get returns the lyrics in language, or in fallback if there's none
+func (tl *trackLyrics) get(language, fallback string) (lyrics common.LyricsResult, ok bool) {
+	if lyrics, ok = tl.lookup(language); ok && hasLyrics(lyrics) {
+		return
+	}
+	return tl.lookup(fallback)
+}
+
+//normalizeLanguage returns the lowercased language code, or "" if it's invalid
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) > maxLanguageLength {
+		return ""
+	}
+	for _, c := range language {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return ""
+		}
+	}
+	return language
+}
+
+//socketLanguage returns the lyrics language chosen by the client of socket, or the server's default one
+func (s *Server) socketLanguage(socket *webSocket) string {
+	if socket == nil || socket.ctx == nil {
+		return s.lyricsLanguage
+	}
+	socket.ctx.L.Lock()
+	language := socket.ctx.Language
+	socket.ctx.L.Unlock()
+	if len(language) == 0 {
+		return s.lyricsLanguage
+	}
+	return language
+}
+
+//requestedLanguages returns the server's default lyrics language and the ones chosen by the connected clients
+func (s *Server) requestedLanguages() (languages []string) {
+	seen := map[string]bool{s.lyricsLanguage: true}
+	languages = append(languages, s.lyricsLanguage)
+	s.connections.Range(func(key, value interface{}) bool {
+		if language := s.socketLanguage(value.(*webSocket)); !seen[language] {
+			seen[language] = true
+			languages = append(languages, language)
+		}
+		return true
+	})
+	return
+}
+
+//fetchLyrics fetches the lyrics of tl's track in language in the background, unless they're known or being fetched
+func (s *Server) fetchLyrics(tl *trackLyrics, language string) {
+	tl.mux.Lock()
+	if _, ok := tl.results[language]; ok || tl.pending[language] {
+		tl.mux.Unlock()
+		return
+	}
+	tl.pending[language] = true
+	tl.mux.Unlock()
+	go func() {
+		lyrics, err := s.lyricsCache.GetTranslatedLyrics(tl.track, language)
+		if err != nil {
+			log.Println("[MusicStream] GetLyrics: ERROR: ", err)
+		}
+		tl.mux.Lock()
+		tl.results[language] = lyrics
+		delete(tl.pending, language)
+		tl.mux.Unlock()
+		if hasLyrics(lyrics) {
+			s.notifyLyrics(tl, language)
+		}
+	}()
+}
+
+//currentTrackMetadata returns the playing track's metadata with its lyrics in language
+func (s *Server) currentTrackMetadata(language string) common.TrackMetadata {
+	s.trackMetaMux.Lock()
+	trackMeta := s.currentTrackMeta.Load().(common.TrackMetadata)
+	tl := s.currentLyrics
+	s.trackMetaMux.Unlock()
+	if tl != nil {
+		if lyrics, ok := tl.get(language, s.lyricsLanguage); ok {
+			trackMeta.Lyrics = lyrics
+		}
+	}
+	return trackMeta
+}
+
+//notifyLyrics sends the lyrics in language to the clients which chose it, if tl is still the playing track's.
+//The lyrics in the default language are also sent to clients whose language has no lyrics
+func (s *Server) notifyLyrics(tl *trackLyrics, language string) {
+	s.trackMetaMux.Lock()
+	current := s.currentLyrics == tl
+	s.trackMetaMux.Unlock()
+	if !current {
+		return
+	}
+	s.webSocketNotifyByLanguage(func(socketLanguage string) (response Response, ok bool) {
+		if socketLanguage != language {
+			if language != s.lyricsLanguage {
+				return
+			}
+			if own, known := tl.lookup(socketLanguage); known && hasLyrics(own) {
+				return
+			}
+		}
+		lyrics, ok := tl.get(socketLanguage, s.lyricsLanguage)
+		if !ok {
+			return
+		}
+		return Response{
+			Operation: opLyricsUpdated,
+			Success:   true,
+			Data: map[string]interface{}{
+				"playId":   tl.playID,
+				"language": socketLanguage,
+				"lyrics":   lyrics,
+			},
+		}, true
+	})
+}
+
+//webSocketNotifyByLanguage sends each client the response built for its lyrics language, if any
+func (s *Server) webSocketNotifyByLanguage(build func(language string) (Response, bool)) {
+	nonce := int(rand.Int31())
+	encoded := make(map[string][]byte)
+	s.connections.Range(func(key, value interface{}) bool {
+		ws := value.(*webSocket)
+		language := s.socketLanguage(ws)
+		data, ok := encoded[language]
+		if !ok {
+			if response, ok := build(language); ok {
+				response.Nonce = nonce
+				data = response.EncodeJSON()
+			}
+			encoded[language] = data
+		}
+		if data != nil {
+			_ = ws.WriteMessage(websocket.TextMessage, data)
+		}
+		return true
+	})
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := map[string]string{
+		"vi":      "vi",
+		" EN-us":  "en-us",
+		"zh_Hant": "zh_hant",
+		"en;rm":   "",
+		"":        "",
+	}
+	for language, want := range tests {
+		if got := normalizeLanguage(language); got != want {
+			t.Errorf("normalizeLanguage(%q) = %q, want %q", language, got, want)
+		}
+	}
+}
+
+func TestTrackLyricsFallback(t *testing.T) {
+	tl := newTrackLyrics(&common.DefaultTrack{})
+	if _, ok := tl.get("vi", "en"); ok {
+		t.Error("get should fail before any lyrics are fetched")
+	}
+	tl.results["en"] = common.LyricsResult{RawLyrics: "english"}
+	tl.results["vi"] = common.LyricsResult{}
+	if lyrics, ok := tl.get("vi", "en"); !ok || lyrics.RawLyrics != "english" {
+		t.Errorf("get(vi) = %+v, %v, want the fallback lyrics", lyrics, ok)
+	}
+	tl.results["vi"] = common.LyricsResult{RawLyrics: "vietnamese"}
+	if lyrics, _ := tl.get("vi", "en"); lyrics.RawLyrics != "vietnamese" {
+		t.Errorf("get(vi) = %+v, want the translated lyrics", lyrics)
+	}
+}
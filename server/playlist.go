@@ -0,0 +1,295 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/playlist"
+	"github.com/TrungNguyen1909/MusicStream/queue"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	//playlistImportWorkers is the number of playlist entries which are resolved concurrently
+	playlistImportWorkers = 4
+	maxPlaylistSize       = 1 << 20
+)
+
+//playlistEntryError describes an entry of a playlist which could not be enqueued
+type playlistEntryError struct {
+	Index  int            `json:"index"`
+	Entry  playlist.Entry `json:"entry"`
+	Reason string         `json:"reason"`
+}
+
+//findSource returns the source with the provided name, or nil
+func (s *Server) findSource(name string) common.MusicSource {
+	for _, source := range s.sources {
+		if strings.EqualFold(source.Name(), name) {
+			return source
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+var (
+	errSearchFailed = errors.New("Search Failed!")
+	errNoResult     = errors.New("No Result!")
+)
+
+//searchTrack returns the populated first result of query on source, the source's health is updated with the result.
+//Errors caused by the query are returned as they are, so their reason can be shown
+func (s *Server) searchTrack(source common.MusicSource, query string) (track common.Track, err error) {
+	tracks, err := source.Search(query)
+	if common.IsInvalidQuery(err) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("[MusicStream] SearchTrack: Source: %s: Failed: %v", source.Name(), err)
+		s.setSourceHealth(source, err)
+		return nil, errSearchFailed
+	}
+	if len(tracks) <= 0 {
+		s.setSourceHealth(source, nil)
+		return nil, errNoResult
+	}
+	track = tracks[0]
+	if err = track.Populate(); err != nil {
+		log.Printf("[MusicStream] track.Populate() failed: %+v", err)
+		s.setSourceHealth(source, err)
+		return nil, errSearchFailed
+	}
+	s.setSourceHealth(source, nil)
+	return
+}
+
+//resolveEntry finds the track of a playlist entry, by its source and ID, by its URL through the source which recognizes it,
+//or by searching for its artist and title on the fallback source
+func (s *Server) resolveEntry(entry playlist.Entry, fallback common.MusicSource) (common.Track, error) {
+	if len(entry.Source) > 0 && len(entry.ID) > 0 {
+		if source, ok := s.findSource(entry.Source).(common.MusicSourceWithTrackByID); ok {
+			track, err := source.GetTrack(entry.ID)
+			if err == nil && track != nil {
+				if err = track.Populate(); err == nil {
+					return track, nil
+				}
+			}
+			log.Printf("[MusicStream] GetTrack: Source: %s: %s: Failed: %v", entry.Source, entry.ID, err)
+		}
+	}
+	if isHTTPURL(entry.Location) {
+		for _, source := range s.sources {
+			if matcher, ok := source.(common.MusicSourceWithURL); ok && matcher.MatchURL(entry.Location) {
+				return s.searchTrack(source, entry.Location)
+			}
+		}
+	}
+	query := entry.Query()
+	if len(entry.Title) == 0 && len(entry.Location) > 0 && !isHTTPURL(entry.Location) {
+		//local files are searched by their name
+		query = strings.TrimSuffix(path.Base(strings.ReplaceAll(entry.Location, "\\", "/")), path.Ext(entry.Location))
+	}
+	if len(query) == 0 {
+		return nil, errors.New("Invalid Query!")
+	}
+	return s.searchTrack(fallback, query)
+}
+
+//importPlaylist resolves the entries of a playlist and enqueues the found tracks in order.
+//If socket is not nil, it receives an opPlaylistImportProgress notification after each entry
+func (s *Server) importPlaylist(entries []playlist.Entry, fallback common.MusicSource, socket *webSocket) Response {
+	if len(entries) == 0 {
+		return Response{
+			Operation: opClientImportPlaylist,
+			Success:   false,
+			Reason:    "Empty playlist!",
+		}
+	}
+	tracks := make([]common.Track, len(entries))
+	reasons := make([]string, len(entries))
+	var (
+		wg   sync.WaitGroup
+		done int32
+	)
+	indices := make(chan int)
+	for i := 0; i < playlistImportWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				track, err := s.resolveEntry(entries[index], fallback)
+				if err != nil {
+					reasons[index] = err.Error()
+				} else {
+					tracks[index] = track
+				}
+				if socket != nil {
+					data := map[string]interface{}{
+						"index":   index,
+						"done":    atomic.AddInt32(&done, 1),
+						"total":   len(entries),
+						"success": err == nil,
+					}
+					if err != nil {
+						data["reason"] = err.Error()
+					} else {
+						data["track"] = common.GetMetadata(track)
+					}
+					_ = socket.WriteMessage(websocket.TextMessage, Response{
+						Operation: opPlaylistImportProgress,
+						Success:   true,
+						Data:      data,
+					}.EncodeJSON())
+				}
+			}
+		}()
+	}
+	for i := range entries {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	enqueued := make([]common.TrackMetadata, 0, len(entries))
+	entryErrors := make([]playlistEntryError, 0)
+	for i, track := range tracks {
+		if track == nil {
+			entryErrors = append(entryErrors, playlistEntryError{Index: i, Entry: entries[i], Reason: reasons[i]})
+			continue
+		}
+		s.playQueue.Push(track)
+		enqueued = append(enqueued, common.GetMetadata(track))
+	}
+	log.Printf("[MusicStream] Playlist imported: %d/%d tracks enqueued", len(enqueued), len(entries))
+	response := Response{
+		Operation: opClientImportPlaylist,
+		Success:   len(enqueued) > 0,
+		Data: map[string]interface{}{
+			"total":  len(entries),
+			"tracks": enqueued,
+			"errors": entryErrors,
+		},
+	}
+	if len(enqueued) == 0 {
+		response.Reason = "No track was found!"
+	}
+	return response
+}
+
+//maxHistory is the number of played tracks kept for /history/export
+const maxHistory = 500
+
+func (s *Server) addHistory(trackMeta common.TrackMetadata) {
+	trackMeta.Lyrics = common.LyricsResult{}
+	s.history.Push(trackMeta)
+	for s.history.Size() > maxHistory {
+		s.history.Pop()
+	}
+}
+
+//playlistEntry returns the playlist entry of a track
+func playlistEntry(trackMeta common.TrackMetadata) playlist.Entry {
+	return playlist.Entry{
+		Source:   trackMeta.Source,
+		ID:       trackMeta.ID,
+		Title:    trackMeta.Title,
+		Artist:   trackMeta.Artist,
+		Album:    trackMeta.Album,
+		Cover:    trackMeta.CoverURL,
+		Duration: trackMeta.Duration,
+		Location: trackMeta.Href,
+	}
+}
+
+//exportPlaylist writes the tracks of q as a playlist file in the format requested by the format query parameter
+func exportPlaylist(c echo.Context, q *queue.Queue, name string) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	switch format {
+	case "", "m3u8":
+		format = playlist.FormatM3U
+	case playlist.FormatM3U, playlist.FormatXSPF, playlist.FormatJSON:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported playlist format")
+	}
+	values := q.Values()
+	entries := make([]playlist.Entry, 0, len(values))
+	for _, value := range values {
+		entries = append(entries, playlistEntry(value.(common.TrackMetadata)))
+	}
+	w := c.Response()
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Content-Type", playlist.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, playlist.Extension(format)))
+	w.WriteHeader(http.StatusOK)
+	return playlist.Write(w, entries, format, "MusicStream "+name)
+}
+
+func (s *Server) exportQueueHandler(c echo.Context) error {
+	return exportPlaylist(c, s.cacheQueue, "queue")
+}
+
+func (s *Server) exportHistoryHandler(c echo.Context) error {
+	return exportPlaylist(c, s.history, "history")
+}
+
+//readPlaylist parses a playlist of the provided format, which is detected from its content if it's empty
+func readPlaylist(r io.Reader, format string) ([]playlist.Entry, error) {
+	entries, err := playlist.Parse(io.LimitReader(r, maxPlaylistSize), format)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("Invalid playlist: ", errors.Cause(err)))
+	}
+	return entries, nil
+}
+
+func importPlaylist(s *Server, msg wsMessage) Response {
+	if msg.Selector < 0 || msg.Selector >= len(s.sources) {
+		return Response{
+			Operation: opClientImportPlaylist,
+			Success:   false,
+			Reason:    "Invalid source!",
+		}
+	}
+	entries, err := readPlaylist(strings.NewReader(msg.Query), "")
+	if err != nil {
+		return Response{
+			Operation: opClientImportPlaylist,
+			Success:   false,
+			Reason:    err.Error(),
+		}
+	}
+	return s.importPlaylist(entries, s.sources[msg.Selector], msg.socket)
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/playlist"
+	"github.com/TrungNguyen1909/MusicStream/queue"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+//playlistTestSource finds a track titled after each query, and recognizes its own URLs
+type playlistTestSource struct {
+	name    string
+	mux     sync.Mutex
+	queries []string
+}
+
+func (source *playlistTestSource) Name() string        { return source.name }
+func (source *playlistTestSource) DisplayName() string { return source.name }
+func (source *playlistTestSource) Search(query string) ([]common.Track, error) {
+	source.mux.Lock()
+	source.queries = append(source.queries, query)
+	source.mux.Unlock()
+	if strings.Contains(query, "missing") {
+		return nil, nil
+	}
+	if strings.Contains(query, "broken") {
+		return nil, errors.New("broken")
+	}
+	if strings.Contains(query, "slow") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return []common.Track{&healthTestTrack{title: source.name + ":" + query}}, nil
+}
+func (source *playlistTestSource) GetTrack(id string) (common.Track, error) {
+	return &healthTestTrack{title: source.name + ":id:" + id}, nil
+}
+func (source *playlistTestSource) MatchURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "https://"+source.name+".test/")
+}
+
+//newTestWebSocket returns a server-side webSocket connected to the returned client connection
+func newTestWebSocket(t *testing.T) (*webSocket, *websocket.Conn, func()) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error("Upgrade: ", err)
+			return
+		}
+		conns <- conn
+	}))
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal("Dial: ", err)
+	}
+	socket := &webSocket{conn: <-conns, mux: &sync.Mutex{}}
+	return socket, client, func() {
+		client.Close()
+		socket.Close()
+		server.Close()
+	}
+}
+
+func newPlaylistTestServer() (*Server, *playlistTestSource, *playlistTestSource) {
+	fallback := &playlistTestSource{name: "fallback"}
+	matcher := &playlistTestSource{name: "matcher"}
+	s := &Server{sources: []common.MusicSource{fallback, matcher}, playQueue: queue.New()}
+	s.initSourcesHealth()
+	return s, fallback, matcher
+}
+
+func TestResolveEntry(t *testing.T) {
+	s, fallback, matcher := newPlaylistTestServer()
+	tests := []struct {
+		entry playlist.Entry
+		title string
+	}{
+		{playlist.Entry{Source: "matcher", ID: "42", Title: "Song"}, "matcher:id:42"},
+		{playlist.Entry{Location: "https://matcher.test/song"}, "matcher:https://matcher.test/song"},
+		{playlist.Entry{Location: "https://unknown.test/song", Artist: "Artist", Title: "Song"}, "fallback:Artist - Song"},
+		{playlist.Entry{Title: "Song"}, "fallback:Song"},
+		{playlist.Entry{Location: `C:\Music\Artist - Local Song.mp3`}, "fallback:Artist - Local Song"},
+		{playlist.Entry{Location: "/music/Other Song.flac"}, "fallback:Other Song"},
+	}
+	for _, test := range tests {
+		track, err := s.resolveEntry(test.entry, fallback)
+		if err != nil {
+			t.Errorf("resolveEntry(%+v): %v", test.entry, err)
+			continue
+		}
+		if track.Title() != test.title {
+			t.Errorf("resolveEntry(%+v) = %q, want %q", test.entry, track.Title(), test.title)
+		}
+	}
+	if len(matcher.queries) != 1 {
+		t.Errorf("the matching source was queried %d times, want 1", len(matcher.queries))
+	}
+	if _, err := s.resolveEntry(playlist.Entry{Title: "missing"}, fallback); err != errNoResult {
+		t.Errorf("resolveEntry(missing) = %v, want errNoResult", err)
+	}
+	if _, err := s.resolveEntry(playlist.Entry{}, fallback); err == nil {
+		t.Error("an empty entry should not be resolved")
+	}
+}
+
+func TestImportPlaylist(t *testing.T) {
+	s, fallback, _ := newPlaylistTestServer()
+	socket, client, closeSocket := newTestWebSocket(t)
+	defer closeSocket()
+	entries := []playlist.Entry{
+		{Title: "slow 1"},
+		{Title: "missing"},
+		{Location: "https://matcher.test/2"},
+		{Title: "broken"},
+		{Title: "3"},
+		{Title: "slow 4"},
+	}
+	resp := s.importPlaylist(entries, fallback, socket)
+	if !resp.Success {
+		t.Fatal("importPlaylist: ", resp.Reason)
+	}
+	want := []string{"fallback:slow 1", "matcher:https://matcher.test/2", "fallback:3", "fallback:slow 4"}
+	queued := s.playQueue.Values()
+	if len(queued) != len(want) {
+		t.Fatalf("%d tracks were enqueued, want %d", len(queued), len(want))
+	}
+	for i, value := range queued {
+		if title := value.(common.Track).Title(); title != want[i] {
+			t.Errorf("queue[%d] = %q, want %q", i, title, want[i])
+		}
+	}
+	entryErrors := resp.Data["errors"].([]playlistEntryError)
+	if len(entryErrors) != 2 || entryErrors[0].Index != 1 || entryErrors[1].Index != 3 {
+		t.Errorf("errors = %+v, want entries 1 and 3", entryErrors)
+	}
+	if resp.Data["total"] != len(entries) || len(resp.Data["tracks"].([]common.TrackMetadata)) != len(want) {
+		t.Errorf("data = %+v", resp.Data)
+	}
+
+	seen := make(map[int]bool)
+	for i := 1; i <= len(entries); i++ {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		var notification struct {
+			Operation int `json:"op"`
+			Data      struct {
+				Index   int                  `json:"index"`
+				Done    int                  `json:"done"`
+				Total   int                  `json:"total"`
+				Success bool                 `json:"success"`
+				Track   common.TrackMetadata `json:"track"`
+				Reason  string               `json:"reason"`
+			} `json:"data"`
+		}
+		if err := client.ReadJSON(&notification); err != nil {
+			t.Fatalf("notification %d: %v", i, err)
+		}
+		data := notification.Data
+		if notification.Operation != opPlaylistImportProgress || data.Done != i || data.Total != len(entries) {
+			t.Errorf("notification %d = %+v", i, notification)
+		}
+		if seen[data.Index] {
+			t.Errorf("entry %d was notified twice", data.Index)
+		}
+		seen[data.Index] = true
+		failed := data.Index == 1 || data.Index == 3
+		if data.Success == failed || (failed && len(data.Reason) == 0) || (!failed && len(data.Track.Title) == 0) {
+			t.Errorf("notification of entry %d = %+v", data.Index, data)
+		}
+	}
+}
+
+func TestImportPlaylistNotFound(t *testing.T) {
+	s, fallback, _ := newPlaylistTestServer()
+	if resp := s.importPlaylist(nil, fallback, nil); resp.Success {
+		t.Error("an empty playlist should not be imported")
+	}
+	resp := s.importPlaylist([]playlist.Entry{{Title: "missing"}}, fallback, nil)
+	if resp.Success || s.playQueue.Size() != 0 {
+		t.Errorf("importPlaylist() = %+v, want a failure", resp)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(resp.EncodeJSON(), &data); err != nil {
+		t.Error("the response can't be encoded: ", err)
+	}
+}
+
+func TestEnqueuePlaylistSource(t *testing.T) {
+	s, _, matcher := newPlaylistTestServer()
+	s.playlists = playlist.NewMemoryStore()
+	p, err := s.playlists.Create("test")
+	if err != nil {
+		t.Fatal("Create: ", err)
+	}
+	if _, err = s.playlists.Update(p.ID, func(p *playlist.Playlist) error {
+		p.Tracks = []playlist.Entry{{Title: "Song"}}
+		return nil
+	}); err != nil {
+		t.Fatal("Update: ", err)
+	}
+	for _, selector := range []int{-1, 2} {
+		if resp := enqueuePlaylist(s, wsMessage{Query: p.ID, Selector: selector}); resp.Success || resp.Reason != "Invalid source!" {
+			t.Errorf("selector %d: %+v, want an invalid source", selector, resp)
+		}
+	}
+	s.messageHandlers = map[int]RequestHandler{opClientEnqueuePlaylist: enqueuePlaylist}
+	e := echo.New()
+	e.POST("/playlists/:id/enqueue", s.playlistHandler(opClientEnqueuePlaylist))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("POST", "/playlists/"+p.ID+"/enqueue?source=Matcher", nil))
+	if len(matcher.queries) != 1 || s.playQueue.Size() != 1 {
+		t.Errorf("the source selected by name was not searched: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("POST", "/playlists/"+p.ID+"/enqueue?source=unknown", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown source: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,319 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/playlist"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const maxPlaylistNameLength = 100
+
+var (
+	errPlaylistFull = errors.New("Playlist is full!")
+	errInvalidIndex = errors.New("Invalid index!")
+)
+
+//playlistRequest contains the parameters of saved playlists' requests, sent as JSON in the query of the message
+type playlistRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	//PlayID is the playId of a track in the queue, history or playing, which is added to the playlist
+	PlayID string `json:"playId"`
+	//Query is searched on the message's selected source for the track to add to the playlist
+	Query   string `json:"query"`
+	Index   int    `json:"index"`
+	Shuffle bool   `json:"shuffle"`
+}
+
+func playlistError(op int, reason string) Response {
+	return Response{
+		Operation: op,
+		Success:   false,
+		Reason:    reason,
+	}
+}
+
+func playlistResponse(op int, p playlist.Playlist) Response {
+	return Response{
+		Operation: op,
+		Success:   true,
+		Data: map[string]interface{}{
+			"playlist": p,
+		},
+	}
+}
+
+//parsePlaylistRequest decodes the playlist request of msg, whose query may also be a bare playlist ID
+func parsePlaylistRequest(msg wsMessage) (req playlistRequest, err error) {
+	query := strings.TrimSpace(msg.Query)
+	if strings.HasPrefix(query, "{") {
+		err = json.Unmarshal([]byte(query), &req)
+		return
+	}
+	req.ID = query
+	return
+}
+
+func validPlaylistName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, len(name) > 0 && utf8.RuneCountInString(name) <= maxPlaylistNameLength
+}
+
+//storeError returns the reason of a playlist store's error
+func storeError(err error) string {
+	if errors.Cause(err) == playlist.ErrNotFound {
+		return "Playlist not found!"
+	}
+	return "Failed to save playlist!"
+}
+
+//findTrackMetadata returns the metadata of the track with playID, if it's playing, in the queue or in the history
+func (s *Server) findTrackMetadata(playID string) (trackMeta common.TrackMetadata, ok bool) {
+	if trackMeta = s.currentTrackMeta.Load().(common.TrackMetadata); trackMeta.PlayID == playID {
+		return trackMeta, true
+	}
+	for _, q := range [...]interface{ Values() []interface{} }{s.cacheQueue, s.history} {
+		for _, value := range q.Values() {
+			if trackMeta = value.(common.TrackMetadata); trackMeta.PlayID == playID {
+				return trackMeta, true
+			}
+		}
+	}
+	return common.TrackMetadata{}, false
+}
+
+func listPlaylists(s *Server, msg wsMessage) Response {
+	playlists, err := s.playlists.List()
+	if err != nil {
+		return playlistError(opClientListPlaylists, storeError(err))
+	}
+	return Response{
+		Operation: opClientListPlaylists,
+		Success:   true,
+		Data: map[string]interface{}{
+			"playlists": playlists,
+		},
+	}
+}
+
+func getPlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientGetPlaylist, "Bad Request")
+	}
+	p, err := s.playlists.Get(req.ID)
+	if err != nil {
+		return playlistError(opClientGetPlaylist, storeError(err))
+	}
+	return playlistResponse(opClientGetPlaylist, p)
+}
+
+func createPlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientCreatePlaylist, "Bad Request")
+	}
+	if len(req.Name) == 0 {
+		//the query is the name itself
+		req.Name = req.ID
+	}
+	name, ok := validPlaylistName(req.Name)
+	if !ok {
+		return playlistError(opClientCreatePlaylist, "Invalid playlist name!")
+	}
+	p, err := s.playlists.Create(name)
+	if err != nil {
+		return playlistError(opClientCreatePlaylist, storeError(err))
+	}
+	return playlistResponse(opClientCreatePlaylist, p)
+}
+
+func renamePlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientRenamePlaylist, "Bad Request")
+	}
+	name, ok := validPlaylistName(req.Name)
+	if !ok {
+		return playlistError(opClientRenamePlaylist, "Invalid playlist name!")
+	}
+	p, err := s.playlists.Update(req.ID, func(p *playlist.Playlist) error {
+		p.Name = name
+		return nil
+	})
+	if err != nil {
+		return playlistError(opClientRenamePlaylist, storeError(err))
+	}
+	return playlistResponse(opClientRenamePlaylist, p)
+}
+
+func deletePlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientDeletePlaylist, "Bad Request")
+	}
+	if err = s.playlists.Delete(req.ID); err != nil {
+		return playlistError(opClientDeletePlaylist, storeError(err))
+	}
+	return Response{
+		Operation: opClientDeletePlaylist,
+		Success:   true,
+		Data: map[string]interface{}{
+			"id": req.ID,
+		},
+	}
+}
+
+func addToPlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientAddToPlaylist, "Bad Request")
+	}
+	var trackMeta common.TrackMetadata
+	switch {
+	case len(req.PlayID) > 0:
+		var ok bool
+		if trackMeta, ok = s.findTrackMetadata(req.PlayID); !ok {
+			return playlistError(opClientAddToPlaylist, "Track not found!")
+		}
+	case len(req.Query) > 0:
+		if msg.Selector < 0 || msg.Selector >= len(s.sources) {
+			return playlistError(opClientAddToPlaylist, "Invalid source!")
+		}
+		track, err := s.searchTrack(s.sources[msg.Selector], req.Query)
+		if err != nil {
+			return playlistError(opClientAddToPlaylist, err.Error())
+		}
+		trackMeta = common.GetMetadata(track)
+		if len(trackMeta.Source) == 0 {
+			trackMeta.Source = s.sources[msg.Selector].Name()
+		}
+	default:
+		return playlistError(opClientAddToPlaylist, "Invalid Query!")
+	}
+	p, err := s.playlists.Update(req.ID, func(p *playlist.Playlist) error {
+		if len(p.Tracks) >= playlist.MaxEntries {
+			return errPlaylistFull
+		}
+		p.Tracks = append(p.Tracks, playlistEntry(trackMeta))
+		return nil
+	})
+	if err == errPlaylistFull {
+		return playlistError(opClientAddToPlaylist, err.Error())
+	} else if err != nil {
+		return playlistError(opClientAddToPlaylist, storeError(err))
+	}
+	return playlistResponse(opClientAddToPlaylist, p)
+}
+
+func removeFromPlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientRemoveFromPlaylist, "Bad Request")
+	}
+	p, err := s.playlists.Update(req.ID, func(p *playlist.Playlist) error {
+		if req.Index < 0 || req.Index >= len(p.Tracks) {
+			return errInvalidIndex
+		}
+		p.Tracks = append(p.Tracks[:req.Index], p.Tracks[req.Index+1:]...)
+		return nil
+	})
+	if err == errInvalidIndex {
+		return playlistError(opClientRemoveFromPlaylist, err.Error())
+	} else if err != nil {
+		return playlistError(opClientRemoveFromPlaylist, storeError(err))
+	}
+	return playlistResponse(opClientRemoveFromPlaylist, p)
+}
+
+//enqueuePlaylist re-resolves each track of a saved playlist through its source and enqueues them, optionally shuffled
+func enqueuePlaylist(s *Server, msg wsMessage) Response {
+	req, err := parsePlaylistRequest(msg)
+	if err != nil {
+		return playlistError(opClientEnqueuePlaylist, "Bad Request")
+	}
+	p, err := s.playlists.Get(req.ID)
+	if err != nil {
+		return playlistError(opClientEnqueuePlaylist, storeError(err))
+	}
+	if req.Shuffle {
+		rand.Shuffle(len(p.Tracks), func(i, j int) {
+			p.Tracks[i], p.Tracks[j] = p.Tracks[j], p.Tracks[i]
+		})
+	}
+	if msg.Selector < 0 || msg.Selector >= len(s.sources) {
+		return playlistError(opClientEnqueuePlaylist, "Invalid source!")
+	}
+	response := s.importPlaylist(p.Tracks, s.sources[msg.Selector], msg.socket)
+	response.Operation = opClientEnqueuePlaylist
+	return response
+}
+
+//playlistHandler returns an HTTP handler for a saved playlists' opcode.
+//The request is built from the JSON body, the id and index path parameters, and the shuffle and source query parameters
+func (s *Server) playlistHandler(op int) echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		r := c.Request()
+		w := c.Response()
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
+		w.Header().Set("Pragma", "no-cache")
+		w.Header().Set("Expires", "0")
+		var req playlistRequest
+		if r.ContentLength != 0 && (r.Method == http.MethodPost || r.Method == http.MethodPut) {
+			if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, playlistError(op, "Bad Request"))
+			}
+		}
+		if id := c.Param("id"); len(id) > 0 {
+			req.ID = id
+		}
+		if index := c.Param("index"); len(index) > 0 {
+			if req.Index, err = strconv.Atoi(index); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, playlistError(op, "Invalid index!"))
+			}
+		}
+		if shuffle := c.QueryParam("shuffle"); shuffle == "1" || shuffle == "true" {
+			req.Shuffle = true
+		}
+		msg := wsMessage{Operation: op}
+		if selector := c.QueryParam("source"); len(selector) > 0 {
+			if msg.Selector, err = strconv.Atoi(selector); err != nil {
+				if source := s.findSource(selector); source != nil {
+					msg.Selector = s.sourceIndex(source)
+				} else {
+					return echo.NewHTTPError(http.StatusBadRequest, playlistError(op, "Invalid source!"))
+				}
+			}
+		}
+		query, _ := json.Marshal(req)
+		msg.Query = string(query)
+		_, _ = w.Write(s.handleMessage(&msg))
+		return nil
+	}
+}
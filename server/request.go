@@ -20,6 +20,7 @@ package server
 
 import (
 	"log"
+	"strings"
 	"sync/atomic"
 
 	"github.com/TrungNguyen1909/MusicStream/common"
@@ -30,6 +31,7 @@ func getSourcesList(s *Server, msg wsMessage) Response {
 	for i, v := range s.sources {
 		result[i] = common.GetMusicSourceInfo(v)
 		result[i].ID = i
+		result[i].Healthy, result[i].Error = s.sourceStatus(v)
 	}
 	return Response{
 		Operation: opListSources,
@@ -44,7 +46,7 @@ func getPlaying(s *Server, msg wsMessage) Response {
 		Operation: opSetClientsTrack,
 		Success:   true,
 		Data: map[string]interface{}{
-			"track":       s.currentTrackMeta.Load().(common.TrackMetadata),
+			"track":       s.currentTrackMetadata(s.socketLanguage(msg.socket)),
 			"pos":         atomic.LoadInt64(&s.startPos[0]),
 			"fallbackpos": atomic.LoadInt64(&s.startPos[1]),
 			"listeners":   atomic.LoadInt32(&s.listenersCount),
@@ -63,7 +65,6 @@ func getListenersCount(s *Server, msg wsMessage) Response {
 }
 
 func enqueue(s *Server, msg wsMessage) Response {
-	var err error
 	if len(msg.Query) == 0 {
 		return Response{
 			Operation: opClientRequestTrack,
@@ -71,7 +72,13 @@ func enqueue(s *Server, msg wsMessage) Response {
 			Reason:    "Invalid Query!",
 		}
 	}
-	var tracks []common.Track
+	if result, ok := s.searchResults.Take(msg.Query); ok {
+		return s.enqueueSearchResult(result.source, result.track)
+	}
+	if msg.Selector == allSources {
+		log.Printf("[MusicStream] Client Queried: All sources: %s", msg.Query)
+		return s.enqueueBestResult(msg.Query)
+	}
 	if msg.Selector < 0 || msg.Selector >= len(s.sources) {
 		return Response{
 			Operation: opClientRequestTrack,
@@ -80,42 +87,28 @@ func enqueue(s *Server, msg wsMessage) Response {
 		}
 	}
 	log.Printf("[MusicStream] Client Queried: Source: %s: %s", s.sources[msg.Selector].Name(), msg.Query)
-	tracks, err = s.sources[msg.Selector].Search(msg.Query)
-	switch {
-	case err != nil:
-		log.Printf("[MusicStream] SearchTrack: Source: %s: Failed: %v", s.sources[msg.Selector].Name(), err)
-		return Response{
-			Operation: opClientRequestTrack,
-			Success:   false,
-			Reason:    "Search Failed!",
-		}
-	case len(tracks) <= 0:
+	track, err := s.searchWithFallback(msg.Selector, msg.Query)
+	if err != nil {
 		return Response{
 			Operation: opClientRequestTrack,
 			Success:   false,
-			Reason:    "No Result!",
-		}
-	default:
-		track := tracks[0]
-		err = track.Populate()
-		if err != nil {
-			log.Printf("[MusicStream] track.Populate() failed: %+v", err)
-			return Response{
-				Operation: opClientRequestTrack,
-				Success:   false,
-				Reason:    "Search Failed!",
-			}
-		}
-		s.playQueue.Push(track)
-		log.Printf("[MusicStream] Track enqueued: %v - %v\n", track.Title(), track.Artist())
-		return Response{
-			Operation: opClientRequestTrack,
-			Success:   true,
-			Data: map[string]interface{}{
-				"track": common.GetMetadata(track),
-			},
+			Reason:    err.Error(),
 		}
 	}
+	return s.enqueueTrack(track)
+}
+
+//enqueueTrack pushes a populated track to the play queue
+func (s *Server) enqueueTrack(track common.Track) Response {
+	s.playQueue.Push(track)
+	log.Printf("[MusicStream] Track enqueued: %v - %v\n", track.Title(), track.Artist())
+	return Response{
+		Operation: opClientRequestTrack,
+		Success:   true,
+		Data: map[string]interface{}{
+			"track": common.GetMetadata(track),
+		},
+	}
 }
 
 func getQueue(s *Server, msg wsMessage) Response {
@@ -188,3 +181,122 @@ func clientKeepAlivePing(s *Server, msg wsMessage) Response {
 		Success:   true,
 	}
 }
+
+func requestWebRTC(s *Server, msg wsMessage) Response {
+	if msg.socket == nil {
+		return Response{
+			Operation: opClientRequestWebRTC,
+			Success:   false,
+			Reason:    "WebRTC is only available over WebSocket",
+		}
+	}
+	s.closeRTCSession(msg.socket)
+	session, err := s.newRTCSession(msg.socket)
+	if err != nil {
+		log.Printf("[WebRTC] newRTCSession: %+v", err)
+		return Response{
+			Operation: opClientRequestWebRTC,
+			Success:   false,
+			Reason:    "Failed to create WebRTC session",
+		}
+	}
+	offer, err := session.Offer()
+	if err != nil {
+		log.Printf("[WebRTC] Offer: %+v", err)
+		session.Close()
+		return Response{
+			Operation: opClientRequestWebRTC,
+			Success:   false,
+			Reason:    "Failed to create WebRTC offer",
+		}
+	}
+	s.rtcSessions.Store(msg.socket, session)
+	return Response{
+		Operation: opClientRequestWebRTC,
+		Success:   true,
+		Data: map[string]interface{}{
+			"sdp": offer,
+		},
+	}
+}
+
+func answerWebRTC(s *Server, msg wsMessage) Response {
+	session, ok := s.rtcSessions.Load(msg.socket)
+	if msg.socket == nil || !ok {
+		return Response{
+			Operation: opClientWebRTCAnswer,
+			Success:   false,
+			Reason:    "No WebRTC session",
+		}
+	}
+	if err := session.(*rtcSession).Answer(msg.Query); err != nil {
+		log.Printf("[WebRTC] Answer: %+v", err)
+		s.closeRTCSession(msg.socket)
+		return Response{
+			Operation: opClientWebRTCAnswer,
+			Success:   false,
+			Reason:    "Invalid answer",
+		}
+	}
+	return Response{
+		Operation: opClientWebRTCAnswer,
+		Success:   true,
+	}
+}
+
+func stopWebRTC(s *Server, msg wsMessage) Response {
+	_, ok := s.rtcSessions.Load(msg.socket)
+	if msg.socket == nil || !ok {
+		return Response{
+			Operation: opClientStopWebRTC,
+			Success:   false,
+			Reason:    "No WebRTC session",
+		}
+	}
+	s.closeRTCSession(msg.socket)
+	return Response{
+		Operation: opClientStopWebRTC,
+		Success:   true,
+	}
+}
+
+func setLanguage(s *Server, msg wsMessage) Response {
+	if msg.socket == nil || msg.socket.ctx == nil {
+		return Response{
+			Operation: opClientSetLanguage,
+			Success:   false,
+			Reason:    "Lyrics language can only be set over WebSocket",
+		}
+	}
+	language := normalizeLanguage(msg.Query)
+	if len(language) == 0 && len(strings.TrimSpace(msg.Query)) > 0 {
+		return Response{
+			Operation: opClientSetLanguage,
+			Success:   false,
+			Reason:    "Invalid language",
+		}
+	}
+	ctx := msg.socket.ctx
+	ctx.L.Lock()
+	ctx.Language = language
+	ctx.L.Unlock()
+	language = s.socketLanguage(msg.socket)
+	s.trackMetaMux.Lock()
+	tl := s.currentLyrics
+	s.trackMetaMux.Unlock()
+	data := map[string]interface{}{
+		"language": language,
+	}
+	if tl != nil {
+		s.fetchLyrics(tl, language)
+		if lyrics, ok := tl.get(language, s.lyricsLanguage); ok {
+			data["playId"] = tl.playID
+			data["lyrics"] = lyrics
+		}
+	}
+	return Response{
+		Operation: opClientSetLanguage,
+		Success:   true,
+		Data:      data,
+	}
+}
@@ -0,0 +1,319 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	//allSources is the selector which searches on every source
+	allSources          = -1
+	searchTimeout       = 10 * time.Second
+	maxResultsPerSource = 10
+	maxSearchResults    = 30
+	//searchResultsTTL is how long search results can be enqueued by their playId
+	searchResultsTTL = 30 * time.Minute
+)
+
+//searchResult is a track found by a search, tagged with its source's ID
+type searchResult struct {
+	Source int                  `json:"source"`
+	Track  common.TrackMetadata `json:"track"`
+	track  common.Track
+	score  float64
+}
+
+//cachedSearchResult is a search result which can be enqueued by its playId
+type cachedSearchResult struct {
+	track   common.Track
+	source  common.MusicSource
+	expires time.Time
+}
+
+//searchResultsCache keeps recent search results
+type searchResultsCache struct {
+	mux     sync.Mutex
+	results map[string]cachedSearchResult
+}
+
+func (cache *searchResultsCache) Add(source common.MusicSource, tracks []common.Track) {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+	now := time.Now()
+	if cache.results == nil {
+		cache.results = make(map[string]cachedSearchResult)
+	}
+	for playID, result := range cache.results {
+		if now.After(result.expires) {
+			delete(cache.results, playID)
+		}
+	}
+	for _, track := range tracks {
+		cache.results[track.PlayID()] = cachedSearchResult{track: track, source: source, expires: now.Add(searchResultsTTL)}
+	}
+}
+
+//Get returns the result with playID
+func (cache *searchResultsCache) Get(playID string) (result cachedSearchResult, ok bool) {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+	result, ok = cache.results[playID]
+	return result, ok && time.Now().Before(result.expires)
+}
+
+//Take removes and returns the result with playID
+func (cache *searchResultsCache) Take(playID string) (result cachedSearchResult, ok bool) {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+	result, ok = cache.results[playID]
+	delete(cache.results, playID)
+	return result, ok && time.Now().Before(result.expires)
+}
+
+//searchResultKey identifies the same song on different sources
+func searchResultKey(track common.Track) string {
+	if isrc := strings.ToUpper(strings.TrimSpace(track.ISRC())); len(isrc) > 0 {
+		return "isrc:" + isrc
+	}
+	return "meta:" + common.NormalizeText(track.Title()) + "|" + common.NormalizeText(track.Artist())
+}
+
+//rankSearchResults merges the results of each source, in the sources' order, into a list of distinct songs sorted by relevance.
+//Songs are ranked by their best position in a source's results, songs found on several sources
+//and songs whose title is in the query are preferred
+func rankSearchResults(query string, results [][]common.Track) (ranked []searchResult) {
+	query = common.NormalizeText(query)
+	index := make(map[string]int)
+	for source, tracks := range results {
+		for rank, track := range tracks {
+			if rank >= maxResultsPerSource {
+				break
+			}
+			score := 1 / float64(1+rank)
+			key := searchResultKey(track)
+			if i, ok := index[key]; ok {
+				if score > ranked[i].score {
+					ranked[i].score = score
+				}
+				ranked[i].score += 0.1
+				continue
+			}
+			if title := common.NormalizeText(track.Title()); len(title) > 0 && strings.Contains(query, title) {
+				score += 0.5
+			}
+			index[key] = len(ranked)
+			ranked = append(ranked, searchResult{Source: source, track: track, score: score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	if len(ranked) > maxSearchResults {
+		ranked = ranked[:maxSearchResults]
+	}
+	for i := range ranked {
+		ranked[i].Track = common.GetMetadata(ranked[i].track)
+	}
+	return
+}
+
+//federatedSearch searches for query on all sources concurrently, sources which don't reply in time are ignored.
+//It returns the ranked results and the IDs of the sources which failed
+func (s *Server) federatedSearch(query string) (ranked []searchResult, failed []int) {
+	type sourceResult struct {
+		source int
+		tracks []common.Track
+		err    error
+	}
+	failed = []int{}
+	replies := make(chan sourceResult, len(s.sources))
+	for i, source := range s.sources {
+		go func(i int, source common.MusicSource) {
+			tracks, err := source.Search(query)
+			replies <- sourceResult{i, tracks, err}
+		}(i, source)
+	}
+	results := make([][]common.Track, len(s.sources))
+	timeout := time.After(searchTimeout)
+	for remaining := len(s.sources); remaining > 0; remaining-- {
+		select {
+		case reply := <-replies:
+			source := s.sources[reply.source]
+			s.setSourceHealth(source, reply.err)
+			if common.IsInvalidQuery(reply.err) {
+				//the source doesn't handle queries like this one
+				results[reply.source] = []common.Track{}
+				continue
+			}
+			if reply.err != nil {
+				failed = append(failed, reply.source)
+				continue
+			}
+			results[reply.source] = reply.tracks
+			s.searchResults.Add(source, reply.tracks)
+		case <-timeout:
+			for i := range s.sources {
+				if results[i] == nil && !containsInt(failed, i) {
+					failed = append(failed, i)
+				}
+			}
+			remaining = 0
+		}
+	}
+	sort.Ints(failed)
+	return rankSearchResults(query, results), failed
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//search returns the ranked results of a query on the selected source, or on all of them if the selector is allSources.
+//Results can be enqueued by sending their playId as the query of opClientRequestTrack
+func search(s *Server, msg wsMessage) Response {
+	if len(msg.Query) == 0 {
+		return Response{
+			Operation: opClientSearch,
+			Success:   false,
+			Reason:    "Invalid Query!",
+		}
+	}
+	var results []searchResult
+	failed := []int{}
+	switch {
+	case msg.Selector == allSources:
+		results, failed = s.federatedSearch(msg.Query)
+	case msg.Selector >= 0 && msg.Selector < len(s.sources):
+		source := s.sources[msg.Selector]
+		tracks, err := source.Search(msg.Query)
+		s.setSourceHealth(source, err)
+		if err != nil {
+			reason := errSearchFailed.Error()
+			if common.IsInvalidQuery(err) {
+				reason = err.Error()
+			}
+			return Response{
+				Operation: opClientSearch,
+				Success:   false,
+				Reason:    reason,
+			}
+		}
+		s.searchResults.Add(source, tracks)
+		trackLists := make([][]common.Track, len(s.sources))
+		trackLists[msg.Selector] = tracks
+		results = rankSearchResults(msg.Query, trackLists)
+	default:
+		return Response{
+			Operation: opClientSearch,
+			Success:   false,
+			Reason:    "Invalid source!",
+		}
+	}
+	if results == nil {
+		results = []searchResult{}
+	}
+	return Response{
+		Operation: opClientSearch,
+		Success:   true,
+		Data: map[string]interface{}{
+			"results": results,
+			"failed":  failed,
+		},
+	}
+}
+
+func (s *Server) searchHandler(c echo.Context) (err error) {
+	w := c.Response()
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	msg := wsMessage{Operation: opClientSearch, Query: c.QueryParam("query"), Selector: allSources}
+	if selector := c.QueryParam("source"); len(selector) > 0 && selector != "all" {
+		if msg.Selector, err = strconv.Atoi(selector); err != nil {
+			if source := s.findSource(selector); source != nil {
+				msg.Selector = s.sourceIndex(source)
+			} else {
+				return echo.NewHTTPError(http.StatusBadRequest, Response{
+					Operation: opClientSearch,
+					Success:   false,
+					Reason:    "Invalid source!",
+				})
+			}
+		}
+	}
+	_, _ = w.Write(s.handleMessage(&msg))
+	return nil
+}
+
+//enqueueSearchResult populates and enqueues a track found by a search
+func (s *Server) enqueueSearchResult(source common.MusicSource, track common.Track) Response {
+	if err := track.Populate(); err != nil {
+		log.Printf("[MusicStream] track.Populate() failed: %+v", err)
+		s.setSourceHealth(source, err)
+		return Response{
+			Operation: opClientRequestTrack,
+			Success:   false,
+			Reason:    errSearchFailed.Error(),
+		}
+	}
+	return s.enqueueTrack(track)
+}
+
+//enqueueBestResult enqueues the best result of a search on all sources
+func (s *Server) enqueueBestResult(query string) Response {
+	results, failed := s.federatedSearch(query)
+	if len(results) == 0 {
+		reason := errNoResult
+		if len(failed) == len(s.sources) {
+			reason = errSearchFailed
+		}
+		return Response{
+			Operation: opClientRequestTrack,
+			Success:   false,
+			Reason:    reason.Error(),
+		}
+	}
+	result := results[0]
+	s.searchResults.Take(result.track.PlayID())
+	return s.enqueueSearchResult(s.sources[result.Source], result.track)
+}
+
+func (s *Server) sourceIndex(source common.MusicSource) int {
+	for i, v := range s.sources {
+		if v == source {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+type searchTestTrack struct {
+	common.DefaultTrack
+	title, artist, isrc, playID string
+}
+
+func (track *searchTestTrack) Title() string  { return track.title }
+func (track *searchTestTrack) Artist() string { return track.artist }
+func (track *searchTestTrack) ISRC() string   { return track.isrc }
+func (track *searchTestTrack) PlayID() string { return track.playID }
+
+func TestRankSearchResults(t *testing.T) {
+	results := [][]common.Track{
+		{
+			&searchTestTrack{title: "Hello (Official Video)", artist: "Adele", playID: "a1"},
+			&searchTestTrack{title: "Someone Like You", artist: "Adele", isrc: "GBBKS1000351", playID: "a2"},
+		},
+		nil,
+		{
+			&searchTestTrack{title: "Hello", artist: "ADELE", playID: "c1"},
+			&searchTestTrack{title: "Someone like you", artist: "Adele (Live)", isrc: "gbbks1000351", playID: "c2"},
+			&searchTestTrack{title: "Hello!", artist: "Adele", playID: "c3"},
+		},
+	}
+	ranked := rankSearchResults("adele hello", results)
+	if len(ranked) != 3 {
+		t.Fatalf("got %d results, want 3: %+v", len(ranked), ranked)
+	}
+	if ranked[0].Track.PlayID != "c1" || ranked[0].Source != 2 {
+		t.Errorf("the result whose title matches the query should be first, got %s from %d", ranked[0].Track.PlayID, ranked[0].Source)
+	}
+	for _, result := range ranked {
+		if result.Track.PlayID == "c2" || result.Track.PlayID == "c3" {
+			t.Errorf("%s is a duplicate", result.Track.PlayID)
+		}
+	}
+}
+
+func TestFederatedSearch(t *testing.T) {
+	s := &Server{sources: []common.MusicSource{
+		&healthTestSource{name: "one"},
+		&healthTestSource{name: "broken", err: errors.New("down")},
+		&healthTestSource{name: "two"},
+	}}
+	s.initSourcesHealth()
+	results, failed := s.federatedSearch("song")
+	if len(results) != 2 || results[0].Source != 0 || results[1].Source != 2 {
+		t.Errorf("federatedSearch() = %+v", results)
+	}
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Errorf("failed = %v, want [1]", failed)
+	}
+	if _, ok := s.searchResults.Take(results[1].Track.PlayID); !ok {
+		t.Error("search results should be cached")
+	}
+}
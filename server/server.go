@@ -23,15 +23,20 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/TrungNguyen1909/MusicStream"
 	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/lyrics"
 	"github.com/TrungNguyen1909/MusicStream/mp3encoder"
 	"github.com/TrungNguyen1909/MusicStream/mxmlyrics"
+	"github.com/TrungNguyen1909/MusicStream/opusencoder"
+	"github.com/TrungNguyen1909/MusicStream/playlist"
 	"github.com/TrungNguyen1909/MusicStream/queue"
+	"github.com/TrungNguyen1909/MusicStream/rpcplugin"
 	"github.com/TrungNguyen1909/MusicStream/vorbisencoder"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -39,62 +44,94 @@ import (
 )
 
 const (
-	opListSources         = 1
-	opSetClientsTrack     = 2
-	opAllClientsSkip      = 3
-	opClientRequestTrack  = 4
-	opClientRequestSkip   = 5
-	opSetClientsListeners = 6
-	opTrackEnqueued       = 7
-	opClientRequestQueue  = 8
-	opWebSocketKeepAlive  = 9
-	opClientRemoveTrack   = 10
-	opClientAudioStartPos = 11
+	opListSources              = 1
+	opSetClientsTrack          = 2
+	opAllClientsSkip           = 3
+	opClientRequestTrack       = 4
+	opClientRequestSkip        = 5
+	opSetClientsListeners      = 6
+	opTrackEnqueued            = 7
+	opClientRequestQueue       = 8
+	opWebSocketKeepAlive       = 9
+	opClientRemoveTrack        = 10
+	opClientAudioStartPos      = 11
+	opClientRequestWebRTC      = 12
+	opClientWebRTCAnswer       = 13
+	opClientStopWebRTC         = 14
+	opLyricsUpdated            = 15
+	opClientSetLanguage        = 16
+	opClientImportPlaylist     = 17
+	opPlaylistImportProgress   = 18
+	opClientListPlaylists      = 19
+	opClientGetPlaylist        = 20
+	opClientCreatePlaylist     = 21
+	opClientRenamePlaylist     = 22
+	opClientDeletePlaylist     = 23
+	opClientAddToPlaylist      = 24
+	opClientRemoveFromPlaylist = 25
+	opClientEnqueuePlaylist    = 26
+	opClientSearch             = 27
+	opStreamError              = 28
+	opTrackOpening             = 29
 )
 
 const (
 	cookieSessionID = "sessionId"
-	defaultStartPos = 0
+	//cookieLyricsLanguage is set by clients to restore their lyrics language when their session is gone
+	cookieLyricsLanguage = "lyricsLanguage"
+	defaultStartPos      = 0
 )
 
+var defaultLyricsProviders = []string{lyrics.SourceProviderName, lyrics.TranscriptProviderName, lyrics.LRCProviderName, mxmlyrics.ProviderName}
+
 //Server is a MusicStream server
 type Server struct {
-	upgrader             websocket.Upgrader
-	connections          sync.Map
-	currentTrack         common.Track
-	currentTrackMeta     atomic.Value
-	mxmClient            *mxmlyrics.Client
-	playQueue            *queue.Queue
-	currentVorbisChannel int
-	currentMP3Channel    int
-	vorbisSubscribers    *int64
-	mp3Subscribers       *int64
-	vorbisChannel        []chan chan *chunk
-	mp3Channel           []chan chan *chunk
-	oggHeader            []byte
-	mp3Header            []byte
-	vorbisChunkID        *int64
-	mp3ChunkID           *int64
-	listenersCount       int32
-	bufferingChannel     chan *chunk
-	streamContext        context.Context
-	skipFunc             context.CancelFunc
-	defaultTrack         *common.DefaultTrack
-	startPos             [2]int64
-	lastStreamEnded      time.Time
-	vorbisEncoder        *vorbisencoder.Encoder
-	mp3Encoder           *mp3encoder.Encoder
-	deltaChannel         chan int64
-	startTime            time.Time
-	cacheQueue           *queue.Queue
-	streamMux            sync.Mutex
-	activityWg           sync.WaitGroup
-	newListenerC         chan int
-	server               *echo.Echo
-	messageHandlers      map[int]RequestHandler
-	processedNonce       sync.Map
-	authCtxs             sync.Map
-	sources              []common.MusicSource
+	upgrader            websocket.Upgrader
+	connections         sync.Map
+	currentTrack        common.Track
+	currentTrackMeta    atomic.Value
+	trackMetaMux        sync.Mutex
+	currentLyrics       *trackLyrics
+	lyricsLanguage      string
+	lyricsChain         *lyrics.Chain
+	lyricsCache         *lyrics.Cache
+	playQueue           *queue.Queue
+	vorbisBroadcast     *broadcastBuffer
+	mp3Broadcast        *broadcastBuffer
+	opusBroadcast       *broadcastBuffer
+	oggHeader           []byte
+	mp3Header           []byte
+	listenersCount      int32
+	bufferingChannel    chan *chunk
+	streamContext       context.Context
+	skipFunc            context.CancelFunc
+	defaultTrack        *common.DefaultTrack
+	startPos            [2]int64
+	lastStreamEnded     time.Time
+	vorbisEncoder       *vorbisencoder.Encoder
+	mp3Encoder          *mp3encoder.Encoder
+	opusEncoder         *opusencoder.Encoder
+	deltaChannel        chan int64
+	startTime           atomic.Value
+	cacheQueue          *queue.Queue
+	history             *queue.Queue
+	playlists           playlist.Store
+	streamMux           sync.Mutex
+	activityWg          sync.WaitGroup
+	newListenerC        chan int
+	server              *echo.Echo
+	messageHandlers     map[int]RequestHandler
+	processedNonce      sync.Map
+	authCtxs            sync.Map
+	sources             []common.MusicSource
+	sourcesHealth       map[string]*sourceHealth
+	searchFallback      bool
+	searchResults       searchResultsCache
+	iceServers          []string
+	preferNativeDecoder bool
+	rtcSessions         sync.Map
+	subsonicUsername    string
+	subsonicPassword    string
 }
 
 //AddMessageHandler registers a new message handler for the specified opcode
@@ -102,6 +139,11 @@ func (s *Server) AddMessageHandler(opcode int, handler RequestHandler) {
 	s.messageHandlers[opcode] = handler
 }
 
+//AddLyricsProvider appends a lyrics provider to the end of the server's lyrics chain
+func (s *Server) AddLyricsProvider(provider common.LyricsProvider) {
+	s.lyricsChain.Add(provider)
+}
+
 //RemoveMessageHandler unregisters the specified opcode
 func (s *Server) RemoveMessageHandler(opcode int) {
 	delete(s.messageHandlers, opcode)
@@ -110,6 +152,7 @@ func (s *Server) RemoveMessageHandler(opcode int) {
 //Start starts the server, listening at addr
 func (s *Server) Start(addr string) (err error) {
 	go s.selfPinger()
+	go s.healthMonitor()
 	go s.inactivityMonitor()
 	go func() {
 		for {
@@ -130,6 +173,7 @@ func (s *Server) Start(addr string) (err error) {
 //StartWithTLS starts the server, listening at addr, also tries to get a cert from LetsEncrypt
 func (s *Server) StartWithTLS(addr string) (err error) {
 	go s.selfPinger()
+	go s.healthMonitor()
 	go s.inactivityMonitor()
 	go func() {
 		for {
@@ -155,23 +199,42 @@ func (s *Server) Close() error {
 			closer.Close()
 		}
 	}
+	if s.lyricsCache != nil {
+		if err := s.lyricsCache.Close(); err != nil {
+			log.Println("[MusicStream] Failed to save lyrics cache: ", err)
+		}
+	}
 	return nil
 }
 
+//addSource configures and adds a music source, which is moved to the first place if it's the default source.
+//It returns false if a source with the same name was already added or it could not be configured
+func (s *Server) addSource(client common.MusicSource, config Config) bool {
+	for _, source := range s.sources {
+		if source.Name() == client.Name() {
+			log.Printf("[MusicStream] Source %s is already loaded", client.Name())
+			return false
+		}
+	}
+	if !configureSource(client, config.SourcesConfig) {
+		return false
+	}
+	if client.Name() == config.DefaultMusicSource && len(s.sources) > 0 {
+		t := s.sources[0]
+		s.sources[0] = client
+		client = t
+	}
+	s.sources = append(s.sources, client)
+	return true
+}
+
 //NewServer returns a new server
 func NewServer(config Config) *Server {
 	s := &Server{}
 	s.bufferingChannel = make(chan *chunk, 5000)
-	s.mp3Channel = make([]chan chan *chunk, 2)
-	s.vorbisChannel = make([]chan chan *chunk, 2)
-	for i := 0; i < 2; i++ {
-		s.mp3Channel[i] = make(chan chan *chunk, 500)
-		s.vorbisChannel[i] = make(chan chan *chunk, 500)
-	}
-	s.vorbisSubscribers = new(int64)
-	s.mp3Subscribers = new(int64)
-	s.vorbisChunkID = new(int64)
-	s.mp3ChunkID = new(int64)
+	s.vorbisBroadcast = newBroadcastBuffer(broadcastBufferSize)
+	s.mp3Broadcast = newBroadcastBuffer(broadcastBufferSize)
+	s.opusBroadcast = newBroadcastBuffer(broadcastBufferSize)
 	s.deltaChannel = make(chan int64, 2)
 	s.newListenerC = make(chan int, 1)
 	s.vorbisEncoder = vorbisencoder.NewEncoder(2, 48000, 320000)
@@ -182,8 +245,19 @@ func NewServer(config Config) *Server {
 	s.mp3Header = make([]byte, 8000)
 	n = s.mp3Encoder.Encode(s.mp3Header, make([]byte, 1152*4))
 	s.mp3Header = s.mp3Header[:n]
+	s.opusEncoder = opusencoder.NewEncoder(2, 48000, 128000)
+	s.preferNativeDecoder = config.PreferNativeDecoder
+	s.iceServers = config.ICEServers
+	if len(s.iceServers) == 0 {
+		s.iceServers = []string{defaultICEServer}
+	}
+	s.subsonicUsername = config.SubsonicUsername
+	s.subsonicPassword = config.SubsonicPassword
 
 	var err error
+	for _, client := range config.Sources {
+		s.addSource(client, config)
+	}
 	log.Println("[MusicStream] initializing source plugins")
 	for _, p := range config.Plugins {
 		sName, err := p.Lookup("Name")
@@ -211,25 +285,84 @@ func NewServer(config Config) *Server {
 			log.Printf("[MusicStream] NewClient failed on plugin %s: %s", *name, err)
 			continue
 		}
-		if client.Name() == config.DefaultMusicSource && len(s.sources) > 0 {
-			t := s.sources[0]
-			s.sources[0] = client
-			client = t
+		if s.addSource(client, config) {
+			log.Printf("[MusicStream] Successfully loaded plugin %s", *name)
+		}
+	}
+	for _, command := range config.ExternalPlugins {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			continue
+		}
+		client, err := rpcplugin.Start(args[0], args[1:]...)
+		if err != nil {
+			log.Printf("[MusicStream] Failed to start plugin %s: %s", args[0], err)
+			continue
+		}
+		if s.addSource(client, config) {
+			log.Printf("[MusicStream] Successfully started plugin %s", client.Name())
+		} else {
+			client.Close()
 		}
-		s.sources = append(s.sources, client)
-		log.Printf("[MusicStream] Successfully loaded plugin %s", *name)
 	}
 	if len(s.sources) <= 0 {
 		log.Panic("[MusicStream] ERROR: No sources intialized")
 	} else {
 		log.Printf("[MusicStream] Loaded %d sources", len(s.sources))
 	}
-	s.mxmClient, err = mxmlyrics.NewClient(config.MusixMatchUserToken, config.MusixMatchOBUserToken)
+	s.initSourcesHealth()
+	s.searchFallback = config.SearchFallback
+	lrcProvider := &lyrics.LRCProvider{Dirs: config.LyricsDirs}
+	if len(config.LyricsDirs) > 0 {
+		go lrcProvider.Index()
+	}
+	lyricsProviders := map[string]common.LyricsProvider{
+		lyrics.SourceProviderName:     lyrics.SourceProvider{},
+		lyrics.TranscriptProviderName: lyrics.TranscriptProvider{},
+		lyrics.LRCProviderName:        lrcProvider,
+	}
+	mxmClient, err := mxmlyrics.NewClient(config.MusixMatchUserToken, config.MusixMatchOBUserToken)
 	if err != nil {
 		log.Println("[MusixMatch] Failed to initalized: ", err)
 		err = nil
+	} else {
+		lyricsProviders[mxmClient.Name()] = mxmClient
+	}
+	lyricsOrder := config.LyricsProviders
+	if len(lyricsOrder) == 0 {
+		lyricsOrder = defaultLyricsProviders
+	}
+	s.lyricsChain = lyrics.NewChain()
+	for _, name := range lyricsOrder {
+		if provider, ok := lyricsProviders[name]; ok {
+			s.lyricsChain.Add(provider)
+		} else {
+			log.Printf("[MusicStream] Lyrics provider %s is not available", name)
+		}
+	}
+	var lyricsStore lyrics.CacheStore = lyrics.NewMemoryStore()
+	if len(config.LyricsCachePath) > 0 {
+		if fileStore, err := lyrics.NewFileStore(config.LyricsCachePath); err != nil {
+			log.Println("[MusicStream] Failed to load lyrics cache: ", err)
+		} else {
+			lyricsStore = fileStore
+		}
+	}
+	s.lyricsCache = lyrics.NewCache(s.lyricsChain, lyricsStore)
+	s.lyricsLanguage = normalizeLanguage(config.LyricsLanguage)
+	if len(s.lyricsLanguage) == 0 {
+		s.lyricsLanguage = defaultLyricsLanguage
 	}
 	s.cacheQueue = queue.New()
+	s.history = queue.New()
+	s.playlists = playlist.NewMemoryStore()
+	if len(config.PlaylistsPath) > 0 {
+		if fileStore, err := playlist.NewFileStore(config.PlaylistsPath); err != nil {
+			log.Println("[MusicStream] Failed to load playlists: ", err)
+		} else {
+			s.playlists = fileStore
+		}
+	}
 	s.playQueue = queue.New()
 	s.playQueue.PushCallback = s.enqueueCallback
 	s.playQueue.PopCallback = s.dequeueCallback
@@ -265,13 +398,27 @@ func NewServer(config Config) *Server {
 	s.messageHandlers = make(map[int]RequestHandler)
 	s.AddMessageHandler(opListSources, getSourcesList)
 	s.AddMessageHandler(opSetClientsTrack, getPlaying)
+	s.AddMessageHandler(opClientSetLanguage, setLanguage)
+	s.AddMessageHandler(opClientImportPlaylist, importPlaylist)
+	s.AddMessageHandler(opClientListPlaylists, listPlaylists)
+	s.AddMessageHandler(opClientGetPlaylist, getPlaylist)
+	s.AddMessageHandler(opClientCreatePlaylist, createPlaylist)
+	s.AddMessageHandler(opClientRenamePlaylist, renamePlaylist)
+	s.AddMessageHandler(opClientDeletePlaylist, deletePlaylist)
+	s.AddMessageHandler(opClientAddToPlaylist, addToPlaylist)
+	s.AddMessageHandler(opClientRemoveFromPlaylist, removeFromPlaylist)
+	s.AddMessageHandler(opClientEnqueuePlaylist, enqueuePlaylist)
 	s.AddMessageHandler(opClientRequestTrack, enqueue)
+	s.AddMessageHandler(opClientSearch, search)
 	s.AddMessageHandler(opClientRequestSkip, skip)
 	s.AddMessageHandler(opSetClientsListeners, getListenersCount)
 	s.AddMessageHandler(opClientRemoveTrack, removeTrack)
 	s.AddMessageHandler(opClientRequestQueue, getQueue)
 	s.AddMessageHandler(opWebSocketKeepAlive, clientKeepAlivePing)
 	s.AddMessageHandler(opClientRemoveTrack, removeTrack)
+	s.AddMessageHandler(opClientRequestWebRTC, requestWebRTC)
+	s.AddMessageHandler(opClientWebRTCAnswer, answerWebRTC)
+	s.AddMessageHandler(opClientStopWebRTC, stopWebRTC)
 	s.server.POST("/enqueue", s.enqueueHandler)
 	s.server.GET("/listeners", s.listenersHandler)
 	s.server.GET("/audio", s.audioHandler)
@@ -279,9 +426,23 @@ func NewServer(config Config) *Server {
 	s.server.GET("/status", s.wsHandler)
 	s.server.GET("/playing", s.playingHandler)
 	s.server.GET("/sources", s.listSourcesHandler)
+	s.server.GET("/search", s.searchHandler)
 	s.server.GET("/skip", s.skipHandler)
 	s.server.POST("/remove", s.removeTrackHandler)
 	s.server.GET("/queue", s.queueHandler)
+	s.server.GET("/cover/:source", s.coverHandler)
+	s.server.POST("/playlist/import", s.importPlaylistHandler)
+	s.server.GET("/queue/export", s.exportQueueHandler)
+	s.server.GET("/history/export", s.exportHistoryHandler)
+	s.server.Any("/rest/:method", s.subsonicHandler)
+	s.server.GET("/playlists", s.playlistHandler(opClientListPlaylists))
+	s.server.POST("/playlists", s.playlistHandler(opClientCreatePlaylist))
+	s.server.GET("/playlists/:id", s.playlistHandler(opClientGetPlaylist))
+	s.server.PUT("/playlists/:id", s.playlistHandler(opClientRenamePlaylist))
+	s.server.DELETE("/playlists/:id", s.playlistHandler(opClientDeletePlaylist))
+	s.server.POST("/playlists/:id/tracks", s.playlistHandler(opClientAddToPlaylist))
+	s.server.DELETE("/playlists/:id/tracks/:index", s.playlistHandler(opClientRemoveFromPlaylist))
+	s.server.POST("/playlists/:id/enqueue", s.playlistHandler(opClientEnqueuePlaylist))
 	if len(config.StaticFilesPath) > 0 {
 		s.server.Static("/", config.StaticFilesPath)
 	} else {
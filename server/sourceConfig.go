@@ -0,0 +1,91 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+//configFile is the content of the configuration file
+type configFile struct {
+	//Sources contains a section for each source, by name
+	Sources map[string]map[string]interface{} `json:"sources"`
+}
+
+//LoadConfigFile loads the sources' configuration from the JSON file at path into config
+func LoadConfigFile(path string, config *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	var file configFile
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&file); err != nil {
+		return errors.Wrap(err, path)
+	}
+	config.SourcesConfig = file.Sources
+	return nil
+}
+
+//sourceConfig returns the configuration section of the source name, which is matched case-insensitively
+func sourceConfig(config map[string]map[string]interface{}, name string) (section map[string]interface{}, ok bool) {
+	if section, ok = config[name]; ok {
+		return
+	}
+	for key, section := range config {
+		if strings.EqualFold(key, name) {
+			return section, true
+		}
+	}
+	return nil, false
+}
+
+//configureSource configures client if it's a ConfigurableMusicSource.
+//If the configuration is invalid, the server panics if the source has a section in the configuration,
+//otherwise the source is skipped, as it might not be meant to be used
+func configureSource(client common.MusicSource, config map[string]map[string]interface{}) bool {
+	section, hasSection := sourceConfig(config, client.Name())
+	configurable, ok := client.(common.ConfigurableMusicSource)
+	if !ok {
+		if hasSection && len(section) > 0 {
+			log.Panicf("[MusicStream] Source %s does not have any options", client.Name())
+		}
+		return true
+	}
+	values, err := common.ValidateConfig(configurable.ConfigSchema(), section)
+	if err == nil {
+		err = configurable.Configure(values)
+	}
+	if err != nil {
+		if hasSection {
+			log.Panicf("[MusicStream] Invalid configuration of source %s: %v", client.Name(), err)
+		}
+		log.Printf("[MusicStream] Source %s is not configured: %v", client.Name(), err)
+		return false
+	}
+	return true
+}
@@ -34,15 +34,39 @@ import (
 type Config struct {
 	MusixMatchUserToken   string
 	MusixMatchOBUserToken string
-	Plugins               []*plugin.Plugin
-	StaticFilesPath       string
-	DefaultMusicSource    string
+	//Sources contains music sources compiled into the server, they are added before plugins
+	Sources []common.MusicSource
+	Plugins []*plugin.Plugin
+	//SourcesConfig contains the configuration of each source, by name
+	SourcesConfig map[string]map[string]interface{}
+	//ExternalPlugins contains the command lines of out-of-process plugins' executables
+	ExternalPlugins    []string
+	StaticFilesPath    string
+	DefaultMusicSource string
+	//SearchFallback retries failed searches on the other healthy sources, unless the query is specific to the selected one
+	SearchFallback bool
+	//ICEServers contains the STUN/TURN servers' URLs used for WebRTC listeners
+	ICEServers []string
+	//PreferNativeDecoder decodes MP3, FLAC, Ogg Vorbis and WAV streams in pure Go instead of libav
+	PreferNativeDecoder bool
+	//LyricsProviders contains the names of the lyrics providers to query, in order of preference
+	LyricsProviders []string
+	//LyricsDirs contains the directories searched for LRC files
+	LyricsDirs []string
+	//LyricsCachePath is the file where fetched lyrics are cached, lyrics are only cached in memory if it's empty
+	LyricsCachePath string
+	//LyricsLanguage is the language that lyrics are translated into for clients which haven't chosen one, English if empty
+	LyricsLanguage string
+	//PlaylistsPath is the directory where saved playlists are stored, they are only kept in memory if it's empty
+	PlaylistsPath string
+	//SubsonicUsername and SubsonicPassword are the credentials of the Subsonic API, anyone can use it if the password is empty
+	SubsonicUsername string
+	SubsonicPassword string
 }
 
 type chunk struct {
 	buffer     []byte
 	encoderPos int64
-	channel    int
 	chunkID    int64
 }
 type wsMessage struct {
@@ -50,11 +74,15 @@ type wsMessage struct {
 	Query     string `json:"query"`
 	Selector  int    `json:"selector"`
 	Nonce     int    `json:"nonce"`
+	//socket is the websocket that the message was received from, nil if it came from the HTTP API
+	socket *webSocket
 }
 
 type webSocket struct {
 	conn *websocket.Conn
 	mux  *sync.Mutex
+	//ctx is the context of the client's session, it's not stored in authCtxs if the client has no sessionId
+	ctx *authenticatedContext
 }
 
 type authenticatedContext struct {
@@ -62,7 +90,9 @@ type authenticatedContext struct {
 	WS              *webSocket
 	StartPos        int64
 	AudioDisconnect chan int
-	L               *sync.Mutex
+	//Language is the language that the client wants lyrics translated into, the server's default if empty
+	Language string
+	L        *sync.Mutex
 }
 
 func (socket *webSocket) WriteMessage(messageType int, data []byte) error {
@@ -100,17 +130,42 @@ func (r Response) EncodeJSON() []byte {
 //RequestHandler is a function that handles a request from user.
 type RequestHandler func(s *Server, msg wsMessage) Response
 
-//GetRawStream returns a decoded stream from a common.Stream
-func GetRawStream(s common.Stream) (stream io.ReadCloser, err error) {
+//GetRawStream returns a decoded stream from a common.Stream.
+//If preferNative is set, supported formats are decoded in pure Go instead of libav
+func GetRawStream(s common.Stream, preferNative bool) (stream io.ReadCloser, err error) {
 	body := s.Body()
 	if body == nil {
 		return nil, errors.New("Invalid stream")
 	}
+	var info common.StreamInfo
+	if streamWithInfo, ok := s.(common.StreamWithInfo); ok {
+		info = streamWithInfo.Info()
+	}
 	switch s.Format() {
 	case common.RawStream:
-		return body, nil
+		if (info.SampleRate == 0 || info.SampleRate == 48000) && (info.Channels == 0 || info.Channels == 2) {
+			return body, nil
+		}
+		sampleRate, channels := info.SampleRate, info.Channels
+		if sampleRate == 0 {
+			sampleRate = 48000
+		}
+		if channels == 0 {
+			channels = 2
+		}
+		stream, err = streamdecoder.NewPCMDecoder(body, sampleRate, channels)
+		if err != nil {
+			stream = nil
+		}
+		return
 	default:
-		stream, err = streamdecoder.NewAVDecoder(body)
+		stream, err = streamdecoder.NewDecoder(body, streamdecoder.Options{
+			Format:        info.Codec,
+			ContentType:   info.ContentType,
+			ContentLength: info.ContentLength,
+			Seekable:      info.Seekable,
+			PreferNative:  preferNative,
+		})
 		if err != nil {
 			stream = nil
 		}
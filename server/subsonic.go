@@ -0,0 +1,475 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	MusicStream "github.com/TrungNguyen1909/MusicStream"
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	subsonicAPIVersion = "1.16.1"
+	//subsonicRoomStreamID is the song ID which streams the room itself
+	subsonicRoomStreamID  = "room"
+	subsonicDefaultSongs  = 20
+	subsonicErrorGeneric  = 0
+	subsonicErrorMissing  = 10
+	subsonicErrorAuth     = 40
+	subsonicErrorNotFound = 70
+)
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicSong struct {
+	ID       string `xml:"id,attr" json:"id"`
+	IsDir    bool   `xml:"isDir,attr" json:"isDir"`
+	Title    string `xml:"title,attr" json:"title"`
+	Artist   string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Album    string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Duration int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Type     string `xml:"type,attr" json:"type"`
+}
+
+type subsonicSearchResult struct {
+	Songs []subsonicSong `xml:"song" json:"song"`
+}
+
+type subsonicPlayQueue struct {
+	Current   string         `xml:"current,attr,omitempty" json:"current,omitempty"`
+	Position  int64          `xml:"position,attr,omitempty" json:"position,omitempty"`
+	Username  string         `xml:"username,attr" json:"username"`
+	Changed   time.Time      `xml:"changed,attr" json:"changed"`
+	ChangedBy string         `xml:"changedBy,attr" json:"changedBy"`
+	Entries   []subsonicSong `xml:"entry" json:"entry"`
+}
+
+type subsonicJukeboxStatus struct {
+	CurrentIndex int     `xml:"currentIndex,attr" json:"currentIndex"`
+	Playing      bool    `xml:"playing,attr" json:"playing"`
+	Gain         float32 `xml:"gain,attr" json:"gain"`
+	Position     int     `xml:"position,attr,omitempty" json:"position,omitempty"`
+}
+
+type subsonicJukeboxPlaylist struct {
+	subsonicJukeboxStatus
+	Entries []subsonicSong `xml:"entry" json:"entry"`
+}
+
+//subsonicResponse is the root element of every Subsonic API response
+type subsonicResponse struct {
+	XMLName         xml.Name                 `xml:"http://subsonic.org/restapi subsonic-response" json:"-"`
+	Status          string                   `xml:"status,attr" json:"status"`
+	Version         string                   `xml:"version,attr" json:"version"`
+	Type            string                   `xml:"type,attr" json:"type"`
+	ServerVersion   string                   `xml:"serverVersion,attr" json:"serverVersion"`
+	Error           *subsonicError           `xml:"error,omitempty" json:"error,omitempty"`
+	License         *subsonicLicense         `xml:"license,omitempty" json:"license,omitempty"`
+	SearchResult3   *subsonicSearchResult    `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	PlayQueue       *subsonicPlayQueue       `xml:"playQueue,omitempty" json:"playQueue,omitempty"`
+	JukeboxStatus   *subsonicJukeboxStatus   `xml:"jukeboxStatus,omitempty" json:"jukeboxStatus,omitempty"`
+	JukeboxPlaylist *subsonicJukeboxPlaylist `xml:"jukeboxPlaylist,omitempty" json:"jukeboxPlaylist,omitempty"`
+}
+
+//subsonicMethod handles a Subsonic API method, it returns nil if it has already written the response
+type subsonicMethod func(s *Server, c echo.Context, params url.Values) *subsonicResponse
+
+var subsonicMethods = map[string]subsonicMethod{
+	"ping":           subsonicPing,
+	"getLicense":     subsonicGetLicense,
+	"search3":        subsonicSearch3,
+	"stream":         subsonicStream,
+	"download":       subsonicStream,
+	"getPlayQueue":   subsonicGetPlayQueue,
+	"jukeboxControl": subsonicJukeboxControl,
+}
+
+var subsonicContentTypes = map[string]string{
+	common.CodecMP3:    "audio/mpeg",
+	common.CodecFLAC:   "audio/flac",
+	common.CodecVorbis: "audio/ogg",
+	common.CodecOpus:   "audio/ogg",
+	common.CodecWAV:    "audio/wav",
+	common.CodecAAC:    "audio/aac",
+	common.CodecMP4:    "audio/mp4",
+	common.CodecWebM:   "audio/webm",
+}
+
+func newSubsonicResponse() *subsonicResponse {
+	return &subsonicResponse{
+		Status:        "ok",
+		Version:       subsonicAPIVersion,
+		Type:          "musicstream",
+		ServerVersion: MusicStream.Version,
+	}
+}
+
+func subsonicFailure(code int, message string) *subsonicResponse {
+	resp := newSubsonicResponse()
+	resp.Status = "failed"
+	resp.Error = &subsonicError{Code: code, Message: message}
+	return resp
+}
+
+func newSubsonicSong(meta common.TrackMetadata) subsonicSong {
+	return subsonicSong{
+		ID:       meta.PlayID,
+		Title:    meta.Title,
+		Artist:   meta.Artist,
+		Album:    meta.Album,
+		Duration: meta.Duration,
+		Type:     "music",
+	}
+}
+
+//subsonicAuthenticate checks the credentials of a request, either a token (md5(password + salt)) and its salt,
+//or a password in clear or hex-encoded with the "enc:" prefix. Any credentials are accepted if password is empty
+func subsonicAuthenticate(username, password string, params url.Values) *subsonicResponse {
+	if len(password) == 0 {
+		return nil
+	}
+	user := params.Get("u")
+	if len(user) == 0 {
+		return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: u")
+	}
+	if len(username) > 0 && user != username {
+		return subsonicFailure(subsonicErrorAuth, "Wrong username or password")
+	}
+	var given, expected string
+	if token := params.Get("t"); len(token) > 0 {
+		sum := md5.Sum([]byte(password + params.Get("s")))
+		given, expected = strings.ToLower(token), hex.EncodeToString(sum[:])
+	} else if p := params.Get("p"); len(p) > 0 {
+		given, expected = p, password
+		if strings.HasPrefix(p, "enc:") {
+			decoded, err := hex.DecodeString(p[len("enc:"):])
+			if err != nil {
+				return subsonicFailure(subsonicErrorAuth, "Wrong username or password")
+			}
+			given = string(decoded)
+		}
+	} else {
+		return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: t or p")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+		return subsonicFailure(subsonicErrorAuth, "Wrong username or password")
+	}
+	return nil
+}
+
+func writeSubsonicResponse(c echo.Context, params url.Values, resp *subsonicResponse) error {
+	switch params.Get("f") {
+	case "json":
+		return c.JSON(http.StatusOK, map[string]interface{}{"subsonic-response": resp})
+	case "jsonp":
+		return c.JSONP(http.StatusOK, params.Get("callback"), map[string]interface{}{"subsonic-response": resp})
+	}
+	return c.XML(http.StatusOK, resp)
+}
+
+//subsonicHandler serves the subset of the Subsonic API which maps onto the room
+func (s *Server) subsonicHandler(c echo.Context) (err error) {
+	params, err := c.FormParams()
+	if err != nil {
+		params = c.QueryParams()
+	}
+	w := c.Response()
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, public, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	if resp := subsonicAuthenticate(s.subsonicUsername, s.subsonicPassword, params); resp != nil {
+		return writeSubsonicResponse(c, params, resp)
+	}
+	method, ok := subsonicMethods[strings.TrimSuffix(c.Param("method"), ".view")]
+	if !ok {
+		return writeSubsonicResponse(c, params, subsonicFailure(subsonicErrorNotFound, "Method not supported"))
+	}
+	if resp := method(s, c, params); resp != nil {
+		return writeSubsonicResponse(c, params, resp)
+	}
+	return nil
+}
+
+func subsonicPing(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	return newSubsonicResponse()
+}
+
+func subsonicGetLicense(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	resp := newSubsonicResponse()
+	resp.License = &subsonicLicense{Valid: true}
+	return resp
+}
+
+func subsonicSearch3(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	resp := newSubsonicResponse()
+	resp.SearchResult3 = &subsonicSearchResult{Songs: []subsonicSong{}}
+	query := strings.Trim(strings.TrimSpace(params.Get("query")), `"`)
+	if len(query) == 0 {
+		return resp
+	}
+	count, offset := subsonicDefaultSongs, 0
+	if v, err := strconv.Atoi(params.Get("songCount")); err == nil && v >= 0 {
+		count = v
+	}
+	if v, err := strconv.Atoi(params.Get("songOffset")); err == nil && v >= 0 {
+		offset = v
+	}
+	if count == 0 {
+		return resp
+	}
+	log.Printf("[MusicStream] Subsonic client queried: All sources: %s", query)
+	results, _ := s.federatedSearch(query)
+	for i := offset; i < len(results) && i < offset+count; i++ {
+		resp.SearchResult3.Songs = append(resp.SearchResult3.Songs, newSubsonicSong(results[i].Track))
+	}
+	return resp
+}
+
+//subsonicPlaylist returns the playing track, if any, followed by the queue.
+//current is the index of the playing track, or -1
+func (s *Server) subsonicPlaylist() (entries []common.TrackMetadata, current int) {
+	current = -1
+	entries = []common.TrackMetadata{}
+	if meta, ok := s.currentTrackMeta.Load().(common.TrackMetadata); ok && len(meta.PlayID) > 0 {
+		entries = append(entries, meta)
+		current = 0
+	}
+	for _, val := range s.cacheQueue.Values() {
+		entries = append(entries, val.(common.TrackMetadata))
+	}
+	return
+}
+
+//subsonicPosition returns the position in the playing track
+func (s *Server) subsonicPosition(current int) time.Duration {
+	startTime, ok := s.startTime.Load().(time.Time)
+	if current < 0 || !ok || startTime.IsZero() {
+		return 0
+	}
+	return time.Since(startTime)
+}
+
+func subsonicGetPlayQueue(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	entries, current := s.subsonicPlaylist()
+	resp := newSubsonicResponse()
+	resp.PlayQueue = &subsonicPlayQueue{
+		Username:  params.Get("u"),
+		Changed:   time.Now(),
+		ChangedBy: "MusicStream",
+		Entries:   make([]subsonicSong, len(entries)),
+	}
+	for i, entry := range entries {
+		resp.PlayQueue.Entries[i] = newSubsonicSong(entry)
+	}
+	if current >= 0 {
+		resp.PlayQueue.Current = entries[current].PlayID
+		resp.PlayQueue.Position = int64(s.subsonicPosition(current) / time.Millisecond)
+	}
+	return resp
+}
+
+func (s *Server) subsonicJukeboxStatus() (status subsonicJukeboxStatus, entries []common.TrackMetadata) {
+	entries, current := s.subsonicPlaylist()
+	return subsonicJukeboxStatus{
+		CurrentIndex: current,
+		Playing:      current >= 0,
+		Gain:         1,
+		Position:     int(s.subsonicPosition(current) / time.Second),
+	}, entries
+}
+
+//subsonicEnqueue enqueues the search results with playIDs
+func (s *Server) subsonicEnqueue(playIDs []string) *subsonicResponse {
+	for _, playID := range playIDs {
+		result, ok := s.searchResults.Take(playID)
+		if !ok {
+			return subsonicFailure(subsonicErrorNotFound, "Song not found: "+playID)
+		}
+		if resp := s.enqueueSearchResult(result.source, result.track); !resp.Success {
+			return subsonicFailure(subsonicErrorGeneric, resp.Reason)
+		}
+	}
+	return nil
+}
+
+//subsonicClear removes the queued entries
+func (s *Server) subsonicClear(entries []common.TrackMetadata) {
+	for _, entry := range entries {
+		removeTrack(s, wsMessage{Operation: opClientRemoveTrack, Query: entry.PlayID})
+	}
+}
+
+//subsonicRemove removes the entry at index of the jukebox playlist, the playing track is skipped
+func (s *Server) subsonicRemove(entries []common.TrackMetadata, current int, index int) *subsonicResponse {
+	if index < 0 || index >= len(entries) {
+		return subsonicFailure(subsonicErrorNotFound, "Index out of range")
+	}
+	var resp Response
+	if index == current {
+		resp = skip(s, wsMessage{Operation: opClientRequestSkip})
+	} else {
+		resp = removeTrack(s, wsMessage{Operation: opClientRemoveTrack, Query: entries[index].PlayID})
+	}
+	if !resp.Success {
+		return subsonicFailure(subsonicErrorGeneric, resp.Reason)
+	}
+	return nil
+}
+
+//subsonicJukeboxControl controls the room, the jukebox playlist is the playing track followed by the queue.
+//Playback can't be paused, shuffled or seeked
+func subsonicJukeboxControl(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	action := params.Get("action")
+	status, entries := s.subsonicJukeboxStatus()
+	var failure *subsonicResponse
+	switch action {
+	case "get":
+		resp := newSubsonicResponse()
+		resp.JukeboxPlaylist = &subsonicJukeboxPlaylist{
+			subsonicJukeboxStatus: status,
+			Entries:               make([]subsonicSong, len(entries)),
+		}
+		for i, entry := range entries {
+			resp.JukeboxPlaylist.Entries[i] = newSubsonicSong(entry)
+		}
+		return resp
+	case "status", "start", "setGain":
+	case "add":
+		failure = s.subsonicEnqueue(params["id"])
+	case "set":
+		s.subsonicClear(entries[status.CurrentIndex+1:])
+		failure = s.subsonicEnqueue(params["id"])
+	case "clear":
+		s.subsonicClear(entries[status.CurrentIndex+1:])
+	case "remove":
+		index, err := strconv.Atoi(params.Get("index"))
+		if err != nil {
+			return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: index")
+		}
+		failure = s.subsonicRemove(entries, status.CurrentIndex, index)
+	case "skip":
+		index, err := strconv.Atoi(params.Get("index"))
+		if err != nil {
+			return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: index")
+		}
+		if index <= status.CurrentIndex || index >= len(entries) {
+			return subsonicFailure(subsonicErrorGeneric, "Only skipping forward in the queue is supported")
+		}
+		s.subsonicClear(entries[status.CurrentIndex+1 : index])
+		if status.CurrentIndex >= 0 {
+			failure = s.subsonicRemove(entries, status.CurrentIndex, status.CurrentIndex)
+		}
+	case "":
+		return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: action")
+	default:
+		return subsonicFailure(subsonicErrorGeneric, "Unsupported action: "+action)
+	}
+	if failure != nil {
+		return failure
+	}
+	resp := newSubsonicResponse()
+	if action != "status" {
+		status, _ = s.subsonicJukeboxStatus()
+	}
+	resp.JukeboxStatus = &status
+	return resp
+}
+
+//subsonicFindTrack returns the queued track or the search result with playID
+func (s *Server) subsonicFindTrack(playID string) (track common.Track, source common.MusicSource, ok bool) {
+	for _, val := range s.playQueue.Values() {
+		if track = val.(common.Track); track.PlayID() == playID {
+			return track, nil, true
+		}
+	}
+	if result, ok := s.searchResults.Get(playID); ok {
+		return result.track, result.source, true
+	}
+	return nil, nil, false
+}
+
+//subsonicStream streams the room as MP3 if id is "room" or the playing track's ID,
+//otherwise it downloads a queued track or a search result
+func subsonicStream(s *Server, c echo.Context, params url.Values) *subsonicResponse {
+	playID := params.Get("id")
+	if len(playID) == 0 {
+		return subsonicFailure(subsonicErrorMissing, "Required parameter is missing: id")
+	}
+	if meta, ok := s.currentTrackMeta.Load().(common.TrackMetadata); playID == subsonicRoomStreamID || (ok && meta.PlayID == playID) {
+		_ = s.serveAudio(c, true)
+		return nil
+	}
+	track, source, ok := s.subsonicFindTrack(playID)
+	if !ok {
+		return subsonicFailure(subsonicErrorNotFound, "Song not found")
+	}
+	if source != nil {
+		if err := track.Populate(); err != nil {
+			log.Printf("[MusicStream] track.Populate() failed: %+v", err)
+			s.setSourceHealth(source, err)
+			return subsonicFailure(subsonicErrorGeneric, errSearchFailed.Error())
+		}
+	}
+	stream, err := track.Stream()
+	if err != nil {
+		log.Printf("[MusicStream] track.Stream() failed: %+v", err)
+		return subsonicFailure(subsonicErrorGeneric, "Failed to open the track's stream")
+	}
+	body := stream.Body()
+	defer body.Close()
+	if stream.Format() != common.FFmpegStream {
+		return subsonicFailure(subsonicErrorGeneric, "The track can't be downloaded")
+	}
+	contentType := "application/octet-stream"
+	w := c.Response()
+	if istream, ok := stream.(common.StreamWithInfo); ok {
+		info := istream.Info()
+		if v, ok := subsonicContentTypes[info.Codec]; ok {
+			contentType = v
+		} else if len(info.ContentType) > 0 {
+			contentType = info.ContentType
+		}
+		if info.ContentLength > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.ContentLength, 10))
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, body)
+	return nil
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/queue"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSubsonicAuthenticate(t *testing.T) {
+	tests := []struct {
+		params url.Values
+		code   int
+	}{
+		{url.Values{"u": {"alice"}, "t": {"26719a1196d2a940705a59634eb18eab"}, "s": {"c19b2d"}}, -1},
+		{url.Values{"u": {"alice"}, "t": {"26719A1196D2A940705A59634EB18EAB"}, "s": {"c19b2d"}}, -1},
+		{url.Values{"u": {"alice"}, "t": {"26719a1196d2a940705a59634eb18eab"}, "s": {"salt"}}, subsonicErrorAuth},
+		{url.Values{"u": {"alice"}, "p": {"sesame"}}, -1},
+		{url.Values{"u": {"alice"}, "p": {"enc:736573616d65"}}, -1},
+		{url.Values{"u": {"alice"}, "p": {"enc:zz"}}, subsonicErrorAuth},
+		{url.Values{"u": {"bob"}, "p": {"sesame"}}, subsonicErrorAuth},
+		{url.Values{"u": {"alice"}}, subsonicErrorMissing},
+		{url.Values{"p": {"sesame"}}, subsonicErrorMissing},
+	}
+	for i, test := range tests {
+		resp := subsonicAuthenticate("alice", "sesame", test.params)
+		if test.code < 0 && resp != nil {
+			t.Errorf("%d: unexpected error %+v", i, resp.Error)
+		} else if test.code >= 0 && (resp == nil || resp.Error.Code != test.code) {
+			t.Errorf("%d: got %+v, want error %d", i, resp, test.code)
+		}
+	}
+	if resp := subsonicAuthenticate("", "", url.Values{}); resp != nil {
+		t.Error("any credentials should be accepted without a password")
+	}
+}
+
+func TestSubsonicResponseXML(t *testing.T) {
+	resp := newSubsonicResponse()
+	resp.License = &subsonicLicense{Valid: true}
+	data, err := xml.Marshal(resp)
+	if err != nil {
+		t.Fatal("xml.Marshal: ", err)
+	}
+	body := string(data)
+	for _, want := range []string{`<subsonic-response xmlns="http://subsonic.org/restapi" status="ok" version="1.16.1"`, `<license valid="true">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%s does not contain %s", body, want)
+		}
+	}
+	if strings.Contains(body, "error") {
+		t.Errorf("%s contains an error", body)
+	}
+}
+
+func TestSubsonicSearch3(t *testing.T) {
+	s := &Server{
+		sources:          []common.MusicSource{&healthTestSource{name: "one"}},
+		subsonicPassword: "sesame",
+	}
+	s.initSourcesHealth()
+	e := echo.New()
+	e.Any("/rest/:method", s.subsonicHandler)
+	req := httptest.NewRequest("GET", "/rest/search3.view?u=alice&p=sesame&f=json&query=song", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	var result struct {
+		Response subsonicResponse `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", rec.Body.String(), err)
+	}
+	if result.Response.Status != "ok" || result.Response.SearchResult3 == nil || len(result.Response.SearchResult3.Songs) != 1 {
+		t.Fatalf("search3 = %s", rec.Body.String())
+	}
+	song := result.Response.SearchResult3.Songs[0]
+	if _, ok := s.searchResults.Get(song.ID); !ok {
+		t.Errorf("song %s can't be enqueued", song.ID)
+	}
+	req = httptest.NewRequest("GET", "/rest/search3?u=alice&p=wrong&query=song", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `code="40"`) {
+		t.Errorf("wrong password: %s", rec.Body.String())
+	}
+}
+
+//jukeboxTestTrack is an MP3 file containing its title
+type jukeboxTestTrack struct {
+	common.DefaultTrack
+	title     string
+	populated bool
+}
+
+func (track *jukeboxTestTrack) Title() string   { return track.title }
+func (track *jukeboxTestTrack) PlayID() string  { return "play-" + track.title }
+func (track *jukeboxTestTrack) Populate() error { track.populated = true; return nil }
+func (track *jukeboxTestTrack) Stream() (common.Stream, error) {
+	info := common.StreamInfo{Codec: common.CodecMP3, ContentLength: int64(len(track.title))}
+	return common.NewStream(ioutil.NopCloser(strings.NewReader(track.title)), info), nil
+}
+
+//newJukeboxTestServer returns a server playing "playing", with "one", "two" and "three" queued and "found" as a search result.
+//skipped is closed when the playing track is skipped
+func newJukeboxTestServer() (s *Server, found *jukeboxTestTrack, skipped <-chan struct{}) {
+	source := &healthTestSource{name: "source"}
+	s = &Server{sources: []common.MusicSource{source}, playQueue: queue.New(), cacheQueue: queue.New()}
+	s.initSourcesHealth()
+	s.playQueue.PushCallback = s.enqueueCallback
+	s.playQueue.PopCallback = s.dequeueCallback
+	ctx, cancel := context.WithCancel(context.Background())
+	s.streamContext, s.skipFunc = ctx, cancel
+	s.currentTrackMeta.Store(common.GetMetadata(&jukeboxTestTrack{title: "playing"}))
+	s.startTime.Store(time.Now().Add(-3 * time.Second))
+	for _, title := range []string{"one", "two", "three"} {
+		s.playQueue.Push(&jukeboxTestTrack{title: title})
+	}
+	found = &jukeboxTestTrack{title: "found"}
+	s.searchResults.Add(source, []common.Track{found})
+	return s, found, ctx.Done()
+}
+
+//jukeboxTitles returns the titles of the jukebox playlist
+func jukeboxTitles(s *Server) string {
+	var titles []string
+	entries, _ := s.subsonicPlaylist()
+	for _, entry := range entries {
+		titles = append(titles, entry.Title)
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestSubsonicJukeboxControl(t *testing.T) {
+	tests := []struct {
+		params  url.Values
+		titles  string
+		skipped bool
+		code    int
+	}{
+		{url.Values{"action": {"status"}}, "playing,one,two,three", false, -1},
+		{url.Values{"action": {"add"}, "id": {"play-found"}}, "playing,one,two,three,found", false, -1},
+		{url.Values{"action": {"add"}, "id": {"play-lost"}}, "playing,one,two,three", false, subsonicErrorNotFound},
+		{url.Values{"action": {"set"}, "id": {"play-found"}}, "playing,found", false, -1},
+		{url.Values{"action": {"clear"}}, "playing", false, -1},
+		{url.Values{"action": {"remove"}, "index": {"2"}}, "playing,one,three", false, -1},
+		{url.Values{"action": {"remove"}, "index": {"0"}}, "playing,one,two,three", true, -1},
+		{url.Values{"action": {"remove"}, "index": {"4"}}, "playing,one,two,three", false, subsonicErrorNotFound},
+		{url.Values{"action": {"remove"}}, "playing,one,two,three", false, subsonicErrorMissing},
+		{url.Values{"action": {"skip"}, "index": {"2"}}, "playing,two,three", true, -1},
+		{url.Values{"action": {"skip"}, "index": {"0"}}, "playing,one,two,three", false, subsonicErrorGeneric},
+		{url.Values{"action": {"shuffle"}}, "playing,one,two,three", false, subsonicErrorGeneric},
+		{url.Values{}, "playing,one,two,three", false, subsonicErrorMissing},
+	}
+	for _, test := range tests {
+		s, found, skipped := newJukeboxTestServer()
+		resp := subsonicJukeboxControl(s, nil, test.params)
+		if test.code >= 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("%v: error %+v, want %d", test.params, resp.Error, test.code)
+			}
+		} else if resp.Error != nil || resp.JukeboxStatus == nil || resp.JukeboxStatus.CurrentIndex != 0 || !resp.JukeboxStatus.Playing {
+			t.Errorf("%v: %+v, %+v", test.params, resp.Error, resp.JukeboxStatus)
+		}
+		if titles := jukeboxTitles(s); titles != test.titles {
+			t.Errorf("%v: playlist %s, want %s", test.params, titles, test.titles)
+		}
+		select {
+		case <-skipped:
+			if !test.skipped {
+				t.Errorf("%v: the playing track was skipped", test.params)
+			}
+		default:
+			if test.skipped {
+				t.Errorf("%v: the playing track was not skipped", test.params)
+			}
+		}
+		if strings.HasSuffix(test.titles, "found") && !found.populated {
+			t.Errorf("%v: the enqueued search result was not populated", test.params)
+		}
+	}
+	s, _, _ := newJukeboxTestServer()
+	resp := subsonicJukeboxControl(s, nil, url.Values{"action": {"get"}})
+	if resp.JukeboxPlaylist == nil || len(resp.JukeboxPlaylist.Entries) != 4 || resp.JukeboxPlaylist.Entries[1].ID != "play-one" || resp.JukeboxPlaylist.Position != 3 {
+		t.Errorf("get = %+v", resp.JukeboxPlaylist)
+	}
+}
+
+func TestSubsonicGetPlayQueue(t *testing.T) {
+	s, _, _ := newJukeboxTestServer()
+	resp := subsonicGetPlayQueue(s, nil, url.Values{"u": {"alice"}})
+	playQueue := resp.PlayQueue
+	if playQueue == nil || playQueue.Current != "play-playing" || playQueue.Username != "alice" || playQueue.Position < 3000 || len(playQueue.Entries) != 4 || playQueue.Entries[3].Title != "three" {
+		t.Errorf("getPlayQueue = %+v", playQueue)
+	}
+	s.currentTrackMeta.Store(common.TrackMetadata{})
+	if playQueue = subsonicGetPlayQueue(s, nil, url.Values{}).PlayQueue; len(playQueue.Current) > 0 || playQueue.Position != 0 || len(playQueue.Entries) != 3 {
+		t.Errorf("getPlayQueue without a playing track = %+v", playQueue)
+	}
+}
+
+func TestSubsonicStream(t *testing.T) {
+	s, found, _ := newJukeboxTestServer()
+	s.mp3Header = []byte("ID3")
+	e := echo.New()
+	e.Any("/rest/:method", s.subsonicHandler)
+	tests := []struct {
+		id, body, contentType string
+	}{
+		{"room", "ID3", "audio/mpeg"},
+		{"play-playing", "ID3", "audio/mpeg"},
+		{"play-two", "two", "audio/mpeg"},
+		{"play-found", "found", "audio/mpeg"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/rest/stream.view?id="+test.id, nil)
+		//the room is streamed until the client disconnects, a range request only gets its header
+		req.Header.Set("Range", "bytes=0-")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Body.String() != test.body || rec.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("stream %s = %q (%s), want %q (%s)", test.id, rec.Body.String(), rec.Header().Get("Content-Type"), test.body, test.contentType)
+		}
+	}
+	if !found.populated {
+		t.Error("the downloaded search result was not populated")
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/rest/download?id=play-lost", nil))
+	if !strings.Contains(rec.Body.String(), `code="70"`) {
+		t.Errorf("download of an unknown song: %s", rec.Body.String())
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
 )
 
 func (s *Server) preloadTrack(stream io.ReadCloser, streamContext context.Context) {
@@ -64,7 +65,7 @@ func (s *Server) processTrack() {
 	if s.playQueue.Empty() {
 		s.currentTrack = s.defaultTrack
 		s.updateStartPos(true)
-		s.setTrack(common.GetMetadata(s.currentTrack))
+		s.setTrack(common.GetMetadata(s.currentTrack), nil)
 	}
 	s.activityWg.Wait()
 	track = s.playQueue.Pop().(common.Track)
@@ -72,23 +73,29 @@ func (s *Server) processTrack() {
 	s.currentTrack = track
 	log.Printf("[MusicStream] Playing %v - %v\n", track.Title(), track.Artist())
 	trackDict := common.GetMetadata(track)
-	if ltrack, ok := track.(common.TrackWithLyrics); ok {
-		lyrics, err := ltrack.GetLyrics()
-		if err != nil {
-			log.Println("[MusicStream] track.GetLyrics: ERROR: ", err)
-		} else {
-			trackDict.Lyrics = lyrics
-		}
-	} else if s.mxmClient != nil {
-		lyrics, err := s.mxmClient.GetLyrics(track)
-		if err != nil {
-			log.Println("[MusixMatch] GetLyrics: ", err)
-		} else {
-			trackDict.Lyrics = lyrics
-		}
+	trackLyrics := newTrackLyrics(track)
+	for _, language := range s.requestedLanguages() {
+		s.fetchLyrics(trackLyrics, language)
 	}
-	stream, err := track.Stream()
+	streamContext, skipFunc := context.WithCancel(context.TODO())
+	//the track can be skipped while it's opened, which takes a while if it's retried
+	s.streamContext = streamContext
+	s.skipFunc = skipFunc
+	s.webSocketNotify(Response{
+		Operation: opTrackOpening,
+		Success:   true,
+		Data: map[string]interface{}{
+			"track": trackDict,
+		},
+	})
+	playing, rawStream, err := s.openTrackWithFallback(streamContext, track)
 	if err != nil {
+		skipped := streamContext.Err() != nil && errors.Cause(err) == context.Canceled
+		skipFunc()
+		if skipped {
+			log.Printf("[MusicStream] %v - %v was skipped while it was opened", trackDict.Title, trackDict.Artist)
+			return
+		}
 		data := Response{
 			Operation: opSetClientsTrack,
 			Success:   false,
@@ -99,29 +106,28 @@ func (s *Server) processTrack() {
 			Reason: fmt.Sprintf("Failed to play %v - %v", trackDict.Title, trackDict.Artist),
 		}
 		s.webSocketNotify(data)
-		log.Panicf("[MusicStream] track.Stream: ERROR: %+v", err)
+		log.Printf("[MusicStream] Failed to play %v - %v: %+v", trackDict.Title, trackDict.Artist, err)
+		return
 	}
-	rawStream, err := GetRawStream(stream)
-	if err != nil {
-		data := Response{
-			Operation: opSetClientsTrack,
-			Success:   false,
-			Data: map[string]interface{}{
-				"track":     trackDict,
-				"listeners": atomic.LoadInt32(&s.listenersCount),
-			},
-			Reason: fmt.Sprintf("Failed to play %v - %v", trackDict.Title, trackDict.Artist),
+	if playing != track {
+		track = playing
+		s.currentTrack = track
+		log.Printf("[MusicStream] Playing %v - %v from another source instead\n", track.Title(), track.Artist())
+		trackDict = common.GetMetadata(track)
+		trackLyrics = newTrackLyrics(track)
+		for _, language := range s.requestedLanguages() {
+			s.fetchLyrics(trackLyrics, language)
 		}
-		s.webSocketNotify(data)
-		log.Panicf("[MusicStream] GetRawStream: ERROR: %+v", err)
 	}
-	streamContext, skipFunc := context.WithCancel(context.TODO())
 	go s.preloadTrack(rawStream, streamContext)
 	time.Sleep(time.Until(s.lastStreamEnded))
-	s.startTime = time.Now()
-	s.setTrack(trackDict)
-	s.streamContext = streamContext
-	s.skipFunc = skipFunc
+	startTime := time.Now()
+	s.startTime.Store(startTime)
+	s.setTrack(trackDict, trackLyrics)
+	s.addHistory(trackDict)
 	s.lastStreamEnded = s.streamToClients(streamContext)
+	if ptrack, ok := track.(common.TrackWithProgress); ok {
+		ptrack.SetProgress(s.lastStreamEnded.Sub(startTime), streamContext.Err() == nil)
+	}
 	s.skipFunc()
 }
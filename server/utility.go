@@ -85,7 +85,7 @@ func (s *Server) inactivityMonitor() {
 			}
 			s.streamMux.Lock()
 			s.updateStartPos(true)
-			s.setTrack(common.GetMetadata(s.defaultTrack))
+			s.setTrack(common.GetMetadata(s.defaultTrack), nil)
 		}
 	}
 }
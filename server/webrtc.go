@@ -0,0 +1,166 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/opusencoder"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const defaultICEServer = "stun:stun.l.google.com:19302"
+
+//iceGatheringTimeout bounds how long an offer waits for ICE candidates, the candidates gathered by then are sent
+const iceGatheringTimeout = 5 * time.Second
+
+//rtcSession is a WebRTC peer connection that receives the Opus stream
+type rtcSession struct {
+	socket *webSocket
+	pc     *webrtc.PeerConnection
+	track  *webrtc.TrackLocalStaticSample
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *Server) newRTCSession(socket *webSocket) (session *rtcSession, err error) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: s.iceServers}},
+	})
+	if err != nil {
+		return
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: 48000,
+		Channels:  2,
+	}, "audio", "MusicStream")
+	if err != nil {
+		pc.Close()
+		return
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	session = &rtcSession{socket: socket, pc: pc, track: track, ctx: ctx, cancel: cancel}
+	started := int32(0)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			if atomic.CompareAndSwapInt32(&started, 0, 1) {
+				go s.rtcSend(session)
+			}
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			session.cancel()
+		}
+	})
+	return
+}
+
+//rtcSend writes the live Opus packets to the session's track until the session is closed
+func (s *Server) rtcSend(session *rtcSession) {
+	atomic.AddInt32(&s.listenersCount, 1)
+	s.newListenerC <- 1
+	go s.setListenerCount()
+	defer s.setListenerCount()
+	defer atomic.AddInt32(&s.listenersCount, -1)
+	defer s.removeRTCSession(session)
+	subscriber := s.opusBroadcast.Subscribe()
+	duration := time.Duration(opusencoder.FrameSize/48) * time.Millisecond
+	for {
+		Chunk, wait, err := subscriber.Next()
+		if err != nil {
+			log.Println("[WebRTC] disconnecting listener: ", err)
+			return
+		}
+		if Chunk == nil {
+			select {
+			case <-session.ctx.Done():
+				return
+			case <-wait:
+			}
+			continue
+		}
+		if err = session.track.WriteSample(media.Sample{Data: Chunk.buffer, Duration: duration}); err != nil {
+			log.Println("[WebRTC] WriteSample: ", err)
+			return
+		}
+	}
+}
+
+//Offer creates an SDP offer for the session, containing the ICE candidates gathered within iceGatheringTimeout
+func (session *rtcSession) Offer() (offer webrtc.SessionDescription, err error) {
+	offer, err = session.pc.CreateOffer(nil)
+	if err != nil {
+		return
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(session.pc)
+	if err = session.pc.SetLocalDescription(offer); err != nil {
+		return
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(iceGatheringTimeout):
+		log.Println("[WebRTC] ICE gathering timed out, offering the candidates gathered so far")
+	}
+	return *session.pc.LocalDescription(), nil
+}
+
+//Answer applies the client's SDP answer to the session
+func (session *rtcSession) Answer(sdp string) error {
+	return session.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  sdp,
+	})
+}
+
+func (session *rtcSession) Close() error {
+	session.cancel()
+	return session.pc.Close()
+}
+
+//removeRTCSession closes the session and forgets it, unless its socket has requested another session since
+func (s *Server) removeRTCSession(session *rtcSession) {
+	if current, ok := s.rtcSessions.Load(session.socket); ok && current == session {
+		s.rtcSessions.Delete(session.socket)
+	}
+	session.Close()
+}
+
+func (s *Server) closeRTCSession(socket *webSocket) {
+	if session, ok := s.rtcSessions.Load(socket); ok {
+		s.rtcSessions.Delete(socket)
+		session.(*rtcSession).Close()
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestWebRTCSignalling(t *testing.T) {
+	s := &Server{iceServers: []string{}}
+	if resp := requestWebRTC(s, wsMessage{}); resp.Success {
+		t.Error("WebRTC should only be available over WebSocket")
+	}
+	socket := &webSocket{}
+	if resp := answerWebRTC(s, wsMessage{socket: socket, Query: "v=0"}); resp.Success {
+		t.Error("answer without an offer should fail")
+	}
+	resp := requestWebRTC(s, wsMessage{socket: socket})
+	if !resp.Success {
+		t.Fatal("requestWebRTC: ", resp.Reason)
+	}
+	offer := resp.Data["sdp"].(webrtc.SessionDescription)
+	if offer.Type != webrtc.SDPTypeOffer {
+		t.Errorf("sdp type = %v", offer.Type)
+	}
+	peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal("NewPeerConnection: ", err)
+	}
+	defer peer.Close()
+	if err = peer.SetRemoteDescription(offer); err != nil {
+		t.Fatal("SetRemoteDescription: ", err)
+	}
+	answer, err := peer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal("CreateAnswer: ", err)
+	}
+	if resp := answerWebRTC(s, wsMessage{socket: socket, Query: answer.SDP}); !resp.Success {
+		t.Error("answerWebRTC: ", resp.Reason)
+	}
+	if resp := stopWebRTC(s, wsMessage{socket: socket}); !resp.Success {
+		t.Error("stopWebRTC: ", resp.Reason)
+	}
+	if resp := stopWebRTC(s, wsMessage{socket: socket}); resp.Success {
+		t.Error("the session should be closed")
+	}
+}
+
+func TestWebRTCInvalidAnswer(t *testing.T) {
+	s := &Server{iceServers: []string{}}
+	socket := &webSocket{}
+	if resp := requestWebRTC(s, wsMessage{socket: socket}); !resp.Success {
+		t.Fatal("requestWebRTC: ", resp.Reason)
+	}
+	if resp := answerWebRTC(s, wsMessage{socket: socket, Query: "invalid"}); resp.Success {
+		t.Error("an invalid answer should be rejected")
+	}
+	if _, ok := s.rtcSessions.Load(socket); ok {
+		t.Error("the session should be closed after an invalid answer")
+	}
+}
+
+func TestRemoveRTCSession(t *testing.T) {
+	s := &Server{iceServers: []string{}}
+	socket := &webSocket{}
+	if resp := requestWebRTC(s, wsMessage{socket: socket}); !resp.Success {
+		t.Fatal("requestWebRTC: ", resp.Reason)
+	}
+	old, _ := s.rtcSessions.Load(socket)
+	if resp := requestWebRTC(s, wsMessage{socket: socket}); !resp.Success {
+		t.Fatal("requestWebRTC: ", resp.Reason)
+	}
+	s.removeRTCSession(old.(*rtcSession))
+	session, ok := s.rtcSessions.Load(socket)
+	if !ok || session == old {
+		t.Fatal("removing an old session should keep the socket's new session")
+	}
+	s.removeRTCSession(session.(*rtcSession))
+	if _, ok := s.rtcSessions.Load(socket); ok {
+		t.Error("the session should be forgotten after it's removed")
+	}
+}
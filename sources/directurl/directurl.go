@@ -0,0 +1,393 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package directurl is a music source which plays audio files from http(s) and file URLs
+package directurl
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/streamdecoder"
+	"github.com/pkg/errors"
+)
+
+var Name string = "URL"
+var DisplayName string = "URL"
+
+func init() {
+	common.RegisterSource(Name, NewClient)
+}
+
+//probeTimeout limits the time spent reading the tags of a URL
+const probeTimeout = 30 * time.Second
+
+//audioExtensions are the extensions of the files recognized by MatchURL
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".wav":  true,
+	".m4a":  true,
+	".aac":  true,
+	".webm": true,
+	".mka":  true,
+}
+
+var errFileNotAllowed = errors.New("file is not in any of the allowed directories")
+
+//Track is an audio file at a URL
+type Track struct {
+	url           string
+	path          string
+	title         string
+	artist        string
+	album         string
+	isrc          string
+	duration      int
+	contentType   string
+	contentLength int64
+	playID        string
+	client        *Client
+}
+
+//ID returns the track's URL
+func (track *Track) ID() string {
+	return track.url
+}
+
+func (track *Track) IsRadio() bool {
+	return false
+}
+
+//Title returns the track's title tag, or its file name
+func (track *Track) Title() string {
+	return track.title
+}
+
+//Artist returns the track's artist tag
+func (track *Track) Artist() string {
+	return track.artist
+}
+
+//Artists returns the track's artist tag
+func (track *Track) Artists() string {
+	return track.artist
+}
+
+//Album returns the track's album tag
+func (track *Track) Album() string {
+	return track.album
+}
+
+//ISRC returns the track's ISRC tag
+func (track *Track) ISRC() string {
+	return track.isrc
+}
+
+//Href returns the track's URL
+func (track *Track) Href() string {
+	return track.url
+}
+
+func (track *Track) CoverURL() string {
+	return ""
+}
+
+//Duration returns the track's duration in seconds, 0 if unknown
+func (track *Track) Duration() int {
+	return track.duration
+}
+
+func (track *Track) SpotifyURI() string {
+	return ""
+}
+
+//PlayID returns a random string which is unique to this instance of Track
+func (track *Track) PlayID() string {
+	return track.playID
+}
+
+//Source returns the name of the track's source
+func (track *Track) Source() string {
+	return Name
+}
+
+//Populate does nothing as the track's metadata is read when it's found
+func (track *Track) Populate() error {
+	return nil
+}
+
+//Stream returns the body of the track's URL
+func (track *Track) Stream() (common.Stream, error) {
+	return track.StreamFrom(0)
+}
+
+//StreamFrom returns the body of the track's URL, starting at offset bytes
+func (track *Track) StreamFrom(offset int64) (common.Stream, error) {
+	info := common.StreamInfo{ContentType: track.contentType}
+	if len(track.path) > 0 {
+		f, err := os.Open(track.path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, errors.WithStack(err)
+		}
+		info.Seekable = offset == 0
+		if offset == 0 {
+			info.ContentLength = track.contentLength
+		}
+		return common.NewStream(f, info), nil
+	}
+	resp, err := common.GetRange(context.Background(), track.client.httpClient, track.url, offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.ContentType) == 0 {
+		info.ContentType = resp.Header.Get("Content-Type")
+	}
+	if resp.ContentLength > 0 && offset == 0 {
+		info.ContentLength = resp.ContentLength
+	}
+	return common.NewStream(resp.Body, info), nil
+}
+
+//setMetadata fills the track's metadata from the tags of its file, name is used if it has no title
+func (track *Track) setMetadata(metadata streamdecoder.Metadata, name string) {
+	track.title = metadata.Title
+	if len(track.title) == 0 {
+		track.title = strings.TrimSuffix(name, path.Ext(name))
+	}
+	track.artist = metadata.Artist
+	track.album = metadata.Album
+	track.isrc = metadata.Tags["isrc"]
+	track.duration = int(metadata.Duration / time.Second)
+}
+
+//Client finds audio files from their URLs
+type Client struct {
+	httpClient *http.Client
+	//roots are the directories which file URLs may point into
+	roots []string
+}
+
+//MatchURL reports whether rawURL is a file URL, or an http(s) URL of an audio file
+func (client *Client) MatchURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "file":
+		return true
+	case "http", "https":
+		return audioExtensions[strings.ToLower(path.Ext(u.Path))]
+	}
+	return false
+}
+
+//Search returns the audio file at query, which is an http(s) or file URL.
+//Other queries have no results
+func (client *Client) Search(query string) (tracks []common.Track, err error) {
+	query = strings.TrimSpace(query)
+	if !client.MatchURL(query) {
+		return nil, nil
+	}
+	u, err := url.Parse(query)
+	if err != nil {
+		return nil, nil
+	}
+	var track *Track
+	if u.Scheme == "file" {
+		track, err = client.probeFile(u)
+	} else {
+		track, err = client.probeHTTP(u)
+	}
+	if err != nil {
+		//the URL is the user's, it failing doesn't mean that the source isn't working
+		return nil, &common.InvalidQueryError{Reason: err.Error()}
+	}
+	return []common.Track{track}, nil
+}
+
+//probeHTTP checks that u is an audio file with a HEAD request, then reads its tags
+func (client *Client) probeHTTP(u *url.URL) (*Track, error) {
+	track := &Track{url: u.String(), playID: common.GenerateID(), client: client}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, track.url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		track.contentType = resp.Header.Get("Content-Type")
+		if resp.ContentLength > 0 {
+			track.contentLength = resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		//the server doesn't support HEAD, the file is checked when probing its body
+	default:
+		return nil, errors.Errorf("%s: %s", track.url, resp.Status)
+	}
+	if strings.HasPrefix(track.contentType, "text/") {
+		return nil, errors.Errorf("%s is not an audio file (%s)", track.url, track.contentType)
+	}
+	if resp, err = common.GetRange(ctx, client.httpClient, track.url, 0); err != nil {
+		return nil, err
+	}
+	if len(track.contentType) == 0 {
+		track.contentType = resp.Header.Get("Content-Type")
+	}
+	metadata, err := streamdecoder.Probe(resp.Body, streamdecoder.Options{
+		ContentType:   track.contentType,
+		ContentLength: track.contentLength,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not an audio file", track.url)
+	}
+	track.setMetadata(metadata, path.Base(u.Path))
+	return track, nil
+}
+
+//probeFile reads the tags of the file at u, which must be in one of the client's roots
+func (client *Client) probeFile(u *url.URL) (*Track, error) {
+	if len(u.Host) > 0 && u.Host != "localhost" {
+		return nil, errors.Errorf("%s is not a local file", u.String())
+	}
+	filePath, err := client.allowedPath(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, errors.Errorf("%s is a directory", filePath)
+	}
+	track := &Track{
+		url:           u.String(),
+		path:          filePath,
+		contentType:   mime.TypeByExtension(filepath.Ext(filePath)),
+		contentLength: stat.Size(),
+		playID:        common.GenerateID(),
+		client:        client,
+	}
+	metadata, err := streamdecoder.Probe(f, streamdecoder.Options{
+		ContentType:   track.contentType,
+		ContentLength: track.contentLength,
+		Seekable:      true,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not an audio file", filePath)
+	}
+	track.setMetadata(metadata, filepath.Base(filePath))
+	return track, nil
+}
+
+//allowedPath resolves the symbolic links of filePath and checks that it's in one of the client's roots
+func (client *Client) allowedPath(filePath string) (string, error) {
+	if !filepath.IsAbs(filePath) {
+		return "", errFileNotAllowed
+	}
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, root := range client.roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", errFileNotAllowed
+}
+
+//CheckHealth always succeeds as the source doesn't depend on any service
+func (client *Client) CheckHealth() error {
+	return nil
+}
+
+func (client *Client) Name() string {
+	return Name
+}
+func (client *Client) DisplayName() string {
+	return DisplayName
+}
+
+//ConfigSchema describes the client's options
+func (client *Client) ConfigSchema() []common.ConfigOption {
+	return []common.ConfigOption{
+		{
+			Name:        "file_roots",
+			Description: "Directories which file URLs may point into, file URLs are rejected if it's empty",
+			Type:        common.ConfigStrings,
+			Env:         "URL_FILE_ROOTS",
+		},
+	}
+}
+
+//Configure sets the directories which file URLs may point into
+func (client *Client) Configure(config map[string]interface{}) error {
+	roots, _ := config["file_roots"].([]string)
+	client.roots = nil
+	for _, root := range roots {
+		if len(root) == 0 {
+			continue
+		}
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return errors.WithStack(err)
+		}
+		client.roots = append(client.roots, root)
+	}
+	return nil
+}
+
+//NewClient returns a new Client, which rejects file URLs until it's configured with their directories
+func NewClient() (common.MusicSource, error) {
+	return &Client{httpClient: &http.Client{}}, nil
+}
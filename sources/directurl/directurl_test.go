@@ -0,0 +1,138 @@
+package directurl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/common/sourcetest"
+)
+
+func makeWAV(sampleRate int, samples []int16) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+2*len(samples)))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(1), uint16(1), uint32(sampleRate),
+		uint32(sampleRate * 2), uint16(2), uint16(16),
+	} {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(2*len(samples)))
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func TestMatchURL(t *testing.T) {
+	client := sourcetest.NewClient(t, NewClient, nil).(*Client)
+	cases := map[string]bool{
+		"https://nas.local/music/Song.MP3":            true,
+		"http://nas.local/music/song.flac?download=1": true,
+		"file:///music/song.ogg":                      true,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ": false,
+		"song.mp3": false,
+	}
+	for rawURL, expected := range cases {
+		if matched := client.MatchURL(rawURL); matched != expected {
+			t.Errorf("MatchURL(%q) = %v, want %v", rawURL, matched, expected)
+		}
+	}
+}
+
+func TestSearchHTTP(t *testing.T) {
+	wav := makeWAV(8000, make([]int16, 8000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/music/Some Song.wav":
+			w.Header().Set("Content-Type", "audio/wav")
+			http.ServeContent(w, r, "Some Song.wav", time.Time{}, bytes.NewReader(wav))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, nil).(*Client)
+	tracks, err := client.Search(server.URL + "/music/Some%20Song.wav")
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("Search() = %v, %v", tracks, err)
+	}
+	track := tracks[0].(*Track)
+	if track.Title() != "Some Song" {
+		t.Errorf("Title() = %q, want %q", track.Title(), "Some Song")
+	}
+	stream, err := track.Stream()
+	if err != nil {
+		t.Fatal("Stream: ", err)
+	}
+	info := stream.(common.StreamWithInfo).Info()
+	if stream.Format() != common.FFmpegStream || info.ContentType != "audio/wav" || info.ContentLength != int64(len(wav)) {
+		t.Errorf("stream = %d, %+v", stream.Format(), info)
+	}
+	body, _ := ioutil.ReadAll(stream.Body())
+	stream.Body().Close()
+	if !bytes.Equal(body, wav) {
+		t.Error("Stream() returned a different body")
+	}
+	if stream, err = track.StreamFrom(40); err != nil {
+		t.Fatal("StreamFrom: ", err)
+	}
+	body, _ = ioutil.ReadAll(stream.Body())
+	stream.Body().Close()
+	if !bytes.Equal(body, wav[40:]) {
+		t.Error("StreamFrom() returned a different body")
+	}
+	if tracks, err = client.Search(server.URL + "/page.mp3"); !common.IsInvalidQuery(err) {
+		t.Errorf("Search(HTML page) = %v, %v, want an InvalidQueryError", tracks, err)
+	}
+	if tracks, err = client.Search(server.URL + "/index.html"); err != nil || len(tracks) != 0 {
+		t.Errorf("Search(non-audio URL) = %v, %v, want no results", tracks, err)
+	}
+	if tracks, err = client.Search("some song"); err != nil || len(tracks) != 0 {
+		t.Errorf("Search(text) = %v, %v, want no results", tracks, err)
+	}
+}
+
+func TestSearchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directurl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "music")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(root, "song.wav")
+	outside := filepath.Join(dir, "secret.wav")
+	for _, name := range []string{inside, outside} {
+		if err = ioutil.WriteFile(name, makeWAV(8000, make([]int16, 16000)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := sourcetest.NewClient(t, NewClient, map[string]interface{}{"file_roots": []string{root}}).(*Client)
+	fileURL := "file://" + filepath.ToSlash(inside)
+	tracks, err := client.Search(fileURL)
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("Search(%q) = %v, %v", fileURL, tracks, err)
+	}
+	if title := tracks[0].Title(); title != "song" {
+		t.Errorf("Title() = %q, want %q", title, "song")
+	}
+	for _, name := range []string{outside, filepath.Join(root, "..", "secret.wav")} {
+		fileURL = "file://" + filepath.ToSlash(name)
+		if _, err = client.Search(fileURL); err == nil || !strings.Contains(err.Error(), "allowed") {
+			t.Errorf("Search(%q) = %v, want errFileNotAllowed", fileURL, err)
+		}
+	}
+}
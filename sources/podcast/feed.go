@@ -0,0 +1,268 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package podcast
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//feed is a podcast feed, parsed from RSS or Atom
+type feed struct {
+	url      string
+	title    string
+	author   string
+	cover    string
+	episodes []*episode
+	fetched  time.Time
+}
+
+//episode is an item of a feed which has an audio enclosure
+type episode struct {
+	id          string
+	title       string
+	author      string
+	link        string
+	cover       string
+	audioURL    string
+	contentType string
+	length      int64
+	//duration is in seconds, 0 if unknown
+	duration    int
+	chapters    []chapter
+	chaptersURL string
+}
+
+//chapter is a chapter mark of an episode, start is in seconds
+type chapter struct {
+	start float64
+	title string
+}
+
+type rssImage struct {
+	Href string `xml:"href,attr"`
+}
+
+//rssChannelImage is either the channel's itunes:image or its RSS image
+type rssChannelImage struct {
+	Href string `xml:"href,attr"`
+	URL  string `xml:"url"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type pscChapters struct {
+	Chapters []struct {
+		Start string `xml:"start,attr"`
+		Title string `xml:"title,attr"`
+	} `xml:"chapter"`
+}
+
+type podcastChapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string          `xml:"title"`
+	GUID        string          `xml:"guid"`
+	Link        string          `xml:"link"`
+	Enclosure   rssEnclosure    `xml:"enclosure"`
+	Duration    string          `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Author      string          `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	Image       rssImage        `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Chapters    pscChapters     `xml:"http://podlove.org/simple-chapters chapters"`
+	ChaptersURL podcastChapters `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+}
+
+type rssFeed struct {
+	Channel struct {
+		Title  string            `xml:"title"`
+		Author string            `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		Images []rssChannelImage `xml:"image"`
+		Items  []rssItem         `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomEntry struct {
+	Title  string     `xml:"title"`
+	ID     string     `xml:"id"`
+	Links  []atomLink `xml:"link"`
+	Author string     `xml:"author>name"`
+	Image  rssImage   `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+}
+
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Author  string      `xml:"author>name"`
+	Logo    string      `xml:"logo"`
+	Icon    string      `xml:"icon"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+//parseFeed parses an RSS 2.0 or Atom feed, items without an audio enclosure are skipped
+func parseFeed(r io.Reader, feedURL string) (*feed, error) {
+	data, err := readAll(r, maxFeedSize)
+	if err != nil {
+		return nil, err
+	}
+	var root struct {
+		XMLName xml.Name
+	}
+	if err = xml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "invalid feed")
+	}
+	f := &feed{url: feedURL, fetched: time.Now()}
+	switch root.XMLName.Local {
+	case "rss":
+		var rss rssFeed
+		if err = xml.Unmarshal(data, &rss); err != nil {
+			return nil, errors.Wrap(err, "invalid RSS feed")
+		}
+		channel := rss.Channel
+		f.title, f.author = strings.TrimSpace(channel.Title), strings.TrimSpace(channel.Author)
+		for _, image := range channel.Images {
+			if len(image.Href) > 0 {
+				f.cover = image.Href
+				break
+			}
+			if len(f.cover) == 0 {
+				f.cover = strings.TrimSpace(image.URL)
+			}
+		}
+		for _, item := range channel.Items {
+			if len(item.Enclosure.URL) == 0 {
+				continue
+			}
+			e := &episode{
+				id:          strings.TrimSpace(item.GUID),
+				title:       strings.TrimSpace(item.Title),
+				author:      strings.TrimSpace(item.Author),
+				link:        strings.TrimSpace(item.Link),
+				cover:       item.Image.Href,
+				audioURL:    item.Enclosure.URL,
+				contentType: item.Enclosure.Type,
+				duration:    int(parseTime(item.Duration)),
+				chaptersURL: item.ChaptersURL.URL,
+			}
+			e.length, _ = strconv.ParseInt(strings.TrimSpace(item.Enclosure.Length), 10, 64)
+			for _, c := range item.Chapters.Chapters {
+				e.chapters = append(e.chapters, chapter{start: parseTime(c.Start), title: c.Title})
+			}
+			f.episodes = append(f.episodes, e)
+		}
+	case "feed":
+		var atom atomFeed
+		if err = xml.Unmarshal(data, &atom); err != nil {
+			return nil, errors.Wrap(err, "invalid Atom feed")
+		}
+		f.title, f.author, f.cover = strings.TrimSpace(atom.Title), strings.TrimSpace(atom.Author), atom.Logo
+		if len(f.cover) == 0 {
+			f.cover = atom.Icon
+		}
+		for _, entry := range atom.Entries {
+			e := &episode{
+				id:     strings.TrimSpace(entry.ID),
+				title:  strings.TrimSpace(entry.Title),
+				author: strings.TrimSpace(entry.Author),
+				cover:  entry.Image.Href,
+			}
+			for _, link := range entry.Links {
+				switch link.Rel {
+				case "enclosure":
+					if len(e.audioURL) == 0 {
+						e.audioURL, e.contentType = link.Href, link.Type
+						e.length, _ = strconv.ParseInt(strings.TrimSpace(link.Length), 10, 64)
+					}
+				case "", "alternate":
+					e.link = link.Href
+				}
+			}
+			if len(e.audioURL) > 0 {
+				f.episodes = append(f.episodes, e)
+			}
+		}
+	default:
+		return nil, errors.Errorf("%s is not a RSS or Atom feed", feedURL)
+	}
+	for _, e := range f.episodes {
+		if len(e.id) == 0 {
+			e.id = e.audioURL
+		}
+		if len(e.author) == 0 {
+			e.author = f.author
+		}
+		if len(e.cover) == 0 {
+			e.cover = f.cover
+		}
+	}
+	return f, nil
+}
+
+//parseTime parses a duration or timestamp in seconds, such as 3600, 59:30 or 01:02:03.500
+func parseTime(s string) (seconds float64) {
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + v
+	}
+	return
+}
+
+//jsonChapters is the JSON chapters format of the podcast namespace
+type jsonChapters struct {
+	Chapters []struct {
+		StartTime float64 `json:"startTime"`
+		Title     string  `json:"title"`
+		TOC       *bool   `json:"toc"`
+	} `json:"chapters"`
+}
+
+func parseJSONChapters(r io.Reader) (chapters []chapter, err error) {
+	var parsed jsonChapters
+	if err = json.NewDecoder(r).Decode(&parsed); err != nil {
+		return nil, errors.Wrap(err, "invalid chapters")
+	}
+	for _, c := range parsed.Chapters {
+		if c.TOC != nil && !*c.TOC {
+			continue
+		}
+		chapters = append(chapters, chapter{start: c.StartTime, title: c.Title})
+	}
+	return
+}
@@ -0,0 +1,435 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package podcast is a music source which plays the episodes of podcast feeds
+package podcast
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+var Name string = "Podcast"
+var DisplayName string = "Podcast"
+
+func init() {
+	common.RegisterSource(Name, NewClient)
+}
+
+const (
+	maxFeedSize = 16 << 20
+	//feedTTL is how long a fetched feed is used before it's fetched again
+	feedTTL      = 15 * time.Minute
+	fetchTimeout = 30 * time.Second
+	maxResults   = 50
+	//resumeMinimum is how far an episode must have been played to be resumed
+	resumeMinimum = 30 * time.Second
+	//resumeRewind is how far before the position where an episode was stopped it's resumed
+	resumeRewind = 5 * time.Second
+	//playedMargin is the remaining time of an episode which was stopped under which it's considered played
+	playedMargin = 30 * time.Second
+)
+
+//Track is an episode of a podcast
+type Track struct {
+	episode *episode
+	feed    *feed
+	playID  string
+	client  *Client
+	mux     sync.Mutex
+	//resumeAt is where the episode starts playing, if it was stopped before
+	resumeAt time.Duration
+}
+
+//ID returns the feed's URL and the episode's GUID, separated by a space
+func (track *Track) ID() string {
+	return track.feed.url + " " + track.episode.id
+}
+
+func (track *Track) IsRadio() bool {
+	return false
+}
+
+//Title returns the episode's title
+func (track *Track) Title() string {
+	return track.episode.title
+}
+
+//Artist returns the episode's author, or the podcast's
+func (track *Track) Artist() string {
+	return track.episode.author
+}
+
+//Artists returns the episode's author, or the podcast's
+func (track *Track) Artists() string {
+	return track.episode.author
+}
+
+//Album returns the podcast's title
+func (track *Track) Album() string {
+	return track.feed.title
+}
+
+func (track *Track) ISRC() string {
+	return ""
+}
+
+//Href returns the episode's web page, or its audio file
+func (track *Track) Href() string {
+	if len(track.episode.link) > 0 {
+		return track.episode.link
+	}
+	return track.episode.audioURL
+}
+
+//CoverURL returns the episode's artwork, or the podcast's
+func (track *Track) CoverURL() string {
+	return track.episode.cover
+}
+
+//Duration returns the remaining duration of the episode in seconds, 0 if unknown
+func (track *Track) Duration() int {
+	if track.episode.duration == 0 {
+		return 0
+	}
+	return track.episode.duration - int(track.getResumeAt()/time.Second)
+}
+
+func (track *Track) SpotifyURI() string {
+	return ""
+}
+
+//PlayID returns a random string which is unique to this instance of Track
+func (track *Track) PlayID() string {
+	return track.playID
+}
+
+//Source returns the name of the track's source
+func (track *Track) Source() string {
+	return Name
+}
+
+//Populate does nothing as the episode's metadata comes from its feed
+func (track *Track) Populate() error {
+	return nil
+}
+
+func (track *Track) getResumeAt() time.Duration {
+	track.mux.Lock()
+	defer track.mux.Unlock()
+	return track.resumeAt
+}
+
+//resumeOffset returns the byte offset of the episode's audio file where it's resumed, estimated from its length and duration
+func (track *Track) resumeOffset() int64 {
+	resumeAt := track.getResumeAt()
+	if resumeAt <= 0 {
+		return 0
+	}
+	return track.episode.length * int64(resumeAt/time.Millisecond) / (int64(track.episode.duration) * 1000)
+}
+
+//Stream returns the episode's audio, from where it was stopped if it was not played to the end
+func (track *Track) Stream() (common.Stream, error) {
+	return track.StreamFrom(0)
+}
+
+//StreamFrom returns the episode's audio, starting at offset bytes after where it's resumed
+func (track *Track) StreamFrom(offset int64) (common.Stream, error) {
+	start := track.resumeOffset()
+	resp, err := common.GetRange(context.Background(), track.client.httpClient, track.episode.audioURL, start+offset)
+	if err != nil && start > 0 && offset == 0 {
+		log.Printf("[Podcast] Cannot resume %s, playing it from the beginning: %v", track.episode.title, err)
+		track.mux.Lock()
+		track.resumeAt = 0
+		track.mux.Unlock()
+		start = 0
+		resp, err = common.GetRange(context.Background(), track.client.httpClient, track.episode.audioURL, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := common.StreamInfo{ContentType: track.episode.contentType}
+	if len(info.ContentType) == 0 {
+		info.ContentType = resp.Header.Get("Content-Type")
+	}
+	if resp.ContentLength > 0 && start+offset == 0 {
+		info.ContentLength = resp.ContentLength
+	}
+	return common.NewStream(resp.Body, info), nil
+}
+
+//GetLyrics returns the episode's chapters as synced lyrics
+func (track *Track) GetLyrics() (result common.LyricsResult, err error) {
+	chapters := append([]chapter(nil), track.episode.chapters...)
+	if len(chapters) == 0 && len(track.episode.chaptersURL) > 0 {
+		if chapters, err = track.client.fetchChapters(track.episode.chaptersURL); err != nil {
+			return
+		}
+	}
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].start < chapters[j].start
+	})
+	resumeAt := track.getResumeAt().Seconds()
+	var raw []string
+	for i, c := range chapters {
+		if i+1 < len(chapters) && chapters[i+1].start <= resumeAt {
+			//the chapter ended before the episode was resumed
+			continue
+		}
+		start := c.start - resumeAt
+		if start < 0 {
+			start = 0
+		}
+		result.SyncedLyrics = append(result.SyncedLyrics, common.LyricsLine{Text: c.title, Time: common.NewLyricsTime(start)})
+		raw = append(raw, c.title)
+	}
+	result.RawLyrics = strings.Join(raw, "\n")
+	return
+}
+
+//SetProgress remembers where the episode was stopped, or that it was played
+func (track *Track) SetProgress(played time.Duration, finished bool) {
+	position := track.getResumeAt() + played
+	state := episodeState{Position: position.Seconds(), Updated: time.Now()}
+	if finished || (track.episode.duration > 0 && position >= time.Duration(track.episode.duration)*time.Second-playedMargin) {
+		state.Played = true
+		state.Position = 0
+	}
+	if err := track.client.state.set(track.feed.url, track.episode.id, state); err != nil {
+		log.Printf("[Podcast] Cannot save the state of %s: %+v", track.episode.title, err)
+	}
+}
+
+//canResume reports whether the episode can be started from the middle of its audio file
+func (e *episode) canResume() bool {
+	if e.length <= 0 || e.duration <= 0 {
+		return false
+	}
+	if e.contentType == "audio/mpeg" || e.contentType == "audio/mp3" {
+		return true
+	}
+	u, err := url.Parse(e.audioURL)
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".mp3")
+}
+
+//Client finds the episodes of podcast feeds
+type Client struct {
+	httpClient *http.Client
+	//feeds are the URLs of the feeds searched by queries which are not a feed URL
+	feeds    []string
+	state    *stateStore
+	cacheMux sync.Mutex
+	cache    map[string]*feed
+}
+
+//isFeedURL reports whether s is an http(s) URL
+func isFeedURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+//matchTitle reports whether title contains all words, which are lowercased
+func matchTitle(title string, words []string) bool {
+	title = strings.ToLower(title)
+	for _, word := range words {
+		if !strings.Contains(title, word) {
+			return false
+		}
+	}
+	return true
+}
+
+//Search returns the episodes whose title contains the query's words.
+//If the query starts with a feed URL, the episodes of that feed are searched for the rest of the query,
+//there are no results if it's not a feed. Otherwise the configured feeds are searched
+func (client *Client) Search(query string) (tracks []common.Track, err error) {
+	query = strings.TrimSpace(query)
+	feedURLs := client.feeds
+	if fields := strings.Fields(query); len(fields) > 0 && isFeedURL(fields[0]) {
+		feedURLs = fields[:1]
+		query = strings.TrimSpace(strings.TrimPrefix(query, fields[0]))
+		if _, err = client.feed(fields[0]); err != nil {
+			//any URL is searched on all sources, most of them are not feeds
+			log.Printf("[Podcast] %s is not a feed: %v", fields[0], err)
+			return nil, nil
+		}
+	}
+	words := strings.Fields(strings.ToLower(query))
+	for _, feedURL := range feedURLs {
+		f, err := client.feed(feedURL)
+		if err != nil {
+			if len(feedURLs) == 1 {
+				return nil, err
+			}
+			log.Printf("[Podcast] Cannot fetch %s: %v", feedURL, err)
+			continue
+		}
+		for _, e := range f.episodes {
+			if !matchTitle(e.title, words) {
+				continue
+			}
+			tracks = append(tracks, client.newTrack(f, e))
+			if len(tracks) >= maxResults {
+				return tracks, nil
+			}
+		}
+	}
+	return tracks, nil
+}
+
+//GetTrack returns the episode of the provided ID, as returned by Track.ID
+func (client *Client) GetTrack(id string) (common.Track, error) {
+	i := strings.IndexByte(id, ' ')
+	if i < 0 {
+		return nil, errors.Errorf("invalid episode ID: %q", id)
+	}
+	f, err := client.feed(id[:i])
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range f.episodes {
+		if e.id == id[i+1:] {
+			return client.newTrack(f, e), nil
+		}
+	}
+	return nil, errors.Errorf("episode %q not found in %s", id[i+1:], id[:i])
+}
+
+//newTrack returns a track of the episode, which is resumed if it was stopped before its end
+func (client *Client) newTrack(f *feed, e *episode) *Track {
+	track := &Track{episode: e, feed: f, playID: common.GenerateID(), client: client}
+	if state, ok := client.state.get(f.url, e.id); ok && !state.Played {
+		position := time.Duration(state.Position * float64(time.Second))
+		if position >= resumeMinimum && e.canResume() {
+			track.resumeAt = position - resumeRewind
+		}
+	}
+	return track
+}
+
+//feed returns the feed at feedURL, which is fetched again if it's older than feedTTL
+func (client *Client) feed(feedURL string) (*feed, error) {
+	client.cacheMux.Lock()
+	f, ok := client.cache[feedURL]
+	client.cacheMux.Unlock()
+	if ok && time.Since(f.fetched) < feedTTL {
+		return f, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := common.GetRange(ctx, client.httpClient, feedURL, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if f, err = parseFeed(resp.Body, feedURL); err != nil {
+		return nil, err
+	}
+	client.cacheMux.Lock()
+	client.cache[feedURL] = f
+	client.cacheMux.Unlock()
+	return f, nil
+}
+
+func (client *Client) fetchChapters(chaptersURL string) ([]chapter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := common.GetRange(ctx, client.httpClient, chaptersURL, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return parseJSONChapters(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+//readAll reads r, which must not be larger than limit bytes
+func readAll(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New("feed is too large")
+	}
+	return data, nil
+}
+
+func (client *Client) Name() string {
+	return Name
+}
+func (client *Client) DisplayName() string {
+	return DisplayName
+}
+
+//ConfigSchema describes the client's options
+func (client *Client) ConfigSchema() []common.ConfigOption {
+	return []common.ConfigOption{
+		{
+			Name:        "feeds",
+			Description: "URLs of the RSS/Atom feeds searched by queries which are not a feed URL",
+			Type:        common.ConfigStrings,
+			Env:         "PODCAST_FEEDS",
+		},
+		{
+			Name:        "state_file",
+			Description: "JSON file where the played episodes are remembered, they're only kept in memory if it's empty",
+			Type:        common.ConfigString,
+			Env:         "PODCAST_STATE_FILE",
+		},
+	}
+}
+
+//Configure sets the client's feeds and loads the played episodes
+func (client *Client) Configure(config map[string]interface{}) error {
+	feeds, _ := config["feeds"].([]string)
+	client.feeds = nil
+	for _, feedURL := range feeds {
+		if feedURL = strings.TrimSpace(feedURL); len(feedURL) == 0 {
+			continue
+		}
+		if !isFeedURL(feedURL) {
+			return errors.Errorf("invalid feed URL: %q", feedURL)
+		}
+		client.feeds = append(client.feeds, feedURL)
+	}
+	statePath, _ := config["state_file"].(string)
+	state, err := newStateStore(statePath)
+	if err != nil {
+		return err
+	}
+	client.state = state
+	return nil
+}
+
+//NewClient returns a new Client, which remembers the played episodes in memory until it's configured
+func NewClient() (common.MusicSource, error) {
+	state, _ := newStateStore("")
+	return &Client{httpClient: &http.Client{}, state: state, cache: make(map[string]*feed)}, nil
+}
@@ -0,0 +1,183 @@
+package podcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common/sourcetest"
+)
+
+//newFeedServer serves the files in testdata, with the fixtures' host replaced by the server's, and an episode's audio
+func newFeedServer(t *testing.T, audio []byte) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/episode3.mp3" {
+			http.ServeContent(w, r, "episode3.mp3", time.Time{}, bytes.NewReader(audio))
+			return
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", filepath.Base(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(bytes.ReplaceAll(data, []byte("http://podcast.test"), []byte(server.URL)))
+	}))
+	return server
+}
+
+func TestParseTime(t *testing.T) {
+	cases := map[string]float64{
+		"1830":         1830,
+		"25:00":        1500,
+		"01:00:00":     3600,
+		"00:10:30.500": 630.5,
+		"":             0,
+		"soon":         0,
+	}
+	for s, expected := range cases {
+		if seconds := parseTime(s); seconds != expected {
+			t.Errorf("parseTime(%q) = %v, want %v", s, seconds, expected)
+		}
+	}
+}
+
+func TestSearchRSS(t *testing.T) {
+	server := newFeedServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, nil).(*Client)
+	tracks, err := client.Search(server.URL + "/rss.xml")
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("Search() = %v, %v, want 2 episodes", tracks, err)
+	}
+	first, second := tracks[0].(*Track), tracks[1].(*Track)
+	if first.Title() != "Episode 3: The Third One" || first.Artist() != "Test Host" || first.Album() != "The Test Show" {
+		t.Errorf("first episode = %q by %q in %q", first.Title(), first.Artist(), first.Album())
+	}
+	if first.Duration() != 3600 || first.CoverURL() != server.URL+"/episode3.jpg" || first.Href() != server.URL+"/episodes/3" {
+		t.Errorf("first episode = %ds, cover %q, href %q", first.Duration(), first.CoverURL(), first.Href())
+	}
+	if second.Artist() != "Guest Host" || second.CoverURL() != server.URL+"/show.jpg" || second.Href() != server.URL+"/episode2.mp3" {
+		t.Errorf("second episode = by %q, cover %q, href %q", second.Artist(), second.CoverURL(), second.Href())
+	}
+	if tracks, err = client.Search(server.URL + "/rss.xml  second ONE"); err != nil || len(tracks) != 1 || tracks[0].Title() != "Episode 2: The Second One" {
+		t.Errorf("Search(filtered) = %v, %v, want the second episode", tracks, err)
+	}
+	track, err := client.GetTrack(second.ID())
+	if err != nil || track.Title() != second.Title() {
+		t.Errorf("GetTrack(%q) = %v, %v", second.ID(), track, err)
+	}
+	if tracks, err = client.Search(server.URL + "/missing.xml"); err != nil || len(tracks) != 0 {
+		t.Errorf("Search(missing feed) = %v, %v, want no results", tracks, err)
+	}
+	if tracks, err = client.Search(server.URL + "/episode3.mp3"); err != nil || len(tracks) != 0 {
+		t.Errorf("Search(URL which is not a feed) = %v, %v, want no results", tracks, err)
+	}
+}
+
+func TestSearchConfiguredFeeds(t *testing.T) {
+	server := newFeedServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, map[string]interface{}{
+		"feeds": []string{server.URL + "/rss.xml", server.URL + "/atom.xml", server.URL + "/missing.xml"},
+	}).(*Client)
+	tracks, err := client.Search("episode")
+	if err != nil || len(tracks) != 3 {
+		t.Fatalf("Search() = %v, %v, want 3 episodes", tracks, err)
+	}
+	atom := tracks[2].(*Track)
+	if atom.Title() != "First Atom Episode" || atom.Artist() != "Atom Host" || atom.Album() != "Atom Show" || atom.CoverURL() != server.URL+"/atom.png" {
+		t.Errorf("Atom episode = %q by %q in %q, cover %q", atom.Title(), atom.Artist(), atom.Album(), atom.CoverURL())
+	}
+	if atom.episode.audioURL != server.URL+"/atom1.ogg" || atom.episode.length != 5000 || atom.Href() != server.URL+"/atom/1" {
+		t.Errorf("Atom episode = %+v", atom.episode)
+	}
+	if tracks, err = client.Search("atom"); err != nil || len(tracks) != 1 {
+		t.Errorf("Search(atom) = %v, %v, want 1 episode", tracks, err)
+	}
+}
+
+func TestChapters(t *testing.T) {
+	server := newFeedServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, nil).(*Client)
+	tracks, err := client.Search(server.URL + "/rss.xml")
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("Search() = %v, %v", tracks, err)
+	}
+	expected := [][]string{{"Cold open", "Main topic"}, {"Intro", "Interview", "Outro"}}
+	starts := [][]float64{{0, 120}, {0, 630.5, 1500}}
+	for i, track := range tracks {
+		lyrics, err := track.(*Track).GetLyrics()
+		if err != nil {
+			t.Fatal("GetLyrics: ", err)
+		}
+		if len(lyrics.SyncedLyrics) != len(expected[i]) {
+			t.Fatalf("GetLyrics() = %+v, want %v", lyrics.SyncedLyrics, expected[i])
+		}
+		for j, line := range lyrics.SyncedLyrics {
+			if line.Text != expected[i][j] || line.Time.Total != starts[i][j] {
+				t.Errorf("chapter %d = %q at %v, want %q at %v", j, line.Text, line.Time.Total, expected[i][j], starts[i][j])
+			}
+		}
+		if lyrics.RawLyrics != strings.Join(expected[i], "\n") {
+			t.Errorf("RawLyrics = %q", lyrics.RawLyrics)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	audio := make([]byte, 1200000)
+	for i := range audio {
+		audio[i] = byte(i / 1000)
+	}
+	server := newFeedServer(t, audio)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "podcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+	client := sourcetest.NewClient(t, NewClient, map[string]interface{}{"state_file": statePath}).(*Client)
+	search := func() *Track {
+		tracks, err := client.Search(server.URL + "/rss.xml third")
+		if err != nil || len(tracks) != 1 {
+			t.Fatalf("Search() = %v, %v", tracks, err)
+		}
+		return tracks[0].(*Track)
+	}
+	track := search()
+	track.SetProgress(10*time.Minute+5*time.Second, false)
+	client = sourcetest.NewClient(t, NewClient, map[string]interface{}{"state_file": statePath}).(*Client)
+	track = search()
+	if track.getResumeAt() != 10*time.Minute || track.Duration() != 3000 {
+		t.Fatalf("resumeAt = %v, Duration() = %d, want 10m0s and 3000", track.getResumeAt(), track.Duration())
+	}
+	stream, err := track.Stream()
+	if err != nil {
+		t.Fatal("Stream: ", err)
+	}
+	body, _ := ioutil.ReadAll(stream.Body())
+	stream.Body().Close()
+	if !bytes.Equal(body, audio[200000:]) {
+		t.Errorf("resumed stream has %d bytes, want the last %d", len(body), len(audio)-200000)
+	}
+	lyrics, _ := track.GetLyrics()
+	if len(lyrics.SyncedLyrics) != 1 || lyrics.SyncedLyrics[0].Text != "Main topic" || lyrics.SyncedLyrics[0].Time.Total != 0 {
+		t.Errorf("chapters after resuming = %+v, want Main topic at 0", lyrics.SyncedLyrics)
+	}
+	track.SetProgress(49*time.Minute+45*time.Second, false)
+	if track = search(); track.getResumeAt() != 0 {
+		t.Errorf("resumeAt = %v after playing the episode to its end, want 0", track.getResumeAt())
+	}
+	if state, _ := client.state.get(server.URL+"/rss.xml", "episode-3"); !state.Played {
+		t.Errorf("state = %+v, want played", state)
+	}
+}
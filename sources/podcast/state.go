@@ -0,0 +1,104 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package podcast
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//episodeState is how far an episode was played
+type episodeState struct {
+	//Position is the time where the episode was stopped, in seconds
+	Position float64   `json:"position"`
+	Played   bool      `json:"played"`
+	Updated  time.Time `json:"updated"`
+}
+
+//stateStore remembers the state of the played episodes of each feed.
+//If path is not empty, it's persisted to a JSON file there, which is rewritten on every change
+type stateStore struct {
+	mux   sync.Mutex
+	path  string
+	feeds map[string]map[string]episodeState
+}
+
+//newStateStore returns a stateStore backed by the file at path, loading it if it exists
+func newStateStore(path string) (*stateStore, error) {
+	store := &stateStore{path: path, feeds: make(map[string]map[string]episodeState)}
+	if len(path) == 0 {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = json.Unmarshal(data, &store.feeds); err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return store, nil
+}
+
+func (store *stateStore) get(feedURL, episodeID string) (state episodeState, ok bool) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	state, ok = store.feeds[feedURL][episodeID]
+	return
+}
+
+//set stores the state of an episode and writes the store to disk
+func (store *stateStore) set(feedURL, episodeID string, state episodeState) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	episodes, ok := store.feeds[feedURL]
+	if !ok {
+		episodes = make(map[string]episodeState)
+		store.feeds[feedURL] = episodes
+	}
+	episodes[episodeID] = state
+	if len(store.path) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(store.feeds)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), store.path))
+}
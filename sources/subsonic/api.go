@@ -0,0 +1,164 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package subsonic
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	apiVersion = "1.16.1"
+	clientName = "MusicStream"
+)
+
+//apiError is an error returned by the Subsonic API
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *apiError) Error() string {
+	return fmt.Sprintf("Subsonic: error %d: %s", err.Code, err.Message)
+}
+
+//errorUnsupported is the error code of methods which the server does not implement
+const errorUnsupported = 30
+
+type song struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Album       string `json:"album"`
+	Artist      string `json:"artist"`
+	CoverArt    string `json:"coverArt"`
+	Duration    int    `json:"duration"`
+	ContentType string `json:"contentType"`
+	Suffix      string `json:"suffix"`
+	Size        int64  `json:"size"`
+	//Artists and ISRC are OpenSubsonic extensions
+	Artists []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	ISRC []string `json:"isrc"`
+}
+
+type lyricsLine struct {
+	//Start is in milliseconds
+	Start *int64 `json:"start"`
+	Value string `json:"value"`
+}
+
+type structuredLyrics struct {
+	Lang   string `json:"lang"`
+	Synced bool   `json:"synced"`
+	//Offset is in milliseconds
+	Offset int64        `json:"offset"`
+	Line   []lyricsLine `json:"line"`
+}
+
+//response is the content of the subsonic-response object
+type response struct {
+	Status        string    `json:"status"`
+	Version       string    `json:"version"`
+	Error         *apiError `json:"error"`
+	SearchResult3 struct {
+		Song []song `json:"song"`
+	} `json:"searchResult3"`
+	Song   *song `json:"song"`
+	Lyrics struct {
+		Artist string `json:"artist"`
+		Title  string `json:"title"`
+		Value  string `json:"value"`
+	} `json:"lyrics"`
+	LyricsList struct {
+		StructuredLyrics []structuredLyrics `json:"structuredLyrics"`
+	} `json:"lyricsList"`
+}
+
+//authParams returns the query parameters which authenticate a request with a salted token
+func (client *Client) authParams() url.Values {
+	salt := make([]byte, 8)
+	_, _ = rand.Read(salt)
+	params := url.Values{}
+	params.Set("u", client.username)
+	params.Set("s", hex.EncodeToString(salt))
+	token := md5.Sum([]byte(client.password + params.Get("s")))
+	params.Set("t", hex.EncodeToString(token[:]))
+	params.Set("v", apiVersion)
+	params.Set("c", clientName)
+	return params
+}
+
+//endpoint returns the authenticated URL of an API method
+func (client *Client) endpoint(method string, params url.Values) string {
+	query := client.authParams()
+	for key, values := range params {
+		query[key] = values
+	}
+	return client.baseURL + "/rest/" + method + "?" + query.Encode()
+}
+
+//call calls an API method and returns its response, or its error
+func (client *Client) call(ctx context.Context, method string, params url.Values) (*response, error) {
+	if len(client.baseURL) == 0 {
+		return nil, errors.New("Subsonic: the server is not configured")
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("f", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.endpoint(method, params), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(common.RedactURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented {
+		return nil, &apiError{Code: errorUnsupported, Message: method + " is not supported"}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Subsonic: %s: %s", method, resp.Status)
+	}
+	var body struct {
+		Response response `json:"subsonic-response"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrapf(err, "Subsonic: %s", method)
+	}
+	if body.Response.Error != nil {
+		return nil, body.Response.Error
+	}
+	if !strings.EqualFold(body.Response.Status, "ok") {
+		return nil, errors.Errorf("Subsonic: %s: status %q", method, body.Response.Status)
+	}
+	return &body.Response, nil
+}
@@ -0,0 +1,360 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package subsonic is a music source which plays songs from a Subsonic/OpenSubsonic server, e.g. Navidrome
+package subsonic
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/pkg/errors"
+)
+
+var Name string = "Subsonic"
+var DisplayName string = "Subsonic"
+
+func init() {
+	common.RegisterSource(Name, NewClient)
+}
+
+const (
+	searchResults = 20
+	apiTimeout    = 30 * time.Second
+	coverSize     = 600
+)
+
+//Track is a song on the Subsonic server
+type Track struct {
+	song
+	playID string
+	client *Client
+}
+
+//ID returns the song's ID on the server
+func (track *Track) ID() string {
+	return track.song.ID
+}
+
+func (track *Track) IsRadio() bool {
+	return false
+}
+
+//Title returns the song's title
+func (track *Track) Title() string {
+	return track.song.Title
+}
+
+//Artist returns the song's main artist
+func (track *Track) Artist() string {
+	if len(track.song.Artists) > 0 {
+		return track.song.Artists[0].Name
+	}
+	return track.song.Artist
+}
+
+//Artists returns the song's artists' name, comma-separated
+func (track *Track) Artists() string {
+	if len(track.song.Artists) == 0 {
+		return track.song.Artist
+	}
+	names := make([]string, len(track.song.Artists))
+	for i, artist := range track.song.Artists {
+		names[i] = artist.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+//Album returns the song's album title
+func (track *Track) Album() string {
+	return track.song.Album
+}
+
+//ISRC returns the song's ISRC ID, if the server knows it
+func (track *Track) ISRC() string {
+	if len(track.song.ISRC) > 0 {
+		return track.song.ISRC[0]
+	}
+	return ""
+}
+
+func (track *Track) Href() string {
+	return ""
+}
+
+//CoverURL returns the path at which MusicStream serves the song's cover art, the server's URL is authenticated
+func (track *Track) CoverURL() string {
+	if len(track.song.CoverArt) == 0 {
+		return ""
+	}
+	return common.CoverArtURL(Name, track.song.CoverArt)
+}
+
+//Duration returns the song's duration in seconds
+func (track *Track) Duration() int {
+	return track.song.Duration
+}
+
+func (track *Track) SpotifyURI() string {
+	return ""
+}
+
+//PlayID returns a random string which is unique to this instance of Track
+func (track *Track) PlayID() string {
+	return track.playID
+}
+
+//Source returns the name of the track's source
+func (track *Track) Source() string {
+	return Name
+}
+
+//Populate does nothing as the song's metadata comes with its search result
+func (track *Track) Populate() error {
+	return nil
+}
+
+//Stream returns the song's original file
+func (track *Track) Stream() (common.Stream, error) {
+	return track.StreamFrom(0)
+}
+
+//StreamFrom returns the song's original file, starting at offset bytes
+func (track *Track) StreamFrom(offset int64) (common.Stream, error) {
+	resp, err := common.GetRange(context.Background(), track.client.httpClient, track.client.endpoint("stream", url.Values{
+		"id":     {track.song.ID},
+		"format": {"raw"},
+	}), offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "Subsonic: stream")
+	}
+	info := common.StreamInfo{ContentType: resp.Header.Get("Content-Type")}
+	if strings.HasPrefix(info.ContentType, "application/json") || strings.HasSuffix(strings.SplitN(info.ContentType, ";", 2)[0], "/xml") {
+		//errors are returned as a subsonic-response instead of the file
+		resp.Body.Close()
+		return nil, errors.Errorf("Subsonic: cannot stream %s", track.song.ID)
+	}
+	if len(info.ContentType) == 0 {
+		info.ContentType = track.song.ContentType
+	}
+	if offset == 0 && resp.ContentLength > 0 {
+		info.ContentLength = resp.ContentLength
+	}
+	return common.NewStream(resp.Body, info), nil
+}
+
+//GetLyrics returns the song's lyrics from the server, synced if it supports OpenSubsonic's structured lyrics
+func (track *Track) GetLyrics() (result common.LyricsResult, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	resp, err := track.client.call(ctx, "getLyricsBySongId", url.Values{"id": {track.song.ID}})
+	if err == nil {
+		if lyrics := pickLyrics(resp.LyricsList.StructuredLyrics); lyrics != nil {
+			return convertLyrics(*lyrics), nil
+		}
+		return
+	}
+	if _, ok := err.(*apiError); !ok {
+		return
+	}
+	resp, err = track.client.call(ctx, "getLyrics", url.Values{
+		"artist": {track.Artist()},
+		"title":  {track.Title()},
+	})
+	if err != nil {
+		return
+	}
+	result.RawLyrics = strings.TrimSpace(resp.Lyrics.Value)
+	return
+}
+
+//pickLyrics returns the best lyrics of a song, synced ones are preferred
+func pickLyrics(lyrics []structuredLyrics) *structuredLyrics {
+	var best *structuredLyrics
+	for i := range lyrics {
+		if len(lyrics[i].Line) == 0 {
+			continue
+		}
+		if best == nil || (lyrics[i].Synced && !best.Synced) {
+			best = &lyrics[i]
+		}
+	}
+	return best
+}
+
+func convertLyrics(lyrics structuredLyrics) (result common.LyricsResult) {
+	raw := make([]string, len(lyrics.Line))
+	for i, line := range lyrics.Line {
+		raw[i] = line.Value
+	}
+	result.RawLyrics = strings.Join(raw, "\n")
+	if lyrics.Lang != "xxx" && lyrics.Lang != "und" {
+		result.Language = lyrics.Lang
+	}
+	if !lyrics.Synced {
+		return
+	}
+	for _, line := range lyrics.Line {
+		if line.Start == nil {
+			continue
+		}
+		//a positive offset shows the lyrics earlier
+		start := float64(*line.Start-lyrics.Offset) / 1000
+		if start < 0 {
+			start = 0
+		}
+		result.SyncedLyrics = append(result.SyncedLyrics, common.LyricsLine{Text: line.Value, Time: common.NewLyricsTime(start)})
+	}
+	sort.SliceStable(result.SyncedLyrics, func(i, j int) bool {
+		return result.SyncedLyrics[i].Time.Total < result.SyncedLyrics[j].Time.Total
+	})
+	return
+}
+
+//Client talks to a Subsonic server
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	username   string
+	password   string
+}
+
+func (client *Client) newTrack(s song) *Track {
+	return &Track{song: s, playID: common.GenerateID(), client: client}
+}
+
+//Search takes a query string and returns a slice of matching songs
+func (client *Client) Search(query string) (tracks []common.Track, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	resp, err := client.call(ctx, "search3", url.Values{
+		"query":       {query},
+		"songCount":   {strconv.Itoa(searchResults)},
+		"artistCount": {"0"},
+		"albumCount":  {"0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range resp.SearchResult3.Song {
+		tracks = append(tracks, client.newTrack(s))
+	}
+	return
+}
+
+//GetTrack returns the song of the provided ID
+func (client *Client) GetTrack(id string) (common.Track, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	resp, err := client.call(ctx, "getSong", url.Values{"id": {id}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Song == nil {
+		return nil, errors.Errorf("Subsonic: song %s not found", id)
+	}
+	return client.newTrack(*resp.Song), nil
+}
+
+//GetCoverArt returns the cover art id from the server
+func (client *Client) GetCoverArt(id string) (body io.ReadCloser, contentType string, err error) {
+	if len(client.baseURL) == 0 {
+		return nil, "", errors.New("Subsonic: the server is not configured")
+	}
+	resp, err := common.GetRange(context.Background(), client.httpClient, client.endpoint("getCoverArt", url.Values{
+		"id":   {id},
+		"size": {strconv.Itoa(coverSize)},
+	}), 0)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Subsonic: getCoverArt")
+	}
+	contentType = resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		//errors are returned as a subsonic-response instead of the image
+		resp.Body.Close()
+		return nil, "", errors.Errorf("Subsonic: cannot get cover art %s", id)
+	}
+	return resp.Body, contentType, nil
+}
+
+//CheckHealth pings the server, which also checks the credentials
+func (client *Client) CheckHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	_, err := client.call(ctx, "ping", nil)
+	return err
+}
+
+func (client *Client) Name() string {
+	return Name
+}
+func (client *Client) DisplayName() string {
+	return DisplayName
+}
+
+//ConfigSchema describes the client's options
+func (client *Client) ConfigSchema() []common.ConfigOption {
+	return []common.ConfigOption{
+		{
+			Name:        "url",
+			Description: "URL of the Subsonic server, e.g. https://music.example.com",
+			Type:        common.ConfigString,
+			Required:    true,
+			Env:         "SUBSONIC_URL",
+		},
+		{
+			Name:        "username",
+			Description: "Username on the Subsonic server",
+			Type:        common.ConfigString,
+			Required:    true,
+			Env:         "SUBSONIC_USERNAME",
+		},
+		{
+			Name:        "password",
+			Description: "Password on the Subsonic server, only sent as salted tokens",
+			Type:        common.ConfigString,
+			Required:    true,
+			Env:         "SUBSONIC_PASSWORD",
+		},
+	}
+}
+
+//Configure sets the server's URL and credentials
+func (client *Client) Configure(config map[string]interface{}) error {
+	baseURL := strings.TrimRight(config["url"].(string), "/")
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.Errorf("invalid Subsonic server URL: %q", baseURL)
+	}
+	client.baseURL = strings.TrimSuffix(baseURL, "/rest")
+	client.username = config["username"].(string)
+	client.password = config["password"].(string)
+	return nil
+}
+
+//NewClient returns a new Client, which must be configured with its server
+func NewClient() (common.MusicSource, error) {
+	return &Client{httpClient: &http.Client{}}, nil
+}
@@ -0,0 +1,191 @@
+package subsonic
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNguyen1909/MusicStream/common"
+	"github.com/TrungNguyen1909/MusicStream/common/sourcetest"
+)
+
+const (
+	testUsername = "listener"
+	testPassword = "sesame"
+)
+
+//newStandInServer replays the responses recorded in testdata, unless the request is not authenticated with a valid token.
+//Methods in unsupported return 404, as on servers without OpenSubsonic extensions
+func newStandInServer(t *testing.T, audio []byte, unsupported ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		token := md5.Sum([]byte(testPassword + query.Get("s")))
+		method := strings.TrimPrefix(r.URL.Path, "/rest/")
+		if query.Get("u") != testUsername || query.Get("t") != hex.EncodeToString(token[:]) || len(query.Get("s")) == 0 || len(query.Get("p")) > 0 {
+			method = "error"
+		}
+		for _, m := range unsupported {
+			if method == m {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		if method == "stream" {
+			http.ServeContent(w, r, query.Get("id")+".mp3", time.Time{}, bytes.NewReader(audio))
+			return
+		}
+		if method == "getCoverArt" {
+			http.ServeContent(w, r, query.Get("id")+".jpg", time.Time{}, bytes.NewReader(audio))
+			return
+		}
+		if method != "error" && query.Get("f") != "json" {
+			t.Errorf("%s was requested without f=json", method)
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", method+".json"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+}
+
+//testConfig is the configuration of a client of the server at serverURL
+func testConfig(serverURL, password string) map[string]interface{} {
+	return map[string]interface{}{"url": serverURL + "/", "username": testUsername, "password": password}
+}
+
+func TestSearch(t *testing.T) {
+	server := newStandInServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, testConfig(server.URL, testPassword)).(*Client)
+	if err := client.CheckHealth(); err != nil {
+		t.Error("CheckHealth: ", err)
+	}
+	tracks, err := client.Search("blue")
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("Search() = %v, %v, want 2 songs", tracks, err)
+	}
+	track := tracks[0]
+	if track.ID() != "8a3c1f" || track.Title() != "Blue Skies" || track.Album() != "Weather Report" || track.Duration() != 215 {
+		t.Errorf("track = %+v", common.GetMetadata(track))
+	}
+	if track.Artist() != "The Forecasters" || track.Artists() != "The Forecasters, Rain" || track.ISRC() != "USABC1900001" {
+		t.Errorf("track = %q, %q, %q", track.Artist(), track.Artists(), track.ISRC())
+	}
+	if tracks[1].Artist() != "Night Band" || tracks[1].Artists() != "Night Band" || tracks[1].CoverURL() != "" {
+		t.Errorf("track without OpenSubsonic fields = %+v", common.GetMetadata(tracks[1]))
+	}
+	cover, err := url.Parse(track.CoverURL())
+	if err != nil || cover.Path != "/cover/Subsonic" || cover.Query().Get("id") != "al-a91b" || len(cover.Query().Get("t")) > 0 {
+		t.Fatalf("CoverURL() = %q", track.CoverURL())
+	}
+	body, contentType, err := client.GetCoverArt(cover.Query().Get("id"))
+	if err != nil || contentType != "image/jpeg" {
+		t.Errorf("GetCoverArt() = %q, %v", contentType, err)
+	} else {
+		body.Close()
+	}
+	if track, err = client.GetTrack("77f0e2"); err != nil || track.Title() != "Blue Moon" {
+		t.Errorf("GetTrack() = %v, %v", track, err)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	server := newStandInServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, testConfig(server.URL, "wrong")).(*Client)
+	_, err := client.Search("blue")
+	if apiErr, ok := err.(*apiError); !ok || apiErr.Code != 40 {
+		t.Errorf("Search() error = %v, want error 40", err)
+	}
+	if err = client.CheckHealth(); err == nil {
+		t.Error("CheckHealth() should fail with a wrong password")
+	}
+}
+
+func TestUnreachableServer(t *testing.T) {
+	server := newStandInServer(t, nil)
+	client := sourcetest.NewClient(t, NewClient, testConfig(server.URL, testPassword)).(*Client)
+	server.Close()
+	_, err := client.Search("blue")
+	if err == nil || strings.Contains(err.Error(), "t=") {
+		t.Errorf("Search() error = %v, want an error without the token", err)
+	}
+	if err = client.CheckHealth(); err == nil || strings.Contains(fmt.Sprintf("%+v", err), "t=") {
+		t.Errorf("CheckHealth() error = %v, want an error without the token", err)
+	}
+	if _, err = client.newTrack(song{ID: "77f0e2"}).Stream(); err == nil || strings.Contains(err.Error(), "t=") {
+		t.Errorf("Stream() error = %v, want an error without the token", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	audio := make([]byte, 100000)
+	for i := range audio {
+		audio[i] = byte(i)
+	}
+	server := newStandInServer(t, audio)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, testConfig(server.URL, testPassword)).(*Client)
+	track := client.newTrack(song{ID: "8a3c1f", ContentType: "audio/mpeg"})
+	stream, err := track.Stream()
+	if err != nil {
+		t.Fatal("Stream: ", err)
+	}
+	info := stream.(common.StreamWithInfo).Info()
+	body, _ := ioutil.ReadAll(stream.Body())
+	stream.Body().Close()
+	if !bytes.Equal(body, audio) || info.ContentLength != int64(len(audio)) || info.ContentType != "audio/mpeg" {
+		t.Errorf("Stream() = %d bytes, %+v", len(body), info)
+	}
+	if stream, err = track.StreamFrom(1000); err != nil {
+		t.Fatal("StreamFrom: ", err)
+	}
+	body, _ = ioutil.ReadAll(stream.Body())
+	stream.Body().Close()
+	if !bytes.Equal(body, audio[1000:]) {
+		t.Errorf("StreamFrom() = %d bytes, want %d", len(body), len(audio)-1000)
+	}
+	client = sourcetest.NewClient(t, NewClient, testConfig(server.URL, "wrong")).(*Client)
+	if _, err = client.newTrack(song{ID: "8a3c1f"}).Stream(); err == nil {
+		t.Error("Stream() should fail with a wrong password")
+	}
+}
+
+func TestLyrics(t *testing.T) {
+	server := newStandInServer(t, nil)
+	defer server.Close()
+	client := sourcetest.NewClient(t, NewClient, testConfig(server.URL, testPassword)).(*Client)
+	track := client.newTrack(song{ID: "8a3c1f", Title: "Blue Skies", Artist: "The Forecasters"})
+	lyrics, err := track.GetLyrics()
+	if err != nil {
+		t.Fatal("GetLyrics: ", err)
+	}
+	if len(lyrics.SyncedLyrics) != 2 || lyrics.Language != "eng" {
+		t.Fatalf("GetLyrics() = %+v, want 2 synced lines in eng", lyrics)
+	}
+	if line := lyrics.SyncedLyrics[0]; line.Text != "Blue skies" || line.Time.Total != 1 {
+		t.Errorf("first line = %q at %v, want %q at 1", line.Text, line.Time.Total, "Blue skies")
+	}
+	if line := lyrics.SyncedLyrics[1]; line.Time.Total != 11.5 {
+		t.Errorf("second line at %v, want 11.5", line.Time.Total)
+	}
+
+	server = newStandInServer(t, nil, "getLyricsBySongId")
+	defer server.Close()
+	track.client = sourcetest.NewClient(t, NewClient, testConfig(server.URL, testPassword)).(*Client)
+	if lyrics, err = track.GetLyrics(); err != nil || lyrics.RawLyrics != "Blue skies\nNothing but blue skies" || len(lyrics.SyncedLyrics) != 0 {
+		t.Errorf("GetLyrics() without OpenSubsonic = %+v, %v", lyrics, err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+//BufferedReadSeeker represents a buffered seekable buffer which allows io.ReadCloser to be seeked.
+//BufferedReadSeeker will read the stream as needed and keep it in memory until closed and does not support io.SeekEnd
+type BufferedReadSeeker struct {
+	r   io.ReadCloser
+	buf bytes.Buffer
+	cur int64
+	len int
+	err error
+}
+
+//Seek seeks BufferedReadSeeker to the provided location, io.SeekEnd is not supported
+func (s *BufferedReadSeeker) Seek(offset int64, whence int) (npos int64, err error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return s.cur, nil
+	}
+	npos = s.cur
+	var np int64
+	switch whence {
+	case io.SeekEnd:
+		err = errors.WithStack(errors.New("SeekEnd not supported on BufferedReadSeeker"))
+		return
+	case io.SeekCurrent:
+		np = s.cur + offset
+	case io.SeekStart:
+		np = offset
+	}
+	if np < 0 {
+		err = errors.WithStack(errors.New("Invalid seek"))
+		return
+	} else if np > int64(s.len) {
+		_, err = s.Read(make([]byte, np-int64(s.len)))
+	}
+	if err == nil {
+		s.cur = np
+	}
+	npos = s.cur
+	return
+}
+func (s *BufferedReadSeeker) Read(p []byte) (n int, err error) {
+	if s.cur+int64(len(p)) > int64(s.len) && s.err == nil {
+		nb := make([]byte, s.cur+int64(len(p))-int64(s.len))
+		var n int
+		n, s.err = io.ReadAtLeast(s.r, nb, len(nb))
+		nb = nb[:n]
+		s.buf.Write(nb)
+		s.len = s.buf.Len()
+	}
+	n = copy(p, s.buf.Bytes()[s.cur:])
+	if n < len(p) || s.err != nil {
+		if s.err == nil {
+			s.err = io.EOF
+		}
+		err = s.err
+	}
+	s.cur += int64(n)
+	return
+}
+
+//Close closes the underlying ReadCloser
+func (s *BufferedReadSeeker) Close() (err error) {
+	err = s.r.Close()
+	s.err = io.EOF
+	return
+}
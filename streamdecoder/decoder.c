@@ -1,3 +1,6 @@
+//go:build !nolibav
+// +build !nolibav
+
 /*
  * MusicStream - Listen to music together with your friends from everywhere, at the same time.
  * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
@@ -33,10 +36,12 @@ struct GoSlice {
 };
 
 typedef int (*read_callback)(void *opaque, void *buf, int buf_size);
+typedef int64_t (*seek_callback)(void *opaque, int64_t offset, int whence);
 
 typedef struct Decoder {
     void *opaque;
     read_callback read_cb;
+    seek_callback seek_cb;
     AVIOContext *input_ioctx;
     AVFormatContext *container;
     AVCodecContext *ctx;
@@ -109,7 +114,14 @@ static int decoder_in(void *opaque, uint8_t *buf, int buf_size)
     return ret;
 }
 
-static Decoder *decoder_new(void *opaque, read_callback read_cb)
+static int64_t decoder_seek(void *opaque, int64_t offset, int whence)
+{
+    Decoder *dec = (Decoder *)opaque;
+    return dec->seek_cb(dec->opaque, offset, whence);
+}
+
+// decoder_new creates a new decoder, seek_cb and format_name are optional
+static Decoder *decoder_new(void *opaque, read_callback read_cb, seek_callback seek_cb, const char *format_name)
 {
     Decoder *dec = (Decoder *)calloc(1, sizeof(Decoder));
     if (!dec) {
@@ -117,12 +129,13 @@ static Decoder *decoder_new(void *opaque, read_callback read_cb)
     }
     dec->opaque = opaque;
     dec->read_cb = read_cb;
+    dec->seek_cb = seek_cb;
     unsigned char *fileStreamBuffer = (unsigned char*)av_malloc(8192);
     if (!fileStreamBuffer) {
         goto cleanup_2;
     }
-    AVIOContext *input_ioctx = avio_alloc_context(fileStreamBuffer, 8192, 
-                                                  0, dec, decoder_in, NULL, NULL);
+    AVIOContext *input_ioctx = avio_alloc_context(fileStreamBuffer, 8192,
+                                                  0, dec, decoder_in, NULL, seek_cb ? decoder_seek : NULL);
     if (!input_ioctx) {
         goto cleanup_3;
     }
@@ -132,7 +145,11 @@ static Decoder *decoder_new(void *opaque, read_callback read_cb)
     }
     container->pb = input_ioctx;
     container->flags |= AVFMT_FLAG_CUSTOM_IO;
-    if (avformat_open_input(&container, NULL, NULL, NULL) < 0) {
+    AVInputFormat *input_format = NULL;
+    if (format_name && *format_name) {
+        input_format = (AVInputFormat *)av_find_input_format(format_name);
+    }
+    if (avformat_open_input(&container, NULL, input_format, NULL) < 0) {
         goto cleanup_4;
     }
     if (avformat_find_stream_info(container, NULL) < 0) {
@@ -236,6 +253,26 @@ cleanup_1:
     return NULL;
 }
 
+// decoder_tag returns the tag after prev of the container, or of the audio stream if stream is set
+static AVDictionaryEntry *decoder_tag(Decoder *dec, int stream, AVDictionaryEntry *prev)
+{
+    AVDictionary *metadata = stream ? dec->container->streams[dec->stream_id]->metadata : dec->container->metadata;
+    return av_dict_get(metadata, "", prev, AV_DICT_IGNORE_SUFFIX);
+}
+
+// decoder_duration returns the duration of the input in microseconds, or -1 if unknown
+static int64_t decoder_duration(Decoder *dec)
+{
+    if (dec->container->duration != AV_NOPTS_VALUE && dec->container->duration > 0) {
+        return dec->container->duration;
+    }
+    AVStream *stream = dec->container->streams[dec->stream_id];
+    if (stream->duration != AV_NOPTS_VALUE && stream->duration > 0) {
+        return av_rescale_q(stream->duration, stream->time_base, AV_TIME_BASE_Q);
+    }
+    return -1;
+}
+
 static void decoder_close(Decoder *dec)
 {
     av_freep(&dec->decoded_buffer);
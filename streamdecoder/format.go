@@ -0,0 +1,178 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Audio formats which can be decoded without libav
+const (
+	FormatUnknown = ""
+	FormatMP3     = "mp3"
+	FormatFLAC    = "flac"
+	FormatVorbis  = "vorbis"
+	FormatWAV     = "wav"
+)
+
+//Options contains hints about the stream to be decoded, zero values mean unknown
+type Options struct {
+	//Format is the container/codec of the stream, e.g. FormatMP3 or any libav input format name
+	Format string
+	//ContentType is the MIME type of the stream, used if Format is empty
+	ContentType string
+	//ContentLength is the size of the stream in bytes
+	ContentLength int64
+	//Seekable specifies that the stream implements io.Seeker and can be seeked from its start
+	Seekable bool
+	//PreferNative decodes the stream in pure Go if its format is supported
+	PreferNative bool
+}
+
+var contentTypes = map[string]string{
+	"audio/mpeg":       FormatMP3,
+	"audio/mp3":        FormatMP3,
+	"audio/flac":       FormatFLAC,
+	"audio/x-flac":     FormatFLAC,
+	"audio/ogg":        "ogg",
+	"audio/vorbis":     FormatVorbis,
+	"audio/wav":        FormatWAV,
+	"audio/x-wav":      FormatWAV,
+	"audio/wave":       FormatWAV,
+	"audio/aac":        "aac",
+	"audio/mp4":        "mp4",
+	"audio/x-m4a":      "mp4",
+	"audio/webm":       "webm",
+	"video/webm":       "webm",
+	"video/mp4":        "mp4",
+	"audio/x-matroska": "matroska",
+}
+
+//FormatFromContentType returns the format of a MIME type, or FormatUnknown
+func FormatFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatUnknown
+	}
+	return contentTypes[strings.ToLower(mediaType)]
+}
+
+//libavFormatName returns the name of libav's input format of the provided format
+func libavFormatName(format string) string {
+	switch format {
+	case FormatVorbis, "opus":
+		return "ogg"
+	}
+	return format
+}
+
+//isNative reports whether format can be decoded without libav
+func isNative(format string) bool {
+	switch format {
+	case FormatMP3, FormatFLAC, FormatVorbis, FormatWAV:
+		return true
+	}
+	return false
+}
+
+//Sniff detects the format of an audio stream from its first bytes
+func Sniff(header []byte) string {
+	switch {
+	case len(header) >= 4 && string(header[:4]) == "fLaC":
+		return FormatFLAC
+	case len(header) >= 35 && string(header[:4]) == "OggS" && string(header[28:35]) == "\x01vorbis":
+		return FormatVorbis
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return FormatWAV
+	case len(header) >= 3 && string(header[:3]) == "ID3":
+		return FormatMP3
+	case len(header) >= 3 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0 && header[2]&0xF0 != 0xF0:
+		return FormatMP3
+	}
+	return FormatUnknown
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+//NewDecoder returns a s16le/48khz PCM stream decoded from stream.
+//If opts does not specify the format, it is detected from the stream's content,
+//which is also sniffed if its content type is a container that may hold a natively supported codec, e.g. Ogg.
+//The stream is decoded in pure Go if its format is supported and either opts.PreferNative is set or libav is not available,
+//otherwise libav is used
+func NewDecoder(stream io.ReadCloser, opts Options) (io.ReadCloser, error) {
+	sniff := opts.Format == FormatUnknown
+	if sniff && len(opts.ContentType) > 0 {
+		opts.Format = FormatFromContentType(opts.ContentType)
+		sniff = !isNative(opts.Format)
+	}
+	if sniff {
+		var header []byte
+		if seeker, ok := stream.(io.ReadSeeker); ok && opts.Seekable {
+			header = make([]byte, 64)
+			n, _ := io.ReadFull(seeker, header)
+			header = header[:n]
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		} else {
+			r := bufio.NewReader(stream)
+			header, _ = r.Peek(64)
+			stream = &readCloser{Reader: r, Closer: stream}
+		}
+		if format := Sniff(header); format != FormatUnknown {
+			opts.Format = format
+		}
+	}
+	if isNative(opts.Format) && (opts.PreferNative || !LibavAvailable) {
+		decoder, err := NewNativeDecoder(stream, opts.Format)
+		if err != nil {
+			return nil, err
+		}
+		return decoder, nil
+	}
+	decoder, err := NewAVDecoderWithOptions(stream, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decoder, nil
+}
+
+//NewPCMDecoder returns a s16le/48khz stereo PCM stream resampled from a s16le PCM stream with the provided sample rate and number of channels
+func NewPCMDecoder(stream io.ReadCloser, sampleRate, channels int) (*NativeDecoder, error) {
+	if sampleRate <= 0 || channels <= 0 {
+		return nil, errors.WithStack(errors.New("invalid stream parameters"))
+	}
+	src := &wavReader{
+		r:             stream,
+		format:        wavFormatPCM,
+		channels:      channels,
+		sampleRate:    sampleRate,
+		bytesPerFrame: 2 * channels,
+		bitsPerSample: 16,
+	}
+	return newNativeDecoder(stream, src), nil
+}
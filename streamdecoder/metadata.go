@@ -0,0 +1,92 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2020 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+//Metadata contains the tags and the duration of an audio stream, zero values mean unknown
+type Metadata struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+	//Tags contains all tags of the stream, by their lowercased names
+	Tags map[string]string
+}
+
+//MetadataDecoder is a decoder which exposes the metadata of its stream
+type MetadataDecoder interface {
+	io.ReadCloser
+	Metadata() Metadata
+}
+
+//metadataReader is a sampleReader which knows the metadata of its stream
+type metadataReader interface {
+	metadata() Metadata
+}
+
+//addTag adds a tag to tags, the different values of a repeated tag are comma-separated
+func addTag(tags map[string]string, name, value string) {
+	name = strings.ToLower(name)
+	if existing, ok := tags[name]; ok {
+		for _, v := range strings.Split(existing, ", ") {
+			if v == value {
+				return
+			}
+		}
+		value = existing + ", " + value
+	}
+	tags[name] = value
+}
+
+//newMetadata returns the Metadata of a stream from its lowercased tags
+func newMetadata(tags map[string]string, duration time.Duration) Metadata {
+	m := Metadata{
+		Title:    tags["title"],
+		Artist:   tags["artist"],
+		Album:    tags["album"],
+		Duration: duration,
+		Tags:     tags,
+	}
+	for _, name := range []string{"album_artist", "albumartist"} {
+		if len(m.Artist) == 0 {
+			m.Artist = tags[name]
+		}
+	}
+	return m
+}
+
+//Probe returns the metadata of stream, which is closed afterwards.
+//Only the beginning of the stream is read
+func Probe(stream io.ReadCloser, opts Options) (Metadata, error) {
+	decoder, err := NewDecoder(stream, opts)
+	if err != nil {
+		stream.Close()
+		return Metadata{}, err
+	}
+	defer decoder.Close()
+	if mdecoder, ok := decoder.(MetadataDecoder); ok {
+		return mdecoder.Metadata(), nil
+	}
+	return Metadata{}, nil
+}
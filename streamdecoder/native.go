@@ -0,0 +1,294 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+	"github.com/pkg/errors"
+)
+
+const outputSampleRate = 48000
+
+//sampleReader reads interleaved float samples in the range [-1, 1]
+type sampleReader interface {
+	SampleRate() int
+	Channels() int
+	ReadSamples(p []float32) (n int, err error)
+}
+
+//NativeDecoder is a pure Go audio decoder which outputs s16le/48khz stereo PCM
+type NativeDecoder struct {
+	r      io.ReadCloser
+	src    sampleReader
+	buf    []float32
+	frames []float32
+	pos    float64
+	step   float64
+	out    []byte
+	err    error
+}
+
+//NewNativeDecoder returns a s16le/48khz PCM stream decoded from a stream of the provided format
+func NewNativeDecoder(stream io.ReadCloser, format string) (decoder *NativeDecoder, err error) {
+	var src sampleReader
+	r := bufio.NewReader(stream)
+	switch format {
+	case FormatMP3:
+		src, err = newMP3Reader(r)
+	case FormatFLAC:
+		src, err = newFLACReader(r)
+	case FormatVorbis:
+		src, err = newVorbisReader(r)
+	case FormatWAV:
+		src, err = newWAVReader(r)
+	default:
+		err = errors.Errorf("unsupported format: %q", format)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if src.SampleRate() <= 0 || src.Channels() <= 0 {
+		return nil, errors.WithStack(errors.New("invalid stream parameters"))
+	}
+	return newNativeDecoder(stream, src), nil
+}
+
+func newNativeDecoder(stream io.ReadCloser, src sampleReader) *NativeDecoder {
+	return &NativeDecoder{
+		r:    stream,
+		src:  src,
+		buf:  make([]float32, 4096*src.Channels()),
+		step: float64(src.SampleRate()) / outputSampleRate,
+	}
+}
+
+//fill reads more samples from the source, converted to stereo
+func (d *NativeDecoder) fill() {
+	n, err := d.src.ReadSamples(d.buf)
+	channels := d.src.Channels()
+	for i := 0; i+channels <= n; i += channels {
+		left := d.buf[i]
+		right := left
+		if channels > 1 {
+			right = d.buf[i+1]
+		}
+		d.frames = append(d.frames, left, right)
+	}
+	if err != nil {
+		d.err = err
+	}
+}
+
+func (d *NativeDecoder) Read(p []byte) (n int, err error) {
+	for len(d.out) < len(p) {
+		i := int(d.pos)
+		if 2*(i+2) > len(d.frames) {
+			if d.err != nil {
+				break
+			}
+			d.fill()
+			continue
+		}
+		frac := float32(d.pos - float64(i))
+		for c := 0; c < 2; c++ {
+			a, b := d.frames[2*i+c], d.frames[2*(i+1)+c]
+			d.out = append(d.out, 0, 0)
+			binary.LittleEndian.PutUint16(d.out[len(d.out)-2:], uint16(toInt16(a+(b-a)*frac)))
+		}
+		d.pos += d.step
+		if consumed := int(d.pos); consumed > 0 && 2*consumed >= len(d.frames)/2 {
+			d.frames = append(d.frames[:0], d.frames[2*consumed:]...)
+			d.pos -= float64(consumed)
+		}
+	}
+	n = copy(p, d.out)
+	d.out = append(d.out[:0], d.out[n:]...)
+	if n == 0 && d.err != nil {
+		err = d.err
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	}
+	return
+}
+
+//Metadata returns the tags and the duration of the stream, if its format provides them
+func (d *NativeDecoder) Metadata() Metadata {
+	if src, ok := d.src.(metadataReader); ok {
+		return src.metadata()
+	}
+	return Metadata{}
+}
+
+//Close closes the underlying ReadCloser
+func (d *NativeDecoder) Close() error {
+	if closer, ok := d.src.(io.Closer); ok {
+		closer.Close()
+	}
+	return d.r.Close()
+}
+
+func toInt16(v float32) int16 {
+	v *= 32768
+	switch {
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	case v < math.MinInt16:
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+type mp3Reader struct {
+	dec *mp3.Decoder
+	buf []byte
+}
+
+func newMP3Reader(r io.Reader) (*mp3Reader, error) {
+	dec, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	return &mp3Reader{dec: dec}, nil
+}
+
+func (r *mp3Reader) SampleRate() int {
+	return r.dec.SampleRate()
+}
+
+//Channels is always 2 as go-mp3 always outputs stereo
+func (r *mp3Reader) Channels() int {
+	return 2
+}
+
+func (r *mp3Reader) ReadSamples(p []float32) (n int, err error) {
+	if cap(r.buf) < 2*len(p) {
+		r.buf = make([]byte, 2*len(p))
+	}
+	m, err := io.ReadFull(r.dec, r.buf[:2*len(p)])
+	for n = 0; 2*n+1 < m; n++ {
+		p[n] = float32(int16(binary.LittleEndian.Uint16(r.buf[2*n:]))) / 32768
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+type vorbisReader struct {
+	*oggvorbis.Reader
+}
+
+func newVorbisReader(r io.Reader) (*vorbisReader, error) {
+	reader, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &vorbisReader{reader}, nil
+}
+
+func (r *vorbisReader) metadata() Metadata {
+	tags := make(map[string]string)
+	for _, comment := range r.CommentHeader().Comments {
+		if i := strings.IndexByte(comment, '='); i > 0 {
+			addTag(tags, comment[:i], comment[i+1:])
+		}
+	}
+	var duration time.Duration
+	if length := r.Length(); length > 0 {
+		duration = time.Duration(length) * time.Second / time.Duration(r.SampleRate())
+	}
+	return newMetadata(tags, duration)
+}
+
+func (r *vorbisReader) ReadSamples(p []float32) (n int, err error) {
+	n, err = r.Read(p[:len(p)-len(p)%r.Channels()])
+	return
+}
+
+type flacReader struct {
+	stream  *flac.Stream
+	pending []float32
+}
+
+func newFLACReader(r io.Reader) (*flacReader, error) {
+	stream, err := flac.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &flacReader{stream: stream}, nil
+}
+
+func (r *flacReader) SampleRate() int {
+	return int(r.stream.Info.SampleRate)
+}
+
+func (r *flacReader) Channels() int {
+	return int(r.stream.Info.NChannels)
+}
+
+func (r *flacReader) metadata() Metadata {
+	tags := make(map[string]string)
+	for _, block := range r.stream.Blocks {
+		if comment, ok := block.Body.(*meta.VorbisComment); ok {
+			for _, tag := range comment.Tags {
+				addTag(tags, tag[0], tag[1])
+			}
+		}
+	}
+	var duration time.Duration
+	if info := r.stream.Info; info.NSamples > 0 && info.SampleRate > 0 {
+		duration = time.Duration(info.NSamples) * time.Second / time.Duration(info.SampleRate)
+	}
+	return newMetadata(tags, duration)
+}
+
+func (r *flacReader) ReadSamples(p []float32) (n int, err error) {
+	for len(r.pending) == 0 {
+		f, err := r.stream.ParseNext()
+		if err != nil {
+			return 0, err
+		}
+		bps := f.BitsPerSample
+		if bps == 0 {
+			bps = r.stream.Info.BitsPerSample
+		}
+		scale := float32(int64(1) << (bps - 1))
+		channels := len(f.Subframes)
+		r.pending = make([]float32, 0, channels*int(f.BlockSize))
+		for i := 0; i < int(f.BlockSize); i++ {
+			for c := 0; c < channels; c++ {
+				r.pending = append(r.pending, float32(f.Subframes[c].Samples[i])/scale)
+			}
+		}
+	}
+	n = copy(p[:len(p)-len(p)%r.Channels()], r.pending)
+	r.pending = r.pending[n:]
+	return
+}
@@ -0,0 +1,181 @@
+package streamdecoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func makeWAV(sampleRate, channels int, samples []int16) []byte {
+	var buf bytes.Buffer
+	dataSize := 2 * len(samples)
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(wavFormatPCM), uint16(channels), uint32(sampleRate),
+		uint32(sampleRate * channels * 2), uint16(channels * 2), uint16(16),
+	} {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	cases := map[string][]byte{
+		FormatFLAC:    []byte("fLaC\x00\x00\x00\x22"),
+		FormatWAV:     makeWAV(48000, 2, nil),
+		FormatMP3:     []byte("ID3\x04\x00"),
+		FormatUnknown: []byte("<html>"),
+	}
+	cases[FormatVorbis] = append(append([]byte("OggS"), make([]byte, 24)...), []byte("\x01vorbis")...)
+	for expected, header := range cases {
+		if format := Sniff(header); format != expected {
+			t.Errorf("Sniff() = %q, want %q", format, expected)
+		}
+	}
+	if format := Sniff([]byte{0xFF, 0xFB, 0x90, 0x00}); format != FormatMP3 {
+		t.Errorf("Sniff(MPEG frame) = %q, want %q", format, FormatMP3)
+	}
+	if format := Sniff([]byte{0xFF, 0xF1, 0x50, 0x80}); format != FormatUnknown {
+		t.Errorf("Sniff(ADTS frame) = %q, want %q", format, FormatUnknown)
+	}
+}
+
+func TestNativeDecoderWAV(t *testing.T) {
+	samples := make([]int16, 48000)
+	for i := range samples {
+		samples[i] = int16(i % 1000)
+	}
+	decoder, err := NewDecoder(ioutil.NopCloser(bytes.NewReader(makeWAV(48000, 1, samples))), Options{PreferNative: true})
+	if err != nil {
+		t.Fatal("NewDecoder: ", err)
+	}
+	defer decoder.Close()
+	pcm, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	if len(pcm) < 4*(len(samples)-1) || len(pcm) > 4*len(samples) {
+		t.Fatalf("len(pcm) = %d, want about %d", len(pcm), 4*len(samples))
+	}
+	for i := 0; i < 100; i++ {
+		left := int16(binary.LittleEndian.Uint16(pcm[4*i:]))
+		right := int16(binary.LittleEndian.Uint16(pcm[4*i+2:]))
+		if left != samples[i] || right != samples[i] {
+			t.Fatalf("frame %d = (%d, %d), want (%d, %d)", i, left, right, samples[i], samples[i])
+		}
+	}
+}
+
+func TestNativeDecoderResample(t *testing.T) {
+	samples := make([]int16, 2*24000)
+	decoder, err := NewNativeDecoder(ioutil.NopCloser(bytes.NewReader(makeWAV(24000, 2, samples))), FormatWAV)
+	if err != nil {
+		t.Fatal("NewNativeDecoder: ", err)
+	}
+	pcm, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	if frames := len(pcm) / 4; frames < 47990 || frames > 48000 {
+		t.Errorf("resampled to %d frames, want about 48000", frames)
+	}
+}
+
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+func TestNewDecoderSeekable(t *testing.T) {
+	samples := make([]int16, 2*4800)
+	stream := readSeekCloser{bytes.NewReader(makeWAV(48000, 2, samples))}
+	decoder, err := NewDecoder(stream, Options{PreferNative: true, Seekable: true})
+	if err != nil {
+		t.Fatal("NewDecoder: ", err)
+	}
+	pcm, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	if len(pcm) < 2*len(samples)-4 || len(pcm) > 2*len(samples) {
+		t.Errorf("len(pcm) = %d, want about %d", len(pcm), 2*len(samples))
+	}
+}
+
+func TestNewDecoderSniffsContainer(t *testing.T) {
+	stream := ioutil.NopCloser(bytes.NewReader(makeWAV(48000, 2, make([]int16, 960))))
+	decoder, err := NewDecoder(stream, Options{ContentType: "audio/ogg", PreferNative: true})
+	if err != nil {
+		t.Fatal("NewDecoder: ", err)
+	}
+	defer decoder.Close()
+	if _, ok := decoder.(*NativeDecoder); !ok {
+		t.Errorf("a natively supported stream served as audio/ogg should be decoded natively, got %T", decoder)
+	}
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	tests := map[string]string{
+		"audio/mpeg":               FormatMP3,
+		"audio/flac; charset=utf8": FormatFLAC,
+		"Audio/X-WAV":              FormatWAV,
+		"audio/webm":               "webm",
+		"text/html":                FormatUnknown,
+		"":                         FormatUnknown,
+	}
+	for contentType, want := range tests {
+		if format := FormatFromContentType(contentType); format != want {
+			t.Errorf("FormatFromContentType(%q) = %q, want %q", contentType, format, want)
+		}
+	}
+}
+
+func TestPCMDecoder(t *testing.T) {
+	pcm := make([]byte, 2*44100)
+	decoder, err := NewPCMDecoder(ioutil.NopCloser(bytes.NewReader(pcm)), 44100, 1)
+	if err != nil {
+		t.Fatal("NewPCMDecoder: ", err)
+	}
+	out, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	if frames := len(out) / 4; frames < 47990 || frames > 48000 {
+		t.Errorf("resampled to %d frames, want about 48000", frames)
+	}
+}
+
+func TestProbeWAV(t *testing.T) {
+	samples := make([]int16, 2*24000)
+	metadata, err := Probe(ioutil.NopCloser(bytes.NewReader(makeWAV(24000, 2, samples))), Options{PreferNative: true})
+	if err != nil {
+		t.Fatal("Probe: ", err)
+	}
+	if metadata.Duration != time.Second {
+		t.Errorf("Duration = %v, want 1s", metadata.Duration)
+	}
+}
+
+func TestNewMetadata(t *testing.T) {
+	tags := make(map[string]string)
+	addTag(tags, "TITLE", "Song")
+	addTag(tags, "ALBUMARTIST", "Band")
+	addTag(tags, "Genre", "Pop")
+	addTag(tags, "GENRE", "Rock")
+	addTag(tags, "genre", "Rock")
+	metadata := newMetadata(tags, 0)
+	if metadata.Title != "Song" || metadata.Artist != "Band" {
+		t.Errorf("metadata = %+v, want title Song by Band", metadata)
+	}
+	if genre := metadata.Tags["genre"]; genre != "Pop, Rock" {
+		t.Errorf("genre = %q, want %q", genre, "Pop, Rock")
+	}
+}
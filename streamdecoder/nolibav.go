@@ -0,0 +1,51 @@
+//go:build nolibav
+// +build nolibav
+
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+//LibavAvailable reports whether the libav decoder is built in
+const LibavAvailable = false
+
+//AVDecoder is not available when built with the nolibav tag
+type AVDecoder struct {
+	io.ReadCloser
+}
+
+//Metadata is always empty when built with the nolibav tag
+func (d *AVDecoder) Metadata() Metadata {
+	return Metadata{}
+}
+
+//NewAVDecoder always fails when built with the nolibav tag
+func NewAVDecoder(stream io.ReadCloser) (decoder *AVDecoder, err error) {
+	return NewAVDecoderWithOptions(stream, Options{})
+}
+
+//NewAVDecoderWithOptions always fails when built with the nolibav tag
+func NewAVDecoderWithOptions(stream io.ReadCloser, opts Options) (decoder *AVDecoder, err error) {
+	return nil, errors.WithStack(errors.New("libav decoder is not available in this build"))
+}
@@ -1,3 +1,6 @@
+//go:build !nolibav
+// +build !nolibav
+
 /*
  * MusicStream - Listen to music together with your friends from everywhere, at the same time.
  * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
@@ -22,26 +25,32 @@ package streamdecoder
 #include "decoder.c"
 
 int decoderIn(void *opaque, void *buf, int buf_size);
+int64_t decoderSeek(void *opaque, int64_t offset, int whence);
 #cgo pkg-config: libavcodec libavformat libavutil libswresample
 */
 import "C"
 
 import (
-	"bytes"
 	"io"
+	"time"
 	"unsafe"
 
 	pointer "github.com/mattn/go-pointer"
 	"github.com/pkg/errors"
 )
 
+//LibavAvailable reports whether the libav decoder is built in
+const LibavAvailable = true
+
 //AVDecoder is a Audio Decoder, backed by libav
 type AVDecoder struct {
-	r       io.ReadCloser
-	p       unsafe.Pointer
-	dec     *C.struct_Decoder
-	err     error
-	errRead error
+	r             io.ReadCloser
+	seeker        io.Seeker
+	contentLength int64
+	p             unsafe.Pointer
+	dec           *C.struct_Decoder
+	err           error
+	errRead       error
 }
 
 func (d *AVDecoder) Read(p []byte) (n int, err error) {
@@ -56,6 +65,28 @@ func (d *AVDecoder) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+//Metadata returns the tags of the container and its audio stream, and the duration of the input
+func (d *AVDecoder) Metadata() Metadata {
+	tags := make(map[string]string)
+	if d.dec == nil {
+		return newMetadata(tags, 0)
+	}
+	for stream := 0; stream < 2; stream++ {
+		var entry *C.AVDictionaryEntry
+		for {
+			if entry = C.decoder_tag(d.dec, C.int(stream), entry); entry == nil {
+				break
+			}
+			addTag(tags, C.GoString(entry.key), C.GoString(entry.value))
+		}
+	}
+	var duration time.Duration
+	if us := int64(C.decoder_duration(d.dec)); us > 0 {
+		duration = time.Duration(us) * time.Microsecond
+	}
+	return newMetadata(tags, duration)
+}
+
 func (d *AVDecoder) Close() (err error) {
 	C.decoder_close(d.dec)
 	d.dec = nil
@@ -75,79 +106,46 @@ func decoderIn(opaque unsafe.Pointer, buf unsafe.Pointer, buf_size C.int) C.int
 	return C.int(n)
 }
 
-//NewAVDecoder returns a s16le/48khz PCM stream decoded from ffmpeg
+//export decoderSeek
+func decoderSeek(opaque unsafe.Pointer, offset C.int64_t, whence C.int) C.int64_t {
+	d := pointer.Restore(opaque).(*AVDecoder)
+	if whence&C.AVSEEK_SIZE != 0 {
+		if d.contentLength > 0 {
+			return C.int64_t(d.contentLength)
+		}
+		return -1
+	}
+	pos, err := d.seeker.Seek(int64(offset), int(whence&^C.AVSEEK_FORCE))
+	if err != nil {
+		return -1
+	}
+	d.err = nil
+	return C.int64_t(pos)
+}
+
+//NewAVDecoder returns a s16le/48khz PCM stream decoded from ffmpeg, the stream is seeked if it implements io.Seeker
 func NewAVDecoder(stream io.ReadCloser) (decoder *AVDecoder, err error) {
+	return NewAVDecoderWithOptions(stream, Options{Seekable: true})
+}
+
+//NewAVDecoderWithOptions returns a s16le/48khz PCM stream decoded from ffmpeg.
+//opts.Format is used as libav's input format to skip probing, and the stream is seeked if opts.Seekable is set and it implements io.Seeker
+func NewAVDecoderWithOptions(stream io.ReadCloser, opts Options) (decoder *AVDecoder, err error) {
 	decoder = &AVDecoder{}
 	decoder.r = stream
+	decoder.contentLength = opts.ContentLength
 	decoder.p = pointer.Save(decoder)
-	decoder.dec = C.decoder_new(decoder.p, C.read_callback(C.decoderIn))
+	var seekCallback C.seek_callback
+	if seeker, ok := stream.(io.Seeker); ok && opts.Seekable {
+		decoder.seeker = seeker
+		seekCallback = C.seek_callback(C.decoderSeek)
+	}
+	formatName := C.CString(libavFormatName(opts.Format))
+	defer C.free(unsafe.Pointer(formatName))
+	decoder.dec = C.decoder_new(decoder.p, C.read_callback(C.decoderIn), seekCallback, formatName)
 	if decoder.dec == nil {
+		pointer.Unref(decoder.p)
 		return nil, errors.New("Failed to initialize C decoder")
 	}
 	return decoder, nil
 }
-
-//BufferedReadSeeker represents a buffered seekable buffer which allows io.ReadCloser to be seeked.
-//BufferedReadSeeker will read the stream as needed and keep it in memory until closed and does not support io.SeekEnd
-type BufferedReadSeeker struct {
-	r   io.ReadCloser
-	buf bytes.Buffer
-	cur int64
-	len int
-	err error
-}
-
-//Seek seeks BufferedReadSeeker to the provided location, io.SeekEnd is not supported
-func (s *BufferedReadSeeker) Seek(offset int64, whence int) (npos int64, err error) {
-	if offset == 0 && whence == io.SeekCurrent {
-		return s.cur, nil
-	}
-	npos = s.cur
-	var np int64
-	switch whence {
-	case io.SeekEnd:
-		err = errors.WithStack(errors.New("SeekEnd not supported on BufferedReadSeeker"))
-		return
-	case io.SeekCurrent:
-		np = s.cur + offset
-	case io.SeekStart:
-		np = offset
-	}
-	if np < 0 {
-		err = errors.WithStack(errors.New("Invalid seek"))
-		return
-	} else if np > int64(s.len) {
-		_, err = s.Read(make([]byte, np-int64(s.len)))
-	}
-	if err == nil {
-		s.cur = np
-	}
-	npos = s.cur
-	return
-}
-func (s *BufferedReadSeeker) Read(p []byte) (n int, err error) {
-	if s.cur+int64(len(p)) > int64(s.len) && s.err == nil {
-		nb := make([]byte, s.cur+int64(len(p))-int64(s.len))
-		var n int
-		n, s.err = io.ReadAtLeast(s.r, nb, len(nb))
-		nb = nb[:n]
-		s.buf.Write(nb)
-		s.len = s.buf.Len()
-	}
-	n = copy(p, s.buf.Bytes()[s.cur:])
-	if n < len(p) || s.err != nil {
-		if s.err == nil {
-			s.err = io.EOF
-		}
-		err = s.err
-	}
-	s.cur += int64(n)
-	return
-}
-
-//Close closes the underlying ReadCloser
-func (s *BufferedReadSeeker) Close() (err error) {
-	err = s.r.Close()
-	s.err = io.EOF
-	return
-}
@@ -0,0 +1,160 @@
+/*
+ * MusicStream - Listen to music together with your friends from everywhere, at the same time.
+ * Copyright (C) 2021 Nguyễn Hoàng Trung(TrungNguyen1909)
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package streamdecoder
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatIEEEFloat  = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+//wavReader reads PCM samples from a RIFF/WAVE stream
+type wavReader struct {
+	r             io.Reader
+	format        uint16
+	channels      int
+	sampleRate    int
+	bytesPerFrame int
+	bitsPerSample int
+	//dataSize is the size of the data chunk in bytes, 0 if unknown
+	dataSize int64
+	buf      []byte
+}
+
+func newWAVReader(r io.Reader) (*wavReader, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, errors.New("not a WAVE stream")
+	}
+	w := &wavReader{}
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		switch string(chunkHeader[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("invalid fmt chunk")
+			}
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, err
+			}
+			w.format = binary.LittleEndian.Uint16(fmtChunk[0:])
+			w.channels = int(binary.LittleEndian.Uint16(fmtChunk[2:]))
+			w.sampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:]))
+			w.bytesPerFrame = int(binary.LittleEndian.Uint16(fmtChunk[12:]))
+			w.bitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:]))
+			if w.format == wavFormatExtensible && size >= 26 {
+				w.format = binary.LittleEndian.Uint16(fmtChunk[24:])
+			}
+		case "data":
+			if w.channels == 0 {
+				return nil, errors.New("data chunk before fmt chunk")
+			}
+			if err := w.validate(); err != nil {
+				return nil, err
+			}
+			w.r = io.LimitReader(r, size)
+			if size < math.MaxUint32 {
+				w.dataSize = size
+			}
+			return w, nil
+		default:
+			if _, err := io.CopyN(ioutil.Discard, r, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (w *wavReader) validate() error {
+	switch {
+	case w.format == wavFormatPCM && (w.bitsPerSample == 8 || w.bitsPerSample == 16 || w.bitsPerSample == 24 || w.bitsPerSample == 32):
+	case w.format == wavFormatIEEEFloat && (w.bitsPerSample == 32 || w.bitsPerSample == 64):
+	default:
+		return errors.Errorf("unsupported WAVE format %d with %d bits per sample", w.format, w.bitsPerSample)
+	}
+	if w.bytesPerFrame != w.channels*w.bitsPerSample/8 {
+		return errors.New("invalid WAVE block align")
+	}
+	return nil
+}
+
+func (w *wavReader) metadata() Metadata {
+	var duration time.Duration
+	if w.dataSize > 0 && w.bytesPerFrame > 0 && w.sampleRate > 0 {
+		duration = time.Duration(w.dataSize/int64(w.bytesPerFrame)) * time.Second / time.Duration(w.sampleRate)
+	}
+	return newMetadata(make(map[string]string), duration)
+}
+
+func (w *wavReader) SampleRate() int {
+	return w.sampleRate
+}
+
+func (w *wavReader) Channels() int {
+	return w.channels
+}
+
+func (w *wavReader) ReadSamples(p []float32) (n int, err error) {
+	frames := len(p) / w.channels
+	size := frames * w.bytesPerFrame
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	m, err := io.ReadFull(w.r, w.buf[:size])
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	bytesPerSample := w.bitsPerSample / 8
+	for n = 0; (n+1)*bytesPerSample <= m-m%w.bytesPerFrame; n++ {
+		b := w.buf[n*bytesPerSample:]
+		switch {
+		case w.format == wavFormatIEEEFloat && w.bitsPerSample == 32:
+			p[n] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case w.format == wavFormatIEEEFloat:
+			p[n] = float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		case w.bitsPerSample == 8:
+			p[n] = float32(int(b[0])-128) / 128
+		case w.bitsPerSample == 16:
+			p[n] = float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		case w.bitsPerSample == 24:
+			p[n] = float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		default:
+			p[n] = float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}
+	}
+	return
+}